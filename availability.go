@@ -0,0 +1,183 @@
+// Availability of approvers decided by a chain of providers, such as the slack status, a static list in the
+// configuration or a shared leave calendar. Each provider answers for the users it knows about and the answers are
+// combined by the configured policy.
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xanzy/go-gitlab"
+)
+
+// AvailabilityProvider - source of approver availability
+type AvailabilityProvider interface {
+	Name() string
+	Availability(user *gitlab.BasicUser, now time.Time) (availability, error)
+}
+
+// availability: a providers answer for a user, known is false when the provider has no information about the user.
+// The ttl is how long the answer is expected to hold, zero when not known.
+type availability struct {
+	known     bool
+	available bool
+	reason    string
+	ttl       time.Duration
+}
+
+const (
+	providerSlack    = "slack"
+	providerStatic   = "static"
+	providerCalendar = "calendar"
+	providerGitlab   = "gitlab"
+)
+
+const (
+	// unavailable when any provider answers unavailable
+	policyAnyUnavailable = "any_unavailable"
+	// unavailable only when every provider knowing the user answers unavailable
+	policyAllUnavailable = "all_unavailable"
+	// the first provider knowing the user decides
+	policyFirstKnown = "first_known"
+)
+
+var defaultProviderOrder = []string{providerSlack, providerStatic, providerCalendar}
+
+// Availability - Providers consulted for approver availability in order, combined by the policy. Providers default to
+// slack then any static or calendar configuration, gitlab is only consulted when listed.
+type Availability struct {
+	Policy    string                        `yaml:"policy"`
+	Providers []string                      `yaml:"providers"`
+	Static    map[string]StaticAvailability `yaml:"static"`
+	Calendars []Calendar                    `yaml:"calendars"`
+}
+
+// validate: check the policy, provider names and each provider configuration
+func (a Availability) validate() error {
+	switch a.Policy {
+	case "", policyAnyUnavailable, policyAllUnavailable, policyFirstKnown:
+	default:
+		return fmt.Errorf("'%s' unknown availability policy.", a.Policy)
+	}
+
+	for _, name := range a.Providers {
+		switch name {
+		case providerSlack, providerStatic, providerCalendar, providerGitlab:
+		default:
+			return fmt.Errorf("'%s' unknown availability provider.", name)
+		}
+	}
+
+	for username, static := range a.Static {
+		if err := static.validate(); err != nil {
+			return fmt.Errorf("static: %s: %s", username, err)
+		}
+	}
+
+	for i, calendar := range a.Calendars {
+		if err := calendar.validate(); err != nil {
+			return fmt.Errorf("calendar: %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// newAvailabilityProviders: create a provider for each source set in the configuration, the slack and gitlab
+// providers are created when processing as they require the api clients
+func newAvailabilityProviders(availability Availability, fs fileSystem) ([]AvailabilityProvider, error) {
+	var providers []AvailabilityProvider
+	if len(availability.Static) > 0 {
+		provider, err := newStaticProvider(availability.Static)
+		if err != nil {
+			return nil, fmt.Errorf("static: %s", err)
+		}
+		providers = append(providers, provider)
+	}
+
+	for i, calendar := range availability.Calendars {
+		provider, err := newCalendarProvider(calendar, fs)
+		if err != nil {
+			return nil, fmt.Errorf("calendar: %d: %s", i, err)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// availabilityChain: providers consulted in order, answers combined by the policy
+type availabilityChain struct {
+	policy    string
+	providers []AvailabilityProvider
+}
+
+// availabilityChain: return the chain of providers in the configured order, the passed providers created when
+// processing take the place of their name in the order
+func (c Config) availabilityChain(processing ...AvailabilityProvider) availabilityChain {
+	order := c.Availability.Providers
+	if len(order) == 0 {
+		order = defaultProviderOrder
+	}
+
+	all := append(append([]AvailabilityProvider{}, processing...), c.providers...)
+	var providers []AvailabilityProvider
+	for _, name := range order {
+		for _, provider := range all {
+			if provider.Name() == name {
+				providers = append(providers, provider)
+			}
+		}
+	}
+
+	policy := c.Availability.Policy
+	if policy == "" {
+		policy = policyAnyUnavailable
+	}
+	return availabilityChain{policy: policy, providers: providers}
+}
+
+// check: return the combined availability of a user and the provider deciding it. A provider failing to answer
+// excludes the user, as availability can not be confirmed.
+func (ac availabilityChain) check(user *gitlab.BasicUser, mr MergeRequests, now time.Time) (string, availability, error) {
+	logger := log.WithFields(log.Fields{"group": mr.Group(), "project_id": mr.ProjectID(), "merge_request_id": mr.MergeReqID(), "username": user.Username})
+
+	type providerAnswer struct {
+		provider string
+		answer   availability
+	}
+
+	var answers []providerAnswer
+	for _, provider := range ac.providers {
+		answer, err := provider.Availability(user, now)
+		if err != nil {
+			promErrors.WithLabelValues("availability_" + provider.Name()).Inc()
+			return provider.Name(), availability{}, err
+		}
+		if !answer.known {
+			continue
+		}
+
+		if !answer.available {
+			promProviderUnavailable.WithLabelValues(provider.Name(), mr.Group()).Inc()
+			logger.WithFields(log.Fields{"provider": provider.Name(), "reason": answer.reason}).Debug("provider answered unavailable.")
+		}
+
+		// Later providers are not consulted once the answer is decided
+		if ac.policy == policyFirstKnown || (ac.policy == policyAnyUnavailable && !answer.available) {
+			return provider.Name(), answer, nil
+		}
+		answers = append(answers, providerAnswer{provider.Name(), answer})
+	}
+
+	if len(answers) == 0 {
+		return "", availability{available: true}, nil
+	}
+
+	// Every answer is available for any unavailable, for all unavailable one available answer makes the user available
+	for _, a := range answers {
+		if a.answer.available {
+			return a.provider, a.answer, nil
+		}
+	}
+	return answers[0].provider, answers[0].answer, nil
+}
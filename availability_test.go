@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+// Setup
+
+// mockProvider: answers by username, users without an answer are not known
+type mockProvider struct {
+	name    string
+	answers map[string]availability
+	calls   int
+}
+
+func (p *mockProvider) Name() string {
+	return p.name
+}
+
+func (p *mockProvider) Availability(user *gitlab.BasicUser, now time.Time) (availability, error) {
+	p.calls++
+	if user.Username == "error" {
+		return availability{}, errors.New("provider_error")
+	}
+	return p.answers[user.Username], nil
+}
+
+func unavailableAnswer(reason string) availability {
+	return availability{known: true, available: false, reason: reason}
+}
+
+func availableAnswer() availability {
+	return availability{known: true, available: true}
+}
+
+// Tests
+
+func TestAvailabilityChain(t *testing.T) {
+	mr := MergeRequest{group: "test", projectID: 1, mergeReqID: 1}
+
+	first := &mockProvider{name: "first", answers: map[string]availability{
+		"test1": availableAnswer(),
+		"test2": unavailableAnswer("out sick"),
+		"test3": availableAnswer(),
+	}}
+	second := &mockProvider{name: "second", answers: map[string]availability{
+		"test1": unavailableAnswer("leave"),
+		"test2": unavailableAnswer("conference"),
+		"test4": unavailableAnswer("secondment"),
+	}}
+
+	type test struct {
+		policy        string
+		username      string
+		wantProvider  string
+		wantAvailable bool
+		wantErr       bool
+	}
+
+	tests := []test{
+		{policy: policyAnyUnavailable, username: "test1", wantProvider: "second", wantAvailable: false},
+		{policy: policyAnyUnavailable, username: "test2", wantProvider: "first", wantAvailable: false},
+		{policy: policyAnyUnavailable, username: "test3", wantProvider: "first", wantAvailable: true},
+		{policy: policyAnyUnavailable, username: "test4", wantProvider: "second", wantAvailable: false},
+		{policy: policyAnyUnavailable, username: "test5", wantProvider: "", wantAvailable: true},
+		{policy: policyAllUnavailable, username: "test1", wantProvider: "first", wantAvailable: true},
+		{policy: policyAllUnavailable, username: "test2", wantProvider: "first", wantAvailable: false},
+		{policy: policyAllUnavailable, username: "test4", wantProvider: "second", wantAvailable: false},
+		{policy: policyFirstKnown, username: "test1", wantProvider: "first", wantAvailable: true},
+		{policy: policyFirstKnown, username: "test4", wantProvider: "second", wantAvailable: false},
+		{policy: policyFirstKnown, username: "test5", wantProvider: "", wantAvailable: true},
+		// provider errors exclude the user whatever the policy
+		{policy: policyAnyUnavailable, username: "error", wantProvider: "first", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		chain := availabilityChain{policy: tc.policy, providers: []AvailabilityProvider{first, second}}
+		provider, answer, err := chain.check(&gitlab.BasicUser{Username: tc.username}, mr, time.Now())
+		assert.Equal(t, tc.wantErr, err != nil, tc.policy, tc.username)
+		assert.Equal(t, tc.wantProvider, provider, tc.policy, tc.username)
+		if err == nil {
+			assert.Equal(t, tc.wantAvailable, answer.available, tc.policy, tc.username)
+		}
+	}
+
+	// later providers are not consulted once decided
+	first.calls, second.calls = 0, 0
+	chain := availabilityChain{policy: policyAnyUnavailable, providers: []AvailabilityProvider{first, second}}
+	_, _, _ = chain.check(&gitlab.BasicUser{Username: "test2"}, mr, time.Now())
+	assert.Equal(t, 1, first.calls)
+	assert.Equal(t, 0, second.calls)
+}
+
+func TestConfigAvailabilityChain(t *testing.T) {
+	slack := &mockProvider{name: providerSlack}
+	gitlabStatus := &mockProvider{name: providerGitlab}
+	static := &mockProvider{name: providerStatic}
+	calendar1 := &mockProvider{name: providerCalendar}
+	calendar2 := &mockProvider{name: providerCalendar}
+
+	type test struct {
+		availability Availability
+		wantPolicy   string
+		want         []AvailabilityProvider
+	}
+
+	tests := []test{
+		{availability: Availability{}, wantPolicy: policyAnyUnavailable, want: []AvailabilityProvider{slack, static, calendar1, calendar2}},
+		{availability: Availability{Policy: policyFirstKnown, Providers: []string{providerStatic, providerSlack}}, wantPolicy: policyFirstKnown, want: []AvailabilityProvider{static, slack}},
+		{availability: Availability{Providers: []string{providerCalendar}}, wantPolicy: policyAnyUnavailable, want: []AvailabilityProvider{calendar1, calendar2}},
+		// gitlab only consulted when listed
+		{availability: Availability{Providers: []string{providerSlack, providerGitlab}}, wantPolicy: policyAnyUnavailable, want: []AvailabilityProvider{slack, gitlabStatus}},
+	}
+
+	for _, tc := range tests {
+		config := Config{Availability: tc.availability, providers: []AvailabilityProvider{static, calendar1, calendar2}}
+		chain := config.availabilityChain(slack, gitlabStatus)
+		assert.Equal(t, tc.wantPolicy, chain.policy)
+		assert.Equal(t, tc.want, chain.providers)
+	}
+}
+
+func TestAvailabilityChainFirstKnownSlack(t *testing.T) {
+	mr := MergeRequest{group: "test", projectID: 1, mergeReqID: 1}
+	config := Config{UserStatuses: map[string]UserStatus{"": {TTL: 1}, "out sick": {TTL: 8}}}
+
+	cache := newLocalCache()
+	expire := time.Now().Add(time.Hour).Unix()
+	cache.update(userMeta{username: "test1", slackUserID: "1"}, expire)
+	cache.update(userMeta{username: "test2", slackUserID: "2", status: "out sick"}, expire)
+
+	calendar := &mockProvider{name: providerCalendar, answers: map[string]availability{
+		"test1": unavailableAnswer("Annual Leave"),
+		"test2": availableAnswer(),
+	}}
+	chain := availabilityChain{policy: policyFirstKnown, providers: []AvailabilityProvider{newSlackProvider(&MockSlack{}, cache, config, mr), calendar}}
+
+	// slack does not know users without a matching status, the calendar decides
+	provider, answer, err := chain.check(&gitlab.BasicUser{Username: "test1"}, mr, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, providerCalendar, provider)
+	assert.False(t, answer.available)
+
+	provider, answer, err = chain.check(&gitlab.BasicUser{Username: "test2"}, mr, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, providerSlack, provider)
+	assert.False(t, answer.available)
+}
+
+func TestAvailabilityValidate(t *testing.T) {
+	type test struct {
+		availability Availability
+		err          error
+	}
+
+	tests := []test{
+		{availability: Availability{}, err: nil},
+		{availability: Availability{Policy: policyAllUnavailable, Providers: []string{providerSlack, providerStatic, providerCalendar, providerGitlab}}, err: nil},
+		{availability: Availability{Policy: "majority"}, err: errors.New("'majority' unknown availability policy.")},
+		{availability: Availability{Providers: []string{"outlook"}}, err: errors.New("'outlook' unknown availability provider.")},
+		{availability: Availability{Static: map[string]StaticAvailability{"test1": {Until: "01/07/2022"}}}, err: errors.New("static: test1: '01/07/2022' until must be a date such as 2022-07-01.")},
+		{availability: Availability{Calendars: []Calendar{{}}}, err: errors.New("calendar: 0: calendar source required.")},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.err, tc.availability.validate())
+	}
+}
@@ -0,0 +1,153 @@
+// Background refresh of the user cache so slack statuses are kept up to date outside of processing a merge request.
+// Entries nearing expiry are refreshed in batches and users no longer in any configured slack channel are evicted.
+package main
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	defaultCacheRefreshInterval  = 5 * time.Minute
+	defaultCacheRefreshBefore    = 10 * time.Minute
+	defaultCacheRefreshBatchSize = 30
+)
+
+// withDefaults: fill in any cache refresh settings not set in the configuration file
+func (cr CacheRefresh) withDefaults() CacheRefresh {
+	if cr.Interval <= 0 {
+		cr.Interval = defaultCacheRefreshInterval
+	}
+	if cr.RefreshBefore <= 0 {
+		cr.RefreshBefore = defaultCacheRefreshBefore
+	}
+	if cr.BatchSize <= 0 {
+		cr.BatchSize = defaultCacheRefreshBatchSize
+	}
+	return cr
+}
+
+// startCacheRefresher: refresh the cache on the configured interval until the cache is closed
+func startCacheRefresher(slack SlackWrapper, cache UserCache, config Config) {
+	if config.CacheRefresh.Disabled {
+		log.Info("user cache background refresh disabled.")
+		return
+	}
+
+	refresh := config.CacheRefresh.withDefaults()
+	log.WithFields(log.Fields{"interval": refresh.Interval, "refresh_before": refresh.RefreshBefore}).Info("starting user cache background refresh.")
+	cache.startRefresher(refresh.Interval, func() {
+		refreshCache(slack, cache, config)
+	})
+}
+
+// refreshCache: refresh the slack status of cached users expiring within the refresh before duration and evict users
+// who have left every configured slack channel
+func refreshCache(slack SlackWrapper, cache UserCache, config Config) {
+	refresh := config.CacheRefresh.withDefaults()
+	logger := log.WithFields(log.Fields{"func": "refreshCache"})
+
+	users := cache.getUserList()
+	members, membersErr := getChannelMembers(slack, config)
+	if membersErr != nil {
+		promErrors.WithLabelValues("cache_refresh_members").Inc()
+		logger.WithFields(log.Fields{"error": membersErr}).Warn("failed to get slack channel members, skipping eviction.")
+	}
+
+	// Slack user id to usernames, more than one gitlab user may be mapped to a slack user
+	usernames := make(map[string][]string)
+	var refreshIDs []string
+	refreshAt := time.Now().Add(refresh.RefreshBefore)
+	for _, u := range users {
+		if u.SlackUserID == "" {
+			continue
+		}
+
+		_, mapped := config.UserMappings[u.Username]
+		if membersErr == nil && !mapped && !members[u.SlackUserID] {
+			evictCachedUser(cache, u.Username, "left_channel")
+			continue
+		}
+
+		if u.CacheExpire.Before(refreshAt) {
+			if len(usernames[u.SlackUserID]) == 0 {
+				refreshIDs = append(refreshIDs, u.SlackUserID)
+			}
+			usernames[u.SlackUserID] = append(usernames[u.SlackUserID], u.Username)
+		}
+	}
+
+	for start := 0; start < len(refreshIDs); start += refresh.BatchSize {
+		end := start + refresh.BatchSize
+		if end > len(refreshIDs) {
+			end = len(refreshIDs)
+		}
+
+		slackUsersData, _, err := getUsersInfo(slack, refreshIDs[start:end]...)
+		if err != nil {
+			promCacheRefresh.WithLabelValues("failed").Add(float64(end - start))
+			logger.WithFields(log.Fields{"error": err, "num_users": end - start}).Error("failed to refresh slack user data.")
+			continue
+		}
+
+		for _, s := range *slackUsersData {
+			for _, username := range usernames[s.ID] {
+				if s.Deleted {
+					evictCachedUser(cache, username, "deleted")
+					continue
+				}
+				updateCachedUser(cache, username, s, config)
+				promCacheRefresh.WithLabelValues("refreshed").Inc()
+			}
+		}
+	}
+
+	logger.WithFields(log.Fields{"num_users": len(users), "num_refreshed": len(refreshIDs)}).Debug("user cache refreshed.")
+}
+
+// getChannelMembers: return the slack user ids of the members of every configured slack channel
+func getChannelMembers(slack SlackWrapper, config Config) (map[string]bool, error) {
+	members := make(map[string]bool)
+	for _, groupChannel := range config.GroupChannels {
+		if groupChannel.SlackChannelID == "" {
+			continue
+		}
+		userIDs, err := getUsersInConversation(slack, groupChannel.SlackChannelID)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range userIDs {
+			members[id] = true
+		}
+	}
+	return members, nil
+}
+
+func evictCachedUser(cache UserCache, username string, reason string) {
+	if err := cache.delete(username); err != nil {
+		return
+	}
+	promCacheRefresh.WithLabelValues("evicted_" + reason).Inc()
+	log.WithFields(log.Fields{"username": username, "reason": reason}).Info("evicted user from cache.")
+}
+
+// updateCachedUser: set the slack status of a user in the cache, expiring when slack clears the status or otherwise
+// after the ttl configured for the status
+func updateCachedUser(cache UserCache, username string, s slack.User, config Config) userMeta {
+	u := userMeta{username: username, slackUserID: s.ID, status: strings.ToLower(s.Profile.StatusText), statusEmoji: s.Profile.StatusEmoji, timezone: s.TZ, tzOffset: s.TZOffset}
+	cache.update(u, statusExpiry(s, config, time.Now()))
+	return u
+}
+
+// statusExpiry: unix time the cache entry for a slack user expires, the status expiration set in slack when in the
+// future otherwise the ttl configured for the status
+func statusExpiry(s slack.User, config Config, now time.Time) int64 {
+	if expiration := int64(s.Profile.StatusExpiration); expiration > now.Unix() {
+		return expiration
+	}
+	ttl := getStatusTTL(config.UserStatuses, s.Profile.StatusText, s.Profile.StatusEmoji)
+	return now.Add(time.Hour * time.Duration(ttl)).Unix()
+}
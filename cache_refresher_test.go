@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests
+
+func TestRefreshCache(t *testing.T) {
+	timeNow := time.Now()
+	timeNearExpiry := timeNow.Add(time.Minute)
+	timeExpire := timeNow.Add(time.Hour * 8)
+
+	type test struct {
+		channelID string
+		want      map[string]userMeta
+	}
+
+	tests := []test{
+		{
+			channelID: "A",
+			want: map[string]userMeta{
+				// refreshed as nearing expiry
+				"test1": {username: "test1", slackUserID: "1", status: "out sick"},
+				"test2": {username: "test2", slackUserID: "2"},
+				// left the channel but kept as mapped in the configuration
+				"ldap9": {username: "ldap9", slackUserID: "9"},
+			},
+		},
+		// failed to get channel members, nothing evicted
+		{
+			channelID: "E",
+			want: map[string]userMeta{
+				"test1": {username: "test1", slackUserID: "1", status: "out sick"},
+				"test2": {username: "test2", slackUserID: "2"},
+				"test9": {username: "test9", slackUserID: "9"},
+				"ldap9": {username: "ldap9", slackUserID: "9"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		mockConfig := Config{
+			GroupChannels: map[string]GroupChannel{
+				"test":  {SlackChannel: "channel", SlackChannelID: tc.channelID},
+				"other": {SlackChannel: "channel"},
+			},
+			UserStatuses: map[string]UserStatus{"out sick": {TTL: 8}},
+			UserMappings: map[string]string{"ldap9": "9"},
+		}
+
+		cache := newLocalCache()
+		cache.update(userMeta{username: "test1", slackUserID: "1"}, timeNearExpiry.Unix())
+		cache.update(userMeta{username: "test2", slackUserID: "2"}, timeExpire.Unix())
+		cache.update(userMeta{username: "test9", slackUserID: "9"}, timeExpire.Unix())
+		cache.update(userMeta{username: "ldap9", slackUserID: "9"}, timeExpire.Unix())
+
+		// returns user 1 with out sick status
+		refreshCache(&MockSlack{wh_url: "B"}, cache, mockConfig)
+
+		assert.Len(t, cache.users, len(tc.want))
+		for username, want := range tc.want {
+			assert.Equal(t, want, cache.users[username].user)
+		}
+		assert.WithinDuration(t, timeNow.Add(time.Hour*8), time.Unix(cache.users["test1"].expireTimestamp, 0), time.Minute)
+	}
+}
+
+func TestStartRefresher(t *testing.T) {
+	cache := newLocalCache()
+
+	refreshed := make(chan struct{}, 10)
+	cache.startRefresher(time.Millisecond, func() {
+		refreshed <- struct{}{}
+	})
+
+	<-refreshed
+	cache.close()
+
+	// no refresh after close
+	for len(refreshed) > 0 {
+		<-refreshed
+	}
+	time.Sleep(5 * time.Millisecond)
+	assert.Len(t, refreshed, 0)
+}
+
+func TestStatusExpiry(t *testing.T) {
+	now := time.Now()
+	config := Config{
+		UserStatuses: map[string]UserStatus{"": {TTL: 1}, "out sick": {TTL: 8}},
+	}
+
+	type test struct {
+		status     string
+		expiration int64
+		want       int64
+	}
+
+	tests := []test{
+		// ttl from the status rule without a slack status expiration
+		{status: "", expiration: 0, want: now.Add(time.Hour).Unix()},
+		{status: "Out Sick", expiration: 0, want: now.Add(time.Hour * 8).Unix()},
+		// slack status expiration used whether before or after the ttl
+		{status: "Out Sick", expiration: now.Add(time.Minute * 30).Unix(), want: now.Add(time.Minute * 30).Unix()},
+		{status: "Out Sick", expiration: now.Add(time.Hour * 72).Unix(), want: now.Add(time.Hour * 72).Unix()},
+		// expiration already passed, slack is yet to clear the status
+		{status: "Out Sick", expiration: now.Add(-time.Minute).Unix(), want: now.Add(time.Hour * 8).Unix()},
+	}
+
+	for _, tc := range tests {
+		s := slack.User{ID: "1", Profile: slack.UserProfile{StatusText: tc.status, StatusExpiration: int(tc.expiration)}}
+		assert.Equal(t, tc.want, statusExpiry(s, config, now), tc.status)
+	}
+}
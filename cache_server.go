@@ -11,14 +11,16 @@ import (
 )
 
 type cacheHandler struct {
-	cache  *localCache
-	config Config
+	cache     UserCache
+	unmatched *unmatchedUsers
+	config    Config
 }
 
 type cacheResponseData struct {
 	UserList     []userList
+	Unmatched    []unmatchedUser
 	Response     cacheFormResponse
-	UserStatuses map[string]int
+	UserStatuses map[string]UserStatus
 	ServerTime   time.Time
 }
 
@@ -57,13 +59,27 @@ func (c cacheHandler) ServeHTTP(writer http.ResponseWriter, request *http.Reques
 			if customExpireHours > 0 || customExpireDays > 0 || customExpireWeeks > 0 {
 				expire = t.Add(time.Hour * time.Duration(customExpireHours+(customExpireDays*24)+(customExpireWeeks*7*24)))
 			} else {
-				ttl := getStatusTTL(c.config.UserStatuses, slackStatus)
+				ttl := getStatusTTL(c.config.UserStatuses, slackStatus, "")
 				expire = t.Add(time.Hour * time.Duration(ttl))
 			}
 
-			u := userMeta{username: username, slackUserID: slackUserID, status: slackStatus}
+			// Timezone is only known from slack, keep it from the existing entry
+			existing, _ := c.cache.read(username)
+			u := userMeta{username: username, slackUserID: slackUserID, status: slackStatus, timezone: existing.timezone, tzOffset: existing.tzOffset}
 			c.cache.update(u, expire.Unix())
 			cfr = cacheFormResponse{"updated", username, ""}
+		} else if request.FormValue("map") == "map" {
+			slackUserID := request.FormValue("slackUserID")
+			log.Debug("cache admin: mapping user: ", username, " to slack user: ", slackUserID)
+
+			if slackUserID == "" {
+				cfr = cacheFormResponse{"mapped", username, "slack user id required."}
+			} else {
+				// Added as expired so the slack status is fetched on first use
+				c.cache.update(userMeta{username: username, slackUserID: slackUserID}, 0)
+				c.unmatched.remove(username)
+				cfr = cacheFormResponse{Result: "mapped", Username: username}
+			}
 		} else if request.FormValue("delete") == "delete" {
 			log.Debug("cache admin: deleting cache entry for user: ", username)
 			err := c.cache.delete(username)
@@ -90,7 +106,7 @@ func (c cacheHandler) ServeHTTP(writer http.ResponseWriter, request *http.Reques
 		return
 	}
 
-	err = testTemplate.Execute(writer, cacheResponseData{c.cache.getUserList(), cfr, c.config.UserStatuses, t.Local()})
+	err = testTemplate.Execute(writer, cacheResponseData{c.cache.getUserList(), c.unmatched.getList(), cfr, c.config.UserStatuses, t.Local()})
 	if err != nil {
 		log.WithFields(log.Fields{"error": err}).Error("handling MergeEvent request.")
 		writer.WriteHeader(500)
@@ -0,0 +1,349 @@
+// Availability from ICS calendar feeds, such as a shared leave calendar. All day events marked out of office, or with
+// a summary containing an out of office keyword, make the users they belong to unavailable for the days they cover.
+// Events belong to a user by attendee or organizer email, or by the summary containing their username or name as a
+// whole word.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xanzy/go-gitlab"
+)
+
+const (
+	defaultCalendarRefreshInterval = 15 * time.Minute
+	calendarFetchTimeout           = 10 * time.Second
+	icsDateLayout                  = "20060102"
+)
+
+var defaultCalendarKeywords = []string{"ooo", "out of office", "leave", "holiday", "vacation", "pto"}
+
+// Calendar - ICS feed read from a url or file, all day dates are in the calendar timezone, defaulting to the server
+// timezone
+type Calendar struct {
+	Source          string        `yaml:"source"`
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	Keywords        []string      `yaml:"keywords"`
+	Timezone        string        `yaml:"timezone"`
+}
+
+func (c Calendar) validate() error {
+	if c.Source == "" {
+		return errors.New("calendar source required.")
+	}
+	if c.RefreshInterval < 0 {
+		return fmt.Errorf("'%s' refresh interval must not be negative.", c.RefreshInterval)
+	}
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			return fmt.Errorf("'%s' unknown timezone.", c.Timezone)
+		}
+	}
+	return nil
+}
+
+func (c Calendar) remote() bool {
+	u, err := url.Parse(c.Source)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// calendarEvent: an all day out of office event, the end date is exclusive
+type calendarEvent struct {
+	start   time.Time
+	end     time.Time
+	summary string
+	people  []calendarPerson
+}
+
+type calendarPerson struct {
+	name  string
+	email string
+}
+
+// calendarProvider: events are read from the source on first use and again once older than the refresh interval, the
+// last events read are kept when the source can not be read. The source is read without holding the lock, approvers
+// checked while reading again are checked against the last events read.
+type calendarProvider struct {
+	calendar Calendar
+	fs       fileSystem
+	client   *http.Client
+	keywords *regexp.Regexp
+	location *time.Location
+
+	mu         sync.Mutex
+	events     []calendarEvent
+	fetched    time.Time
+	refreshing bool
+	// loaded: the source has been read for the first time, successfully or not
+	loaded bool
+	// firstRead: closed once the source has been read for the first time
+	firstRead chan struct{}
+}
+
+func newCalendarProvider(calendar Calendar, fs fileSystem) (*calendarProvider, error) {
+	if err := calendar.validate(); err != nil {
+		return nil, err
+	}
+	if calendar.RefreshInterval == 0 {
+		calendar.RefreshInterval = defaultCalendarRefreshInterval
+	}
+
+	keywords := calendar.Keywords
+	if len(keywords) == 0 {
+		keywords = defaultCalendarKeywords
+	}
+	var quoted []string
+	for _, keyword := range keywords {
+		quoted = append(quoted, regexp.QuoteMeta(keyword))
+	}
+
+	location := time.Local
+	if calendar.Timezone != "" {
+		location, _ = time.LoadLocation(calendar.Timezone)
+	}
+
+	return &calendarProvider{
+		calendar:  calendar,
+		fs:        fs,
+		client:    &http.Client{Timeout: calendarFetchTimeout},
+		keywords:  regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`),
+		location:  location,
+		firstRead: make(chan struct{}),
+	}, nil
+}
+
+func (cp *calendarProvider) Name() string {
+	return providerCalendar
+}
+
+// Availability: users with an out of office event covering the day in the calendar timezone are unavailable until the
+// event ends, other users are not known
+func (cp *calendarProvider) Availability(user *gitlab.BasicUser, now time.Time) (availability, error) {
+	for _, event := range cp.getEvents(now) {
+		if now.Before(event.start) || !now.Before(event.end) {
+			continue
+		}
+		if event.belongsTo(user) {
+			return availability{known: true, available: false, reason: event.summary, ttl: event.end.Sub(now)}, nil
+		}
+	}
+	return availability{}, nil
+}
+
+// getEvents: return the events, reading the source again when older than the refresh interval. Only one caller reads
+// the source, other callers wait for the first read otherwise use the last events read.
+func (cp *calendarProvider) getEvents(now time.Time) []calendarEvent {
+	cp.mu.Lock()
+	if cp.refreshing || (!cp.fetched.IsZero() && now.Sub(cp.fetched) < cp.calendar.RefreshInterval) {
+		loaded := cp.loaded
+		cp.mu.Unlock()
+		if !loaded {
+			<-cp.firstRead
+		}
+		return cp.lastEvents()
+	}
+	first := !cp.loaded
+	// Retried on the next refresh interval rather than on every approver
+	cp.fetched = now
+	cp.refreshing = true
+	cp.mu.Unlock()
+
+	logger := log.WithFields(log.Fields{"source": cp.calendar.Source})
+	events, err := cp.read()
+
+	cp.mu.Lock()
+	cp.refreshing = false
+	cp.loaded = true
+	if err != nil {
+		promCalendarReads.WithLabelValues("failed").Inc()
+		logger.WithFields(log.Fields{"error": err}).Error("failed to read calendar, using last events read.")
+	} else {
+		promCalendarReads.WithLabelValues("success").Inc()
+		logger.WithFields(log.Fields{"num_events": len(events)}).Debug("read calendar.")
+		cp.events = events
+	}
+	events = cp.events
+	cp.mu.Unlock()
+
+	if first {
+		close(cp.firstRead)
+	}
+	return events
+}
+
+func (cp *calendarProvider) lastEvents() []calendarEvent {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.events
+}
+
+// read: read and parse the calendar from its url or file
+func (cp *calendarProvider) read() ([]calendarEvent, error) {
+	if cp.calendar.remote() {
+		resp, err := cp.client.Get(cp.calendar.Source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+		return cp.parse(resp.Body)
+	}
+
+	f, err := cp.fs.Open(cp.calendar.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return cp.parse(f)
+}
+
+// parse: return the all day out of office events in an ICS calendar. Recurring events are not expanded, only the
+// first occurrence is used.
+func (cp *calendarProvider) parse(r io.Reader) ([]calendarEvent, error) {
+	lines, err := unfoldICSLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []calendarEvent
+	var event *calendarEvent
+	var allDay, outOfOffice bool
+	for _, line := range lines {
+		name, params, value := parseICSProperty(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event, allDay, outOfOffice = &calendarEvent{}, false, false
+		case event == nil:
+			continue
+		case name == "END" && value == "VEVENT":
+			if event.end.IsZero() {
+				event.end = event.start.AddDate(0, 0, 1)
+			}
+			if allDay && (outOfOffice || cp.keywords.MatchString(event.summary)) {
+				events = append(events, *event)
+			}
+			event = nil
+		case name == "DTSTART":
+			event.start, allDay = cp.parseDate(params, value)
+		case name == "DTEND":
+			event.end, _ = cp.parseDate(params, value)
+		case name == "SUMMARY":
+			event.summary = unescapeICSText(value)
+		case name == "ATTENDEE" || name == "ORGANIZER":
+			event.people = append(event.people, calendarPerson{
+				name:  params["CN"],
+				email: strings.TrimPrefix(strings.ToLower(value), "mailto:"),
+			})
+		case name == "X-MICROSOFT-CDO-BUSYSTATUS":
+			outOfOffice = value == "OOF"
+		}
+	}
+	return events, nil
+}
+
+// parseDate: return the start of an all day date in the calendar timezone, false when the value is not a date
+func (cp *calendarProvider) parseDate(params map[string]string, value string) (time.Time, bool) {
+	if params["VALUE"] != "DATE" && len(value) != len(icsDateLayout) {
+		return time.Time{}, false
+	}
+	date, err := time.ParseInLocation(icsDateLayout, value, cp.location)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// belongsTo: if an event belongs to the user by attendee or organizer, otherwise by the summary containing their name
+// or username as a whole word so "ann" does not match "Annual Leave"
+func (e calendarEvent) belongsTo(user *gitlab.BasicUser) bool {
+	for _, person := range e.people {
+		if (user.Username != "" && strings.EqualFold(strings.SplitN(person.email, "@", 2)[0], user.Username)) || (person.name != "" && strings.EqualFold(person.name, user.Name)) {
+			return true
+		}
+	}
+	return containsWord(e.summary, user.Name) || containsWord(e.summary, user.Username)
+}
+
+// containsWord: if the text contains the word, case insensitive, not directly preceded or followed by a letter, digit
+// or a character allowed in usernames. A full stop followed by a space or the end of the text ends a word.
+func containsWord(text string, word string) bool {
+	if word == "" {
+		return false
+	}
+	text, word = strings.ToLower(text), strings.ToLower(word)
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], word)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(word)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if start > 0 && isWordRune(before) {
+			offset = start + 1
+			continue
+		}
+		if after == '.' {
+			after, _ = utf8.DecodeRuneInString(text[end+1:])
+		}
+		if end == len(text) || !isWordRune(after) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+// unfoldICSLines: join lines continued by a leading space or tab
+func unfoldICSLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseICSProperty: split a content line into its upper case name, parameters and value
+func parseICSProperty(line string) (string, map[string]string, string) {
+	parts := strings.SplitN(line, ":", 2)
+	if len(parts) != 2 {
+		return "", nil, ""
+	}
+
+	params := make(map[string]string)
+	fields := strings.Split(parts[0], ";")
+	for _, param := range fields[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return strings.ToUpper(fields[0]), params, parts[1]
+}
+
+func unescapeICSText(value string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
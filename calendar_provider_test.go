@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+// Setup
+
+// calendarUnavailable: the reason and if the calendar answers the user is unavailable
+func calendarUnavailable(cp *calendarProvider, user *gitlab.BasicUser, now time.Time) (string, bool) {
+	answer, _ := cp.Availability(user, now)
+	return answer.reason, answer.known && !answer.available
+}
+
+// Tests
+
+func TestCalendarValidate(t *testing.T) {
+	type test struct {
+		calendar Calendar
+		err      error
+	}
+
+	tests := []test{
+		{calendar: Calendar{Source: "./leave.ics"}, err: nil},
+		{calendar: Calendar{Source: "https://calendar.local/leave.ics", Timezone: "Europe/London"}, err: nil},
+		{calendar: Calendar{}, err: errors.New("calendar source required.")},
+		{calendar: Calendar{Source: "./leave.ics", RefreshInterval: -time.Minute}, err: errors.New("'-1m0s' refresh interval must not be negative.")},
+		{calendar: Calendar{Source: "./leave.ics", Timezone: "Europe/Leith"}, err: errors.New("'Europe/Leith' unknown timezone.")},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.err, tc.calendar.validate())
+	}
+}
+
+func TestCalendarProviderUnavailable(t *testing.T) {
+	provider, err := newCalendarProvider(Calendar{Source: "./tests/fixtures/calendars/leave.ics", Timezone: "UTC"}, &osFS{})
+	assert.NoError(t, err)
+
+	jane := &gitlab.BasicUser{Username: "jane.doe", Name: "Jane Doe"}
+	john := &gitlab.BasicUser{Username: "jsmith", Name: "John Smith"}
+	sam := &gitlab.BasicUser{Username: "slee", Name: "Samuel Lee"}
+	alex := &gitlab.BasicUser{Username: "akim", Name: "Alex Kim"}
+	testUser := &gitlab.BasicUser{Username: "test.user", Name: "Test User"}
+
+	day := func(d int, hour int) time.Time {
+		return time.Date(2022, time.June, d, hour, 0, 0, 0, time.UTC)
+	}
+
+	type test struct {
+		user       *gitlab.BasicUser
+		now        time.Time
+		wantReason string
+		want       bool
+	}
+
+	tests := []test{
+		// attendee email matching username, end date exclusive
+		{user: jane, now: day(13, 0), wantReason: "Annual Leave", want: true},
+		{user: jane, now: day(17, 23), wantReason: "Annual Leave", want: true},
+		{user: jane, now: day(18, 0), want: false},
+		{user: jane, now: day(12, 23), want: false},
+		// summary containing name, single day without an end date
+		{user: john, now: day(15, 12), wantReason: "John Smith - OOO", want: true},
+		{user: john, now: day(16, 0), want: false},
+		// marked out of office, organizer email matching username
+		{user: sam, now: day(16, 9), wantReason: "Away", want: true},
+		// folded summary
+		{user: alex, now: day(21, 9), wantReason: "Parental leave for Alex Kim, returning on Wednesday", want: true},
+		// not all day or without an out of office keyword
+		{user: testUser, now: day(15, 10), want: false},
+	}
+
+	for _, tc := range tests {
+		reason, unavailable := calendarUnavailable(provider, tc.user, tc.now)
+		assert.Equal(t, tc.want, unavailable, tc.user.Username)
+		assert.Equal(t, tc.wantReason, reason, tc.user.Username)
+	}
+}
+
+func TestCalendarProviderRefresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leave.ics")
+	writeCalendar := func(summary string) {
+		data := "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART;VALUE=DATE:20220615\r\nSUMMARY:" + summary + "\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+		assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0600))
+	}
+
+	user := &gitlab.BasicUser{Username: "jsmith", Name: "John Smith"}
+	now := time.Date(2022, time.June, 15, 9, 0, 0, 0, time.UTC)
+
+	provider, err := newCalendarProvider(Calendar{Source: path, Timezone: "UTC", RefreshInterval: time.Hour}, &osFS{})
+	assert.NoError(t, err)
+
+	writeCalendar("John Smith - Leave")
+	_, unavailable := calendarUnavailable(provider, user, now)
+	assert.True(t, unavailable)
+
+	// not read again until the refresh interval has passed
+	writeCalendar("John Smith - Offsite")
+	_, unavailable = calendarUnavailable(provider, user, now.Add(time.Minute*30))
+	assert.True(t, unavailable)
+	_, unavailable = calendarUnavailable(provider, user, now.Add(time.Hour))
+	assert.False(t, unavailable)
+
+	// last events read kept when the source can not be read
+	writeCalendar("John Smith - Leave")
+	_, unavailable = calendarUnavailable(provider, user, now.Add(time.Hour*2))
+	assert.True(t, unavailable)
+	assert.NoError(t, os.Remove(path))
+	_, unavailable = calendarUnavailable(provider, user, now.Add(time.Hour*3))
+	assert.True(t, unavailable)
+}
+
+func TestCalendarProviderRemote(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/leave.ics" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeFile(w, r, "./tests/fixtures/calendars/leave.ics")
+	}))
+	defer server.Close()
+
+	jane := &gitlab.BasicUser{Username: "jane.doe", Name: "Jane Doe"}
+	now := time.Date(2022, time.June, 15, 9, 0, 0, 0, time.UTC)
+
+	provider, err := newCalendarProvider(Calendar{Source: server.URL + "/leave.ics", Timezone: "UTC"}, &osFS{})
+	assert.NoError(t, err)
+	_, unavailable := calendarUnavailable(provider, jane, now)
+	assert.True(t, unavailable)
+
+	provider, err = newCalendarProvider(Calendar{Source: server.URL + "/missing.ics", Timezone: "UTC"}, &osFS{})
+	assert.NoError(t, err)
+	_, unavailable = calendarUnavailable(provider, jane, now)
+	assert.False(t, unavailable)
+}
+
+func TestCalendarEventBelongsTo(t *testing.T) {
+	type test struct {
+		summary string
+		user    *gitlab.BasicUser
+		want    bool
+	}
+
+	tests := []test{
+		{summary: "Ann - Leave", user: &gitlab.BasicUser{Username: "ann"}, want: true},
+		{summary: "Annual Leave", user: &gitlab.BasicUser{Username: "ann"}, want: false},
+		{summary: "Leave for Ann.", user: &gitlab.BasicUser{Username: "ann"}, want: true},
+		{summary: "ann.smith OOO", user: &gitlab.BasicUser{Username: "ann"}, want: false},
+		{summary: "ann.smith OOO", user: &gitlab.BasicUser{Username: "ann.smith"}, want: true},
+		{summary: "Holiday (Jo Bloggs)", user: &gitlab.BasicUser{Username: "jbloggs", Name: "Jo Bloggs"}, want: true},
+		{summary: "Jo Bloggsworth holiday", user: &gitlab.BasicUser{Username: "jbloggs", Name: "Jo Bloggs"}, want: false},
+		{summary: "Team holiday", user: &gitlab.BasicUser{}, want: false},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, calendarEvent{summary: tc.summary}.belongsTo(tc.user), tc.summary)
+	}
+
+	// attendee without a username does not match users without one
+	event := calendarEvent{people: []calendarPerson{{email: "@calendar.local"}}}
+	assert.False(t, event.belongsTo(&gitlab.BasicUser{Name: "Jo Bloggs"}))
+}
+
+func TestCalendarProviderReadWithoutLock(t *testing.T) {
+	release := make(chan struct{})
+	reads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reads++
+		if reads > 1 {
+			<-release
+		}
+		http.ServeFile(w, r, "./tests/fixtures/calendars/leave.ics")
+	}))
+	defer server.Close()
+
+	jane := &gitlab.BasicUser{Username: "jane.doe", Name: "Jane Doe"}
+	now := time.Date(2022, time.June, 15, 9, 0, 0, 0, time.UTC)
+
+	provider, err := newCalendarProvider(Calendar{Source: server.URL, Timezone: "UTC", RefreshInterval: time.Hour}, &osFS{})
+	assert.NoError(t, err)
+	_, unavailable := calendarUnavailable(provider, jane, now)
+	assert.True(t, unavailable)
+
+	// a slow read once the refresh interval has passed does not block other approvers using the last events read
+	refreshed := make(chan struct{})
+	go func() {
+		calendarUnavailable(provider, jane, now.Add(time.Hour))
+		close(refreshed)
+	}()
+	assert.Eventually(t, func() bool {
+		provider.mu.Lock()
+		defer provider.mu.Unlock()
+		return provider.refreshing
+	}, time.Second, time.Millisecond)
+	_, unavailable = calendarUnavailable(provider, jane, now.Add(time.Hour))
+	assert.True(t, unavailable)
+
+	close(release)
+	<-refreshed
+	assert.Equal(t, 2, reads)
+}
+
+func TestCalendarProviderWaitForFirstRead(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		http.ServeFile(w, r, "./tests/fixtures/calendars/leave.ics")
+	}))
+	defer server.Close()
+
+	jane := &gitlab.BasicUser{Username: "jane.doe", Name: "Jane Doe"}
+	now := time.Date(2022, time.June, 15, 9, 0, 0, 0, time.UTC)
+
+	provider, err := newCalendarProvider(Calendar{Source: server.URL, Timezone: "UTC", RefreshInterval: time.Hour}, &osFS{})
+	assert.NoError(t, err)
+
+	// approvers checked while the source is read for the first time wait for the events
+	go calendarUnavailable(provider, jane, now)
+	assert.Eventually(t, func() bool {
+		provider.mu.Lock()
+		defer provider.mu.Unlock()
+		return provider.refreshing
+	}, time.Second, time.Millisecond)
+
+	checked := make(chan bool)
+	go func() {
+		_, unavailable := calendarUnavailable(provider, jane, now)
+		checked <- unavailable
+	}()
+	select {
+	case <-checked:
+		t.Fatal("checked before the first read finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.True(t, <-checked)
+}
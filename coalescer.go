@@ -0,0 +1,115 @@
+// Coalescing of queued jobs by merge request so only one job per merge request is processed at a time. Gitlab sends
+// several events in quick succession when a merge request is opened or updated, events arriving while a job for the
+// same merge request is waiting replace it so a burst results in a single job.
+package main
+
+import (
+	"time"
+)
+
+const defaultCoalesceWindow = 2 * time.Second
+
+// pendingJob: the latest job for a merge request waiting to be dispatched, held until the coalesce window has passed
+type pendingJob struct {
+	job  job
+	held bool
+}
+
+// coalescer: not safe for concurrent use, owned by the scheduler message pump
+type coalescer struct {
+	window   time.Duration
+	pending  map[string]*pendingJob
+	inFlight map[string]job
+	ready    []job
+	expired  chan string
+}
+
+func newCoalescer(window time.Duration) *coalescer {
+	if window == 0 {
+		window = defaultCoalesceWindow
+	}
+	return &coalescer{
+		window:   window,
+		pending:  make(map[string]*pendingJob),
+		inFlight: make(map[string]job),
+		expired:  make(chan string, 100),
+	}
+}
+
+// add: take a job from the queue, when a job for the same merge request is already waiting the new job replaces it
+// and the replaced job is returned so it can be acknowledged
+func (c *coalescer) add(j job) (job, bool) {
+	key := mergeRequestKey(j.mr)
+
+	if p, ok := c.pending[key]; ok {
+		superseded := p.job
+		p.job = j
+		promCoalesced.WithLabelValues(j.mr.Group()).Inc()
+		return superseded, true
+	}
+
+	c.pending[key] = &pendingJob{job: j, held: true}
+	time.AfterFunc(c.window, func() {
+		c.expired <- key
+	})
+	return job{}, false
+}
+
+// expire: the coalesce window for a merge request has passed, ready to dispatch once no job is in flight
+func (c *coalescer) expire(key string) {
+	if p, ok := c.pending[key]; ok {
+		p.held = false
+		c.release(key)
+	}
+}
+
+// done: a job has been processed, releasing any job waiting for the same merge request
+func (c *coalescer) done(j job) {
+	key := mergeRequestKey(j.mr)
+	delete(c.inFlight, key)
+	c.release(key)
+}
+
+// release: move the waiting job for a merge request to the ready list if not held and nothing is in flight
+func (c *coalescer) release(key string) {
+	p, ok := c.pending[key]
+	if !ok || p.held {
+		return
+	}
+	if _, ok := c.inFlight[key]; ok {
+		return
+	}
+	delete(c.pending, key)
+	c.inFlight[key] = p.job
+	c.ready = append(c.ready, p.job)
+}
+
+// next: the next job ready to dispatch to a worker
+func (c *coalescer) next() (job, bool) {
+	if len(c.ready) == 0 {
+		return job{}, false
+	}
+	return c.ready[0], true
+}
+
+// dispatched: remove the job returned by next once sent to a worker
+func (c *coalescer) dispatched() {
+	c.ready = c.ready[1:]
+}
+
+// undrained: all jobs taken from the queue which have not finished processing, ready jobs are also in flight
+func (c *coalescer) undrained() []job {
+	var jobs []job
+	for _, p := range c.pending {
+		jobs = append(jobs, p.job)
+	}
+	for _, j := range c.inFlight {
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+// size: number of jobs taken from the queue which are yet to be dispatched
+func (c *coalescer) size() int {
+	return len(c.pending) + len(c.ready)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests
+
+func TestCoalescer(t *testing.T) {
+	mr1 := MergeRequest{group: "test", projectID: 1, mergeReqID: 1}
+	mr2 := MergeRequest{group: "test", projectID: 1, mergeReqID: 2}
+
+	c := newCoalescer(time.Millisecond)
+
+	_, ok := c.add(job{id: 1, mr: mr1})
+	assert.False(t, ok)
+	_, ok = c.add(job{id: 2, mr: mr2})
+	assert.False(t, ok)
+
+	// later event within the window replaces the pending job
+	superseded, ok := c.add(job{id: 3, mr: mr1})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), superseded.id)
+	assert.Equal(t, 2, c.size())
+
+	// held until the window has passed
+	_, ok = c.next()
+	assert.False(t, ok)
+
+	for i := 0; i < 2; i++ {
+		c.expire(<-c.expired)
+	}
+
+	var dispatched []uint64
+	for j, ok := c.next(); ok; j, ok = c.next() {
+		dispatched = append(dispatched, j.id)
+		c.dispatched()
+	}
+	assert.ElementsMatch(t, []uint64{2, 3}, dispatched)
+	assert.Equal(t, 0, c.size())
+
+	// event for a merge request in flight waits until the job is done
+	_, ok = c.add(job{id: 4, mr: mr1})
+	assert.False(t, ok)
+	c.expire(<-c.expired)
+	_, ok = c.next()
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.size())
+
+	c.done(job{id: 3, mr: mr1})
+	j, ok := c.next()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(4), j.id)
+}
+
+func TestSchedulerCoalesce(t *testing.T) {
+	mr := MergeRequest{group: "test", projectID: 1, mergeReqID: 1}
+
+	dir := t.TempDir()
+	queue, err := newFileQueue(dir+"/queue.log", 10)
+	assert.NoError(t, err)
+
+	scheduler, err := NewScheduler(queue, newDeadLetterList(), Retry{}, time.Millisecond)
+	assert.NoError(t, err)
+
+	assert.NoError(t, queue.push(mr))
+	assert.NoError(t, queue.push(mr))
+	scheduler.coalesce(<-queue.jobs())
+	scheduler.coalesce(<-queue.jobs())
+
+	// replaced job is acknowledged so it is not replayed after a restart
+	assert.Len(t, queue.pending, 1)
+	assert.Contains(t, queue.pending, uint64(2))
+	assert.Equal(t, 1, scheduler.size())
+}
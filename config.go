@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
 
@@ -10,14 +13,98 @@ import (
 type Config struct {
 	ConfigPath    string                  `yaml:"-"`
 	GroupChannels map[string]GroupChannel `yaml:"group_channels"`
-	UserStatuses  map[string]int          `yaml:"user_statuses"`
+	UserStatuses  map[string]UserStatus   `yaml:"user_statuses"`
+	RotationStore Store                   `yaml:"rotation_store"`
+	RequestQueue  Store                   `yaml:"request_queue"`
+	Retry         Retry                   `yaml:"retry"`
+	UserCache     Store                   `yaml:"user_cache"`
+	MessageStore  Store                   `yaml:"message_store"`
+	CacheRefresh  CacheRefresh            `yaml:"cache_refresh"`
+	// UserMappings - gitlab username to slack user id, overrides matching by email or username
+	UserMappings map[string]string `yaml:"user_mappings"`
+	// CoalesceWindow - time to wait for further events for a merge request before processing, defaults to 2s
+	CoalesceWindow time.Duration `yaml:"coalesce_window"`
+	// ShutdownTimeout - time allowed for workers to finish their current merge request on shutdown, defaults to 25s
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	Availability    Availability  `yaml:"availability"`
+	// Templates - default slack message templates for groups without their own
+	Templates MessageTemplates `yaml:"templates"`
+	// SlackInteractive - set when a slack signing secret is configured, adding buttons to slack messages
+	SlackInteractive bool `yaml:"-"`
+
+	// selectors created per group on load so selection state is shared between workers
+	selectors map[string]ReviewerSelector
+	// providers created on load so calendars are only read once per refresh interval
+	providers []AvailabilityProvider
+	// gitlabStatuses cached between merge requests for the gitlab availability provider
+	gitlabStatuses UserCache
+	// presences cached briefly between merge requests, keyed by slack user id
+	presences UserCache
+	// notifiers created per group on load for webhook notifiers
+	notifiers map[string]Notifier
+	// webhooks created per group on load for outbound webhooks
+	webhooks map[string][]Notifier
+	// messages sent to slack recorded so they can be updated with the review status, not recorded when nil
+	messages messageStore
+	// templates parsed per group on load so broken templates fail on start up
+	templates map[string]*messageTemplate
 }
 
 type GroupChannel struct {
-	SlackChannel   string `yaml:"slack_channel"`
-	SlackChannelID string `yaml:"slack_channel_id"`
+	SlackChannel   string         `yaml:"slack_channel"`
+	SlackChannelID string         `yaml:"slack_channel_id"`
+	Selection      Selection      `yaml:"selection"`
+	Notifier       NotifierConfig `yaml:"notifier"`
+	// Webhooks - sent each assignment in addition to the notifier
+	Webhooks []OutboundWebhook `yaml:"webhooks"`
+	// Templates - slack message templates overriding the global templates
+	Templates MessageTemplates `yaml:"templates"`
+}
+
+// Selection - Reviewer selection strategy used for a group, defaults to random when not set. The merge request author
+// is never selected unless allowed, commit authors on the merge request branch can also be excluded.
+type Selection struct {
+	Strategy          string         `yaml:"strategy"`
+	Weights           map[string]int `yaml:"weights"`
+	AllowAuthor       bool           `yaml:"allow_author"`
+	ExcludeCommitters bool           `yaml:"exclude_committers"`
+	PreferPresent     bool           `yaml:"prefer_present"`
+	WorkingHours      *WorkingHours  `yaml:"working_hours"`
+}
+
+const (
+	selectionRandom      = "random"
+	selectionLeastLoaded = "least_loaded"
+	selectionRoundRobin  = "round_robin"
+	selectionWeighted    = "weighted"
+)
+
+// Retry - Retry policy for merge requests which failed processing with a transient error, unset values use defaults
+type Retry struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+// CacheRefresh - Background refresh of user statuses in the cache, unset values use defaults
+type CacheRefresh struct {
+	Disabled      bool          `yaml:"disabled"`
+	Interval      time.Duration `yaml:"interval"`
+	RefreshBefore time.Duration `yaml:"refresh_before"`
+	BatchSize     int           `yaml:"batch_size"`
+}
+
+// Store - Backend used to persist state between restarts, path defaults to a file next to the configuration file
+type Store struct {
+	Backend string `yaml:"backend"`
+	Path    string `yaml:"path"`
 }
 
+const (
+	storeFile   = "file"
+	storeMemory = "memory"
+)
+
 func (c *Config) LoadConfig(fs fileSystem) error {
 	err := c.ValidateConfigPath(fs)
 	if err != nil {
@@ -35,9 +122,208 @@ func (c *Config) LoadConfig(fs fileSystem) error {
 		return err
 	}
 
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.loadSelectors(fs); err != nil {
+		return err
+	}
+
+	if err := c.loadNotifiers(); err != nil {
+		return err
+	}
+
+	if err := c.loadTemplates(); err != nil {
+		return err
+	}
+
+	c.messages, err = newMessageStore(*c)
+	if err != nil {
+		return err
+	}
+
+	c.providers, err = newAvailabilityProviders(c.Availability, fs)
+	c.gitlabStatuses = newLocalCache()
+	c.presences = newLocalCache()
+	return err
+}
+
+// Validate: check configuration values which can not be enforced when decoding the yaml
+func (c *Config) Validate() error {
+	for group, groupChannel := range c.GroupChannels {
+		if err := groupChannel.Selection.validate(); err != nil {
+			return fmt.Errorf("group: %s: %s", group, err)
+		}
+		if err := groupChannel.Notifier.validate(); err != nil {
+			return fmt.Errorf("group: %s: %s", group, err)
+		}
+		for _, webhook := range groupChannel.Webhooks {
+			if err := webhook.validate(); err != nil {
+				return fmt.Errorf("group: %s: %s", group, err)
+			}
+		}
+	}
+
+	for name, status := range c.UserStatuses {
+		if err := status.validate(name); err != nil {
+			return fmt.Errorf("user status: %s: %s", name, err)
+		}
+		c.UserStatuses[name] = status
+	}
+
+	switch c.RotationStore.Backend {
+	case "", storeFile, storeMemory:
+	default:
+		return fmt.Errorf("'%s' unknown rotation store backend.", c.RotationStore.Backend)
+	}
+
+	switch c.RequestQueue.Backend {
+	case "", storeFile, storeMemory:
+	default:
+		return fmt.Errorf("'%s' unknown request queue backend.", c.RequestQueue.Backend)
+	}
+
+	switch c.UserCache.Backend {
+	case "", storeFile, storeMemory:
+	default:
+		return fmt.Errorf("'%s' unknown user cache backend.", c.UserCache.Backend)
+	}
+
+	switch c.MessageStore.Backend {
+	case "", storeFile, storeMemory:
+	default:
+		return fmt.Errorf("'%s' unknown message store backend.", c.MessageStore.Backend)
+	}
+
+	if c.CoalesceWindow < 0 {
+		return fmt.Errorf("'%s' coalesce window must not be negative.", c.CoalesceWindow)
+	}
+
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("'%s' shutdown timeout must not be negative.", c.ShutdownTimeout)
+	}
+
+	if err := c.Availability.validate(); err != nil {
+		return fmt.Errorf("availability: %s", err)
+	}
+	return nil
+}
+
+const defaultShutdownTimeout = 25 * time.Second
+
+// shutdownTimeout: return the configured shutdown timeout, otherwise the default which is within the default
+// kubernetes termination grace period
+func (c Config) shutdownTimeout() time.Duration {
+	if c.ShutdownTimeout > 0 {
+		return c.ShutdownTimeout
+	}
+	return defaultShutdownTimeout
+}
+
+// storePath: return the path set for a store, otherwise the default name in the configuration file directory
+func (c Config) storePath(store Store, name string) string {
+	if store.Path != "" {
+		return store.Path
+	}
+	return filepath.Join(filepath.Dir(c.ConfigPath), name)
+}
+
+func (s Selection) validate() error {
+	switch s.Strategy {
+	case "", selectionRandom, selectionLeastLoaded, selectionRoundRobin:
+	case selectionWeighted:
+		for username, weight := range s.Weights {
+			if weight < 0 {
+				return fmt.Errorf("'%d' weight for user: %s must not be negative.", weight, username)
+			}
+		}
+	default:
+		return fmt.Errorf("'%s' unknown selection strategy.", s.Strategy)
+	}
+	if s.WorkingHours != nil {
+		return s.WorkingHours.validate()
+	}
+	return nil
+}
+
+// loadSelectors: create the reviewer selector for each group sharing a single rotation store, only created when a
+// group uses round robin selection
+func (c *Config) loadSelectors(fs fileSystem) error {
+	var store rotationStore
+	for _, groupChannel := range c.GroupChannels {
+		if groupChannel.Selection.Strategy != selectionRoundRobin || store != nil {
+			continue
+		}
+		switch c.RotationStore.Backend {
+		case storeMemory:
+			store = newMemoryRotationStore()
+		default:
+			fileStore, err := newFileRotationStore(fs, c.storePath(c.RotationStore, "rotation.json"))
+			if err != nil {
+				return err
+			}
+			store = fileStore
+		}
+	}
+
+	c.selectors = make(map[string]ReviewerSelector)
+	for group, groupChannel := range c.GroupChannels {
+		selector, err := newReviewerSelector(group, groupChannel.Selection, store)
+		if err != nil {
+			return fmt.Errorf("group: %s: %s", group, err)
+		}
+		c.selectors[group] = selector
+	}
 	return nil
 }
 
+// loadNotifiers: create the webhook notifier for each group not notified through slack and the outbound webhooks of
+// each group
+func (c *Config) loadNotifiers() error {
+	c.notifiers = make(map[string]Notifier)
+	c.webhooks = make(map[string][]Notifier)
+	for group, groupChannel := range c.GroupChannels {
+		for _, webhook := range groupChannel.Webhooks {
+			notifier, err := newOutboundWebhook(webhook)
+			if err != nil {
+				return fmt.Errorf("group: %s: %s", group, err)
+			}
+			c.webhooks[group] = append(c.webhooks[group], notifier)
+		}
+
+		switch groupChannel.Notifier.Type {
+		case "", notifierSlack:
+			continue
+		}
+		notifier, err := newWebhookNotifier(groupChannel.Notifier)
+		if err != nil {
+			return fmt.Errorf("group: %s: %s", group, err)
+		}
+		c.notifiers[group] = notifier
+	}
+	return nil
+}
+
+// reviewerSelector: return the reviewer selector created for a group on load. Configs not loaded from file, only built
+// in tests, get a new selector on each call so round robin state is not kept between calls
+func (c Config) reviewerSelector(group string) ReviewerSelector {
+	if c.selectors != nil {
+		if selector, ok := c.selectors[group]; ok {
+			return selector
+		}
+		log.WithField("group", group).Warn("no reviewer selector loaded for group, using random.")
+		return &randomSelector{}
+	}
+
+	selector, err := newReviewerSelector(group, c.GroupChannels[group].Selection, newMemoryRotationStore())
+	if err != nil {
+		log.WithFields(log.Fields{"group": group, "error": err}).Warn("invalid selection strategy, using random.")
+		return &randomSelector{}
+	}
+	return selector
+}
+
 func (c *Config) ValidateConfigPath(fs fileSystem) error {
 	s, err := fs.Stat(c.ConfigPath)
 
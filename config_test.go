@@ -30,6 +30,37 @@ group_channels:
     slack_channel_id: "BBBBBBBB"
 `
 
+	invalidSelectionConfig := `---
+group_channels:
+  chan1:
+    slack_channel: "#chan1"
+    slack_channel_id: "AAAAAAAA"
+    selection:
+      strategy: "unknown"
+`
+
+	invalidCalendarConfig := `---
+availability:
+  calendars:
+    - refresh_interval: "15m"
+`
+
+	invalidStatusConfig := `---
+user_statuses:
+  "": 1
+  "on call":
+    match: "unknown"
+`
+
+	invalidTemplateConfig := `---
+group_channels:
+  chan1:
+    slack_channel: "#chan1"
+    slack_channel_id: "AAAAAAAA"
+    templates:
+      message: "{{.Reviewers}} {{.Size}}"
+`
+
 	err := afero.WriteFile(mockFS, "empty.yaml", []byte(emptyConfig), os.ModePerm)
 	if err != nil {
 		fmt.Println("error setting up mock filesystem.")
@@ -42,6 +73,22 @@ group_channels:
 	if err != nil {
 		fmt.Println("error setting up mock filesystem.")
 	}
+	err = afero.WriteFile(mockFS, "invalid-selection.yaml", []byte(invalidSelectionConfig), os.ModePerm)
+	if err != nil {
+		fmt.Println("error setting up mock filesystem.")
+	}
+	err = afero.WriteFile(mockFS, "invalid-status.yaml", []byte(invalidStatusConfig), os.ModePerm)
+	if err != nil {
+		fmt.Println("error setting up mock filesystem.")
+	}
+	err = afero.WriteFile(mockFS, "invalid-calendar.yaml", []byte(invalidCalendarConfig), os.ModePerm)
+	if err != nil {
+		fmt.Println("error setting up mock filesystem.")
+	}
+	err = afero.WriteFile(mockFS, "invalid-template.yaml", []byte(invalidTemplateConfig), os.ModePerm)
+	if err != nil {
+		fmt.Println("error setting up mock filesystem.")
+	}
 }
 
 type MockFS struct {
@@ -63,6 +110,14 @@ func (o *MockFS) Stat(name string) (os.FileInfo, error) {
 	return stat, nil
 }
 
+func (o *MockFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return afero.WriteFile(mockFS, name, data, perm)
+}
+
+func (o *MockFS) Rename(oldname, newname string) error {
+	return mockFS.Rename(oldname, newname)
+}
+
 // Tests
 
 func TestLoadConfig(t *testing.T) {
@@ -103,6 +158,30 @@ func TestLoadConfig(t *testing.T) {
 			wantChannels:      nil,
 			err:               errors.New("open fail.yaml: file does not exist"),
 		},
+		{
+			path:              "invalid-selection.yaml",
+			wantNumOfChannels: 0,
+			wantChannels:      nil,
+			err:               errors.New("group: chan1: 'unknown' unknown selection strategy."),
+		},
+		{
+			path:              "invalid-status.yaml",
+			wantNumOfChannels: 0,
+			wantChannels:      nil,
+			err:               errors.New("user status: on call: 'unknown' unknown match type."),
+		},
+		{
+			path:              "invalid-calendar.yaml",
+			wantNumOfChannels: 0,
+			wantChannels:      nil,
+			err:               errors.New("availability: calendar: 0: calendar source required."),
+		},
+		{
+			path:              "invalid-template.yaml",
+			wantNumOfChannels: 0,
+			wantChannels:      nil,
+			err:               errors.New("group: chan1: templates: message template: template: message:1:17: executing \"message\" at <.Size>: can't evaluate field Size in type main.templateData"),
+		},
 	}
 
 	for _, tc := range tests {
@@ -142,3 +221,50 @@ func TestValidateConfigPath(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadSelectors(t *testing.T) {
+	assert.NoError(t, afero.WriteFile(mockFS, "rotation.json", []byte(`{"chan1":"test.user1"}`), os.ModePerm))
+	assert.NoError(t, afero.WriteFile(mockFS, "invalid-rotation.json", []byte("{"), os.ModePerm))
+
+	// rotation state read through the file system
+	c := &Config{
+		RotationStore: Store{Path: "rotation.json"},
+		GroupChannels: map[string]GroupChannel{"chan1": {Selection: Selection{Strategy: selectionRoundRobin}}},
+	}
+	assert.NoError(t, c.loadSelectors(&MockFS{}))
+	selector, ok := c.selectors["chan1"].(*roundRobinSelector)
+	assert.True(t, ok)
+	last, err := selector.store.load("chan1")
+	assert.NoError(t, err)
+	assert.Equal(t, "test.user1", last)
+
+	// rotation store only read when a group uses round robin
+	c = &Config{
+		RotationStore: Store{Path: "invalid-rotation.json"},
+		GroupChannels: map[string]GroupChannel{"chan1": {Selection: Selection{Strategy: selectionLeastLoaded}}},
+	}
+	assert.NoError(t, c.loadSelectors(&MockFS{}))
+	c.GroupChannels["chan2"] = GroupChannel{Selection: Selection{Strategy: selectionRoundRobin}}
+	assert.Error(t, c.loadSelectors(&MockFS{}))
+}
+
+func TestConfigReviewerSelector(t *testing.T) {
+	// Setup
+	c := &Config{
+		RotationStore: Store{Backend: storeMemory},
+		GroupChannels: map[string]GroupChannel{"chan1": {Selection: Selection{Strategy: selectionRoundRobin}}},
+	}
+
+	// Tests
+	// loaded selectors shared between calls so round robin state is kept
+	assert.NoError(t, c.loadSelectors(&MockFS{}))
+	assert.Same(t, c.reviewerSelector("chan1"), c.reviewerSelector("chan1"))
+
+	// groups without a loaded selector use random
+	_, ok := c.reviewerSelector("chan2").(*randomSelector)
+	assert.True(t, ok)
+
+	// configs not loaded from file create a selector for the group strategy
+	_, ok = Config{GroupChannels: c.GroupChannels}.reviewerSelector("chan1").(*roundRobinSelector)
+	assert.True(t, ok)
+}
@@ -0,0 +1,163 @@
+// A list of merge requests which failed processing and will not be retried, for review through the admin ui where they
+// can be sent back to the request queue. Held in memory, and written to disk alongside the request queue when the
+// queue uses the file backend so parked merge requests are kept after a restart.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errDeadLetterNotFound = errors.New("dead_letter_not_found")
+
+// deadLetter: letters are identified by their own id as job ids are reused once the request queue is compacted
+type deadLetter struct {
+	MR       MergeRequest `json:"mr"`
+	Err      string       `json:"error"`
+	Attempts int          `json:"attempts"`
+	FailedAt time.Time    `json:"failed_at"`
+}
+
+type deadLetterList struct {
+	mu      sync.RWMutex
+	nextID  uint64
+	letters map[uint64]deadLetter
+	// path: file the list is written to on every change, not written when empty
+	path string
+}
+
+func newDeadLetterList() *deadLetterList {
+	return &deadLetterList{
+		letters: make(map[uint64]deadLetter),
+	}
+}
+
+// newDeadLetters: create the dead letter list, written to disk next to the request queue when using the file backend
+func newDeadLetters(config Config) (*deadLetterList, error) {
+	if config.RequestQueue.Backend != storeFile {
+		return newDeadLetterList(), nil
+	}
+	queuePath := config.storePath(config.RequestQueue, "queue.log")
+	return newFileDeadLetterList(filepath.Join(filepath.Dir(queuePath), "dead_letters.json"))
+}
+
+// newFileDeadLetterList: create list loading any existing dead letters, a missing file is treated as no dead letters
+func newFileDeadLetterList(path string) (*deadLetterList, error) {
+	dl := newDeadLetterList()
+	dl.path = path
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.WithFields(log.Fields{"path": path}).Debug("no dead letters found, starting empty.")
+			return dl, nil
+		}
+		return nil, err
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &dl.letters); err != nil {
+			return nil, fmt.Errorf("'%s' invalid dead letters: %s", path, err)
+		}
+	}
+	for id := range dl.letters {
+		if id > dl.nextID {
+			dl.nextID = id
+		}
+	}
+	promDeadLettersParked.Set(float64(len(dl.letters)))
+	return dl, nil
+}
+
+// add: park the job, an error is returned when the list could not be written so the job is kept in the queue
+func (dl *deadLetterList) add(j job, err error) error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	dl.nextID++
+	id := dl.nextID
+	dl.letters[id] = deadLetter{MR: j.mr, Err: err.Error(), Attempts: j.attempts, FailedAt: time.Now()}
+	if err := dl.write(); err != nil {
+		delete(dl.letters, id)
+		return err
+	}
+
+	promDeadLetters.WithLabelValues(j.mr.Group()).Inc()
+	promDeadLettersParked.Set(float64(len(dl.letters)))
+	return nil
+}
+
+// remove: take a merge request out of the list returning it so it can be sent back to the queue
+func (dl *deadLetterList) remove(id uint64) (MergeRequest, error) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	letter, ok := dl.letters[id]
+	if !ok {
+		return MergeRequest{}, errDeadLetterNotFound
+	}
+	delete(dl.letters, id)
+	if err := dl.write(); err != nil {
+		dl.letters[id] = letter
+		return MergeRequest{}, err
+	}
+
+	promDeadLettersParked.Set(float64(len(dl.letters)))
+	return letter.MR, nil
+}
+
+// write: write the list to disk when a path is set, must hold lock
+func (dl *deadLetterList) write() error {
+	if dl.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(dl.letters)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(osFS{}, dl.path, data)
+}
+
+type deadLetterEntry struct {
+	ID                uint64
+	PathWithNamespace string
+	MergeReqID        int
+	MergeReqURL       string
+	MergeReqTitle     string
+	Attempts          int
+	Error             string
+	FailedAt          time.Time
+}
+
+// getList: return all dead letters ordered by when they failed
+func (dl *deadLetterList) getList() []deadLetterEntry {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+
+	entries := []deadLetterEntry{}
+	for id, letter := range dl.letters {
+		entries = append(entries, deadLetterEntry{
+			ID:                id,
+			PathWithNamespace: letter.MR.PathWithNamespace(),
+			MergeReqID:        letter.MR.MergeReqID(),
+			MergeReqURL:       letter.MR.MergeReqURL(),
+			MergeReqTitle:     letter.MR.MergeReqTitle(),
+			Attempts:          letter.Attempts,
+			Error:             letter.Err,
+			FailedAt:          letter.FailedAt,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FailedAt.Before(entries[j].FailedAt)
+	})
+	return entries
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"io"
+	"io/ioutil"
 	"os"
 )
 
 type fileSystem interface {
 	Open(name string) (file, error)
 	Stat(name string) (os.FileInfo, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	Rename(oldname, newname string) error
 }
 
 type file interface {
@@ -28,3 +31,11 @@ func (osFS) Open(name string) (file, error) {
 func (osFS) Stat(name string) (os.FileInfo, error) {
 	return os.Stat(name)
 }
+
+func (osFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(name, data, perm)
+}
+
+func (osFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
@@ -0,0 +1,88 @@
+// Availability from the gitlab user status, set more consistently than the slack status by some teams. A status with
+// availability set to busy is unavailable, otherwise the status message and emoji are matched against the user status
+// rules the same as a slack status. Statuses are cached by username for the ttl of the matching rule.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xanzy/go-gitlab"
+)
+
+const reasonBusy = "busy"
+
+// gitlabProvider: created per merge request as it requires the gitlab client, the status cache is shared
+type gitlabProvider struct {
+	gitClient GitlabWrapper
+	cache     UserCache
+	config    Config
+	mr        MergeRequests
+}
+
+func newGitlabProvider(gitClient GitlabWrapper, cache UserCache, config Config, mr MergeRequests) *gitlabProvider {
+	return &gitlabProvider{gitClient: gitClient, cache: cache, config: config, mr: mr}
+}
+
+func (gp *gitlabProvider) Name() string {
+	return providerGitlab
+}
+
+// Availability: busy or a status matching a user status rule marked unavailable is unavailable, users are only known
+// when busy or their status matches a rule
+func (gp *gitlabProvider) Availability(user *gitlab.BasicUser, now time.Time) (availability, error) {
+	status, err := gp.getStatus(user, now)
+	if err != nil {
+		return availability{}, err
+	}
+
+	ttl := time.Hour * time.Duration(getStatusTTL(gp.config.UserStatuses, status.status, status.statusEmoji))
+	if status.busy {
+		return availability{known: true, available: false, reason: reasonBusy, ttl: ttl}, nil
+	}
+	if reason, unavailable := statusUnavailable(gp.config.UserStatuses, status.status, status.statusEmoji); unavailable {
+		return availability{known: true, available: false, reason: reason, ttl: ttl}, nil
+	}
+	if !statusKnown(gp.config.UserStatuses, status.status, status.statusEmoji) {
+		return availability{}, nil
+	}
+	return availability{known: true, available: true, ttl: ttl}, nil
+}
+
+// getStatus: return the cached gitlab status of a user, requesting the status when missing or expired
+func (gp *gitlabProvider) getStatus(user *gitlab.BasicUser, now time.Time) (userMeta, error) {
+	if gp.cache != nil {
+		if cached, err := gp.cache.read(user.Username); err == nil {
+			return cached, nil
+		}
+	}
+
+	gitlabStatus, _, err := gp.gitClient.GetUserStatus(user.ID)
+	promGitlabReqs.WithLabelValues("user_status", "get", gp.mr.Group()).Inc()
+	if err != nil {
+		log.WithFields(log.Fields{"group": gp.mr.Group(), "username": user.Username, "error": err}).Error("failed to get gitlab user status.")
+		return userMeta{}, fmt.Errorf("gitlab: failed to get user status: %w", err)
+	}
+
+	status := userMeta{
+		username:    user.Username,
+		status:      strings.ToLower(gitlabStatus.Message),
+		statusEmoji: gitlabEmoji(gitlabStatus.Emoji),
+		busy:        gitlabStatus.Availability == gitlab.Busy,
+	}
+	if gp.cache != nil {
+		ttl := getStatusTTL(gp.config.UserStatuses, status.status, status.statusEmoji)
+		gp.cache.update(status, now.Add(time.Hour*time.Duration(ttl)).Unix())
+	}
+	return status, nil
+}
+
+// gitlabEmoji: gitlab returns the emoji name alone, wrapped in colons to match the same rules as slack emoji
+func gitlabEmoji(emoji string) string {
+	if emoji == "" {
+		return ""
+	}
+	return ":" + strings.Trim(emoji, ":") + ":"
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+// Tests
+
+func TestGitlabProvider(t *testing.T) {
+	mr := MergeRequest{group: "test", projectID: 1, mergeReqID: 1}
+	unavailable := false
+	config := Config{
+		UserStatuses: map[string]UserStatus{
+			"":            {TTL: 1},
+			"out sick":    {TTL: 8},
+			"thermometer": {TTL: 4, Emoji: ":thermometer:", Available: &unavailable},
+		},
+	}
+
+	type test struct {
+		user    *gitlab.BasicUser
+		want    availability
+		wantErr bool
+	}
+
+	tests := []test{
+		// a status without a matching rule is left to later providers
+		{user: &gitlab.BasicUser{ID: 1, Username: "test1"}, want: availability{}},
+		{user: &gitlab.BasicUser{ID: 5, Username: "test5"}, want: availability{known: true, available: false, reason: reasonBusy, ttl: time.Hour}},
+		// exact match of the message takes priority over the emoji
+		{user: &gitlab.BasicUser{ID: 6, Username: "test6"}, want: availability{known: true, available: false, reason: "out sick", ttl: time.Hour * 8}},
+		{user: &gitlab.BasicUser{ID: 7, Username: "test7"}, wantErr: true},
+	}
+
+	cache := newLocalCache()
+	for _, tc := range tests {
+		provider := newGitlabProvider(&mockGitlab{}, cache, config, mr)
+		got, err := provider.Availability(tc.user, time.Now())
+		assert.Equal(t, tc.wantErr, err != nil, tc.user.Username)
+		assert.Equal(t, tc.want, got, tc.user.Username)
+	}
+
+	// statuses cached for the ttl of the matching rule
+	cached, err := cache.read("test6")
+	assert.NoError(t, err)
+	assert.Equal(t, userMeta{username: "test6", status: "out sick", statusEmoji: ":thermometer:"}, cached)
+
+	cached, err = cache.read("test5")
+	assert.NoError(t, err)
+	assert.True(t, cached.busy)
+
+	_, err = cache.read("test7")
+	assert.Equal(t, errUserNotInCache, err)
+
+	// cached status used without requesting gitlab
+	cache.update(userMeta{username: "test1", busy: true}, time.Now().Add(time.Hour).Unix())
+	got, err := newGitlabProvider(&mockGitlab{}, cache, config, mr).Availability(&gitlab.BasicUser{ID: 1, Username: "test1"}, time.Now())
+	assert.NoError(t, err)
+	assert.False(t, got.available)
+}
+
+func TestGitlabEmoji(t *testing.T) {
+	assert.Equal(t, "", gitlabEmoji(""))
+	assert.Equal(t, ":palm_tree:", gitlabEmoji("palm_tree"))
+	assert.Equal(t, ":palm_tree:", gitlabEmoji(":palm_tree:"))
+}
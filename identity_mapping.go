@@ -0,0 +1,219 @@
+// Mapping of gitlab users to slack users. A gitlab user is matched by an override set in the configuration, then by
+// email (slack profile email against the gitlab public or primary email) and finally by the slack name equalling the
+// gitlab username. Gitlab users without a match are recorded so they can be mapped through the admin ui, and are not
+// matched again until the recheck interval has passed. Gitlab user emails are cached for the same interval.
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"github.com/xanzy/go-gitlab"
+)
+
+const (
+	matchOverride = "override"
+	matchEmail    = "email"
+	matchUsername = "username"
+
+	// identityRecheckInterval: how long unmatched users and gitlab user emails are kept before being looked up again
+	identityRecheckInterval = time.Hour
+)
+
+// matchSlackUsers: match each gitlab user against the passed slack users, returning the matched slack user keyed by
+// gitlab username and the gitlab users without a match
+func matchSlackUsers(gitClient GitlabWrapper, emails *gitlabEmails, gitUsers []*gitlab.BasicUser, slackUsers []slack.User, mappings map[string]string, mr MergeRequests) (map[string]slack.User, []*gitlab.BasicUser) {
+	logger := log.WithFields(log.Fields{"group": mr.Group(), "project_id": mr.ProjectID(), "merge_request_id": mr.MergeReqID()})
+
+	byID := make(map[string]slack.User)
+	byEmail := make(map[string]slack.User)
+	byName := make(map[string]slack.User)
+	for _, s := range slackUsers {
+		byID[s.ID] = s
+		if s.Profile.Email != "" {
+			byEmail[strings.ToLower(s.Profile.Email)] = s
+		}
+		byName[strings.ToLower(s.Name)] = s
+	}
+
+	matched := make(map[string]slack.User)
+	var unmatched []*gitlab.BasicUser
+	for _, gitUser := range gitUsers {
+		s, match := matchSlackUser(gitClient, emails, gitUser, byID, byEmail, byName, mappings)
+		if match == "" {
+			unmatched = append(unmatched, gitUser)
+			continue
+		}
+		promUserMatches.WithLabelValues(match, mr.Group()).Inc()
+		logger.WithFields(log.Fields{"username": gitUser.Username, "slack_user_id": s.ID, "match": match}).Debug("matched gitlab user to slack user.")
+		matched[gitUser.Username] = s
+	}
+	return matched, unmatched
+}
+
+// matchSlackUser: return the slack user and how the gitlab user was matched, empty when no match found
+func matchSlackUser(gitClient GitlabWrapper, emails *gitlabEmails, gitUser *gitlab.BasicUser, byID, byEmail, byName map[string]slack.User, mappings map[string]string) (slack.User, string) {
+	if slackUserID, ok := mappings[gitUser.Username]; ok {
+		if s, ok := byID[slackUserID]; ok {
+			return s, matchOverride
+		}
+	}
+
+	for _, email := range emails.get(gitClient, gitUser, time.Now()) {
+		if s, ok := byEmail[strings.ToLower(email)]; ok {
+			return s, matchEmail
+		}
+	}
+
+	if s, ok := byName[strings.ToLower(gitUser.Username)]; ok {
+		return s, matchUsername
+	}
+
+	return slack.User{}, ""
+}
+
+// getGitlabUserEmails: return the public and primary email of a gitlab user, the primary email is only returned when
+// the token belongs to an administrator. A failure is logged and treated as having no email.
+func getGitlabUserEmails(gitClient GitlabWrapper, gitUser *gitlab.BasicUser) ([]string, error) {
+	user, _, err := gitClient.GetUser(gitUser.ID, gitlab.GetUsersOptions{})
+	promGitlabReqs.WithLabelValues("users", "get", "").Inc()
+	if err != nil {
+		promErrors.WithLabelValues("get_gitlab_user").Inc()
+		log.WithFields(log.Fields{"username": gitUser.Username, "error": err}).Warn("failed to get gitlab user emails.")
+		return nil, err
+	}
+
+	var emails []string
+	for _, email := range []string{user.PublicEmail, user.Email} {
+		if email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails, nil
+}
+
+// gitlabEmails: emails of gitlab users keyed by user id, so matching does not request every unmatched user on every
+// merge request. Failed requests are not kept.
+type gitlabEmails struct {
+	mu     sync.Mutex
+	emails map[int]gitlabEmailsEntry
+}
+
+type gitlabEmailsEntry struct {
+	emails    []string
+	fetchedAt time.Time
+}
+
+func newGitlabEmails() *gitlabEmails {
+	return &gitlabEmails{
+		emails: make(map[int]gitlabEmailsEntry),
+	}
+}
+
+// get: return the cached emails of a gitlab user, requesting them when missing or older than the recheck interval
+func (ge *gitlabEmails) get(gitClient GitlabWrapper, gitUser *gitlab.BasicUser, now time.Time) []string {
+	ge.mu.Lock()
+	entry, ok := ge.emails[gitUser.ID]
+	ge.mu.Unlock()
+	if ok && now.Sub(entry.fetchedAt) < identityRecheckInterval {
+		return entry.emails
+	}
+
+	emails, err := getGitlabUserEmails(gitClient, gitUser)
+	if err != nil {
+		return nil
+	}
+
+	ge.mu.Lock()
+	defer ge.mu.Unlock()
+	ge.emails[gitUser.ID] = gitlabEmailsEntry{emails: emails, fetchedAt: now}
+	return emails
+}
+
+// applyUserMappings: set the slack user id for each mapping in the configuration, overriding any id already held in
+// the cache. Entries are added as expired so the slack status is fetched on first use.
+func applyUserMappings(cache UserCache, mappings map[string]string) {
+	for username, slackUserID := range mappings {
+		cachedUser, err := cache.read(username)
+		if err == nil && cachedUser.slackUserID == slackUserID {
+			continue
+		}
+		cache.update(userMeta{username: username, slackUserID: slackUserID}, 0)
+		log.WithFields(log.Fields{"username": username, "slack_user_id": slackUserID}).Debug("applied user mapping from configuration.")
+	}
+}
+
+// unmatchedUsers: gitlab users suggested as approvers without a matching slack user, reported through the admin ui
+type unmatchedUsers struct {
+	mu    sync.RWMutex
+	users map[string]unmatchedUser
+}
+
+type unmatchedUser struct {
+	Username string
+	Name     string
+	Group    string
+	LastSeen time.Time
+	// checkedAt: when last matched against the slack channel
+	checkedAt time.Time
+}
+
+func newUnmatchedUsers() *unmatchedUsers {
+	return &unmatchedUsers{
+		users: make(map[string]unmatchedUser),
+	}
+}
+
+func (uu *unmatchedUsers) add(gitUser *gitlab.BasicUser, group string) {
+	uu.mu.Lock()
+	defer uu.mu.Unlock()
+
+	now := time.Now()
+	uu.users[gitUser.Username] = unmatchedUser{Username: gitUser.Username, Name: gitUser.Name, Group: group, LastSeen: now, checkedAt: now}
+	promUsersUnmatched.Set(float64(len(uu.users)))
+}
+
+// toMatch: return the users not found unmatched within the recheck interval, users skipped are marked as seen
+func (uu *unmatchedUsers) toMatch(gitUsers []*gitlab.BasicUser, group string, now time.Time) []*gitlab.BasicUser {
+	uu.mu.Lock()
+	defer uu.mu.Unlock()
+
+	var match []*gitlab.BasicUser
+	for _, gitUser := range gitUsers {
+		u, ok := uu.users[gitUser.Username]
+		if !ok || now.Sub(u.checkedAt) >= identityRecheckInterval {
+			match = append(match, gitUser)
+			continue
+		}
+		u.Group, u.LastSeen = group, now
+		uu.users[gitUser.Username] = u
+	}
+	return match
+}
+
+func (uu *unmatchedUsers) remove(username string) {
+	uu.mu.Lock()
+	defer uu.mu.Unlock()
+
+	delete(uu.users, username)
+	promUsersUnmatched.Set(float64(len(uu.users)))
+}
+
+// getList: return all unmatched users ordered by username
+func (uu *unmatchedUsers) getList() []unmatchedUser {
+	uu.mu.RLock()
+	defer uu.mu.RUnlock()
+
+	list := []unmatchedUser{}
+	for _, u := range uu.users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Username < list[j].Username
+	})
+	return list
+}
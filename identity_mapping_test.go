@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+// Setup
+
+// countingGitlab: counts requests for gitlab users
+type countingGitlab struct {
+	mockGitlab
+	userRequests int
+}
+
+func (c *countingGitlab) GetUser(user int, opt gitlab.GetUsersOptions, options ...gitlab.RequestOptionFunc) (*gitlab.User, *gitlab.Response, error) {
+	c.userRequests++
+	return c.mockGitlab.GetUser(user, opt, options...)
+}
+
+// Tests
+
+func TestMatchSlackUsers(t *testing.T) {
+	mockGitClient := &mockGitlab{}
+	mockMR := MockMergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 1}
+
+	slackUsers := []slack.User{
+		{ID: "U1", Name: "test1"},
+		{ID: "U2", Name: "jane.doe", Profile: slack.UserProfile{Email: "test2@slack.local"}},
+		{ID: "U3", Name: "ldap4"},
+	}
+
+	type test struct {
+		gitUser  *gitlab.BasicUser
+		mappings map[string]string
+		want     string
+	}
+
+	tests := []test{
+		// slack name equals gitlab username
+		{gitUser: &gitlab.BasicUser{ID: 1, Username: "test1"}, want: "U1"},
+		// email preferred over username, compared ignoring case
+		{gitUser: &gitlab.BasicUser{ID: 4, Username: "ldap4"}, want: "U2"},
+		// configured mapping preferred over email
+		{gitUser: &gitlab.BasicUser{ID: 4, Username: "ldap4"}, mappings: map[string]string{"ldap4": "U1"}, want: "U1"},
+		// mapping to a user not in the channel falls back to matching
+		{gitUser: &gitlab.BasicUser{ID: 1, Username: "test1"}, mappings: map[string]string{"test1": "U9"}, want: "U1"},
+		// failure getting gitlab user emails still matches by username
+		{gitUser: &gitlab.BasicUser{ID: 7, Username: "test1"}, want: "U1"},
+		{gitUser: &gitlab.BasicUser{ID: 6, Username: "ldap6"}, want: ""},
+	}
+
+	for _, tc := range tests {
+		matched, unmatched := matchSlackUsers(mockGitClient, newGitlabEmails(), []*gitlab.BasicUser{tc.gitUser}, slackUsers, tc.mappings, mockMR)
+		if tc.want == "" {
+			assert.Len(t, matched, 0)
+			assert.Equal(t, []*gitlab.BasicUser{tc.gitUser}, unmatched)
+			continue
+		}
+		assert.Len(t, unmatched, 0)
+		assert.Equal(t, tc.want, matched[tc.gitUser.Username].ID)
+	}
+}
+
+func TestApplyUserMappings(t *testing.T) {
+	timeExpire := time.Now().Add(time.Hour * 8)
+
+	cache := newLocalCache()
+	cache.update(userMeta{username: "test1", slackUserID: "U1", status: "holiday"}, timeExpire.Unix())
+	cache.update(userMeta{username: "test2", slackUserID: "U9"}, timeExpire.Unix())
+
+	applyUserMappings(cache, map[string]string{"test1": "U1", "test2": "U2", "test3": "U3"})
+
+	// unchanged mapping keeps the cached status
+	assert.Equal(t, cachedUser{user: userMeta{username: "test1", slackUserID: "U1", status: "holiday"}, expireTimestamp: timeExpire.Unix()}, cache.users["test1"])
+	assert.Equal(t, cachedUser{user: userMeta{username: "test2", slackUserID: "U2"}}, cache.users["test2"])
+	assert.Equal(t, cachedUser{user: userMeta{username: "test3", slackUserID: "U3"}}, cache.users["test3"])
+}
+
+func TestUnmatchedUsers(t *testing.T) {
+	unmatched := newUnmatchedUsers()
+
+	unmatched.add(&gitlab.BasicUser{Username: "test2", Name: "Test 2"}, "test")
+	unmatched.add(&gitlab.BasicUser{Username: "test1", Name: "Test 1"}, "test")
+	unmatched.add(&gitlab.BasicUser{Username: "test2", Name: "Test 2"}, "other")
+
+	list := unmatched.getList()
+	assert.Len(t, list, 2)
+	assert.Equal(t, "test1", list[0].Username)
+	assert.Equal(t, "other", list[1].Group)
+
+	unmatched.remove("test1")
+	unmatched.remove("test3")
+	assert.Len(t, unmatched.getList(), 1)
+}
+
+func TestGitlabEmails(t *testing.T) {
+	gitClient := &countingGitlab{}
+	emails := newGitlabEmails()
+	now := time.Now()
+
+	user := &gitlab.BasicUser{ID: 4, Username: "ldap4"}
+	assert.Equal(t, []string{"Test2@slack.local"}, emails.get(gitClient, user, now))
+	assert.Equal(t, []string{"Test2@slack.local"}, emails.get(gitClient, user, now.Add(time.Minute)))
+	assert.Equal(t, 1, gitClient.userRequests)
+
+	// requested again once the recheck interval has passed
+	emails.get(gitClient, user, now.Add(identityRecheckInterval))
+	assert.Equal(t, 2, gitClient.userRequests)
+
+	// failed requests are not kept
+	failed := &gitlab.BasicUser{ID: 7, Username: "test7"}
+	assert.Nil(t, emails.get(gitClient, failed, now))
+	assert.Nil(t, emails.get(gitClient, failed, now))
+	assert.Equal(t, 4, gitClient.userRequests)
+}
+
+func TestUnmatchedUsersToMatch(t *testing.T) {
+	unmatched := newUnmatchedUsers()
+	test1 := &gitlab.BasicUser{Username: "test1"}
+	test2 := &gitlab.BasicUser{Username: "test2"}
+
+	unmatched.add(test1, "test")
+	now := time.Now()
+
+	// recently unmatched users are skipped and marked as seen
+	assert.Equal(t, []*gitlab.BasicUser{test2}, unmatched.toMatch([]*gitlab.BasicUser{test1, test2}, "other", now.Add(time.Minute)))
+	assert.Equal(t, "other", unmatched.getList()[0].Group)
+	assert.Equal(t, now.Add(time.Minute), unmatched.getList()[0].LastSeen)
+
+	// matched again once the recheck interval has passed
+	assert.Equal(t, []*gitlab.BasicUser{test1, test2}, unmatched.toMatch([]*gitlab.BasicUser{test1, test2}, "test", now.Add(identityRecheckInterval+time.Second)))
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 
 	health "github.com/nelkinda/health-go"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -25,6 +28,9 @@ func main() {
 	log.SetLevel(logLevel)
 	log.WithFields(log.Fields{"log_level": logLevel}).Info("set log level.")
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	gitlab_token := os.Getenv("GITLAB_TOKEN")
 	if gitlab_token == "" {
 		log.WithFields(log.Fields{"var": "GITLAB_TOKEN"}).Fatal("environment variable required.")
@@ -50,9 +56,13 @@ func main() {
 		log.WithFields(log.Fields{"var": "GITLAB_MR_WH_SLACK_TOKEN"}).Fatal("environment variable required.")
 	}
 
+	// Optional, buttons are only added to slack messages when set
+	slack_signing_secret := os.Getenv("GITLAB_MR_WH_SLACK_SIGNING_SECRET")
+
 	os := osFS{}
 	config := &Config{
-		ConfigPath: "./config/config.yaml",
+		ConfigPath:       "./config/config.yaml",
+		SlackInteractive: slack_signing_secret != "",
 	}
 	err = config.LoadConfig(&os)
 	if err != nil {
@@ -66,19 +76,38 @@ func main() {
 
 	slack := newSlackClient(slack_token)
 
-	scheduler, err := NewScheduler()
+	queue, err := newRequestQueue(*config)
+	if err != nil {
+		log.Fatalf("could not create request queue: %q.", err)
+	}
+
+	deadLetters, err := newDeadLetters(*config)
+	if err != nil {
+		log.Fatalf("could not create dead letter list: %q.", err)
+	}
+
+	scheduler, err := NewScheduler(queue, deadLetters, config.Retry, config.CoalesceWindow)
 	if err != nil {
 		log.Fatalf("could not create scheduler: %q.", err)
 	}
 
+	locks := newMRLocks()
+	unmatched := newUnmatchedUsers()
+	emails := newGitlabEmails()
+	notifications := newNotificationDispatcher()
 	for i := 0; i < runtime.NumCPU(); i++ {
-		worker := NewWorker()
+		worker := NewWorker(locks, unmatched, emails, notifications)
 		scheduler.AddWorker(worker)
 		promWorkers.Inc()
 	}
 
 	// create user cache
-	cache := newLocalCache()
+	cache, err := newUserCache(*config)
+	if err != nil {
+		log.Fatalf("could not create user cache: %q.", err)
+	}
+	applyUserMappings(cache, config.UserMappings)
+	startCacheRefresher(slack, cache, *config)
 
 	log.Info("starting scheduler.")
 	go scheduler.Run(git, slack, *config, cache)
@@ -92,7 +121,8 @@ func main() {
 		Secret:          webhook_secret,
 		EventsToAccept:  []gitlab.EventType{gitlab.EventTypeMergeRequest},
 		GitlabBotUserID: gitlab_bot_user_identity.ID,
-		Requests:        scheduler.requests,
+		Queue:           queue,
+		StatusUpdater:   newReviewStatusUpdater(slack, config.messages),
 	}
 
 	health_endpoint := health.New(
@@ -106,19 +136,87 @@ func main() {
 
 	// Handle Cache
 	cacheHandler := cacheHandler{
-		cache:  cache,
-		config: *config,
+		cache:     cache,
+		unmatched: unmatched,
+		config:    *config,
 	}
 	mux.Handle("/cache", cacheHandler)
 
+	// Handle Queue
+	queueHandler := queueHandler{
+		scheduler: scheduler,
+	}
+	mux.Handle("/queue", queueHandler)
+
+	// Handle Slack interactivity
+	if config.SlackInteractive {
+		interactionHandler := slackInteractionHandler{
+			signingSecret: slack_signing_secret,
+			gitClient:     git,
+			slack:         slack,
+			cache:         cache,
+			config:        *config,
+			locks:         locks,
+		}
+		mux.Handle("/slack/interactions", interactionHandler)
+	} else {
+		log.WithFields(log.Fields{"var": "GITLAB_MR_WH_SLACK_SIGNING_SECRET"}).Info("slack interactivity disabled, environment variable not set.")
+	}
+
 	// handle static files
 	fileServer := http.FileServer(http.Dir("./static/css"))
 	mux.Handle("/static/", http.StripPrefix("/static", fileServer))
 
 	log.WithFields(log.Fields{"ip": "0.0.0.0", "server port": server_port}).Info("starting web server.")
 
-	if err := http.ListenAndServe("0.0.0.0:"+server_port, mux); err != nil {
-		log.WithFields(log.Fields{"error": err}).Fatal("http server failed to start.")
+	server := &http.Server{
+		Addr:    "0.0.0.0:" + server_port,
+		Handler: mux,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithFields(log.Fields{"error": err}).Fatal("http server failed to start.")
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.WithFields(log.Fields{"timeout": config.shutdownTimeout()}).Info("shutting down.")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.shutdownTimeout())
+	defer cancel()
+
+	// Stop accepting webhooks first so nothing is added to the queue while draining
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		promErrors.WithLabelValues("http_shutdown").Inc()
+		log.WithFields(log.Fields{"error": err}).Error("failed to shutdown web server.")
+	}
+
+	reportUndrained(scheduler.Shutdown(shutdownCtx), queue.durable())
+	if !notifications.wait(shutdownCtx) {
+		promErrors.WithLabelValues("notifications_undelivered").Inc()
+		log.Warn("shutdown deadline passed before notifications were delivered.")
+	}
+
+	if err := queue.close(); err != nil {
+		promErrors.WithLabelValues("queue_close").Inc()
+		log.WithFields(log.Fields{"error": err}).Error("failed to close request queue.")
+	}
+	cache.close()
+
+	log.Info("shutdown complete.")
+}
+
+// Log each merge request not processed before shutdown, a durable queue replays them on the next start up
+func reportUndrained(jobs []job, durable bool) {
+	for _, j := range jobs {
+		promShutdownUndrained.WithLabelValues(j.mr.Group()).Inc()
+		logger := log.WithFields(log.Fields{"group": j.mr.Group(), "project_id": j.mr.ProjectID(), "merge_request_id": j.mr.MergeReqID(), "merge_request_url": j.mr.MergeReqURL(), "attempts": j.attempts})
+		if durable {
+			logger.Info("merge request not processed before shutdown, will be replayed on start up.")
+		} else {
+			logger.Warn("merge request not processed before shutdown, lost as request queue is not durable.")
+		}
 	}
 }
 
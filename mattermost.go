@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// mattermostNotifier: posts to a mattermost incoming webhook, reviewers are mentioned by their gitlab username which
+// matches the mattermost username when signing in through gitlab
+type mattermostNotifier struct {
+	url     string
+	channel string
+	client  *http.Client
+}
+
+type mattermostMessage struct {
+	Channel string `json:"channel,omitempty"`
+	Text    string `json:"text"`
+}
+
+func (mn *mattermostNotifier) Name() string {
+	return notifierMattermost
+}
+
+func (mn *mattermostNotifier) Notify(a assignment) error {
+	text := reviewMessage(a, func(reviewer *gitlab.BasicUser) string {
+		return "@" + reviewer.Username
+	})
+	if err := postWebhook(mn.client, mn.url, mattermostMessage{Channel: mn.channel, Text: text}); err != nil {
+		return fmt.Errorf("mattermost: failed to send message: %w", err)
+	}
+	return nil
+}
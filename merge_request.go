@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -8,9 +9,15 @@ import (
 	"github.com/xanzy/go-gitlab"
 )
 
+var (
+	errNoSuggestedApprovers = errors.New("no suggested approvers.")
+	errNoApprovalsRequired  = errors.New("approvals required is zero, will not assign reviewer.")
+)
+
 type MergeRequests interface {
 	getMR(gc GitlabWrapper) (error, *gitlab.MergeRequest)
 	getMRApprovers(gc GitlabWrapper) ([]*gitlab.BasicUser, int, error)
+	getMRCommits(gc GitlabWrapper) ([]*gitlab.Commit, error)
 	setMRReviwer(gc GitlabWrapper, reviewers []*gitlab.BasicUser) error
 	unsetMRReviwer(gc GitlabWrapper) error
 	PathWithNamespace() string
@@ -36,6 +43,52 @@ type MergeRequest struct {
 	workInProgress    bool
 }
 
+// mergeRequestRecord: exported copy of a MergeRequest used when encoding to json
+type mergeRequestRecord struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+	Group             string `json:"group"`
+	ProjectID         int    `json:"project_id"`
+	ProjectName       string `json:"project_name"`
+	ProjectWebURL     string `json:"project_web_url"`
+	MergeReqID        int    `json:"merge_request_id"`
+	MergeReqURL       string `json:"merge_request_url"`
+	MergeReqTitle     string `json:"merge_request_title"`
+	WorkInProgress    bool   `json:"work_in_progress"`
+}
+
+func (mr MergeRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mergeRequestRecord{
+		PathWithNamespace: mr.pathWithNamespace,
+		Group:             mr.group,
+		ProjectID:         mr.projectID,
+		ProjectName:       mr.projectName,
+		ProjectWebURL:     mr.projectWebURL,
+		MergeReqID:        mr.mergeReqID,
+		MergeReqURL:       mr.mergeReqURL,
+		MergeReqTitle:     mr.mergeReqTitle,
+		WorkInProgress:    mr.workInProgress,
+	})
+}
+
+func (mr *MergeRequest) UnmarshalJSON(data []byte) error {
+	var record mergeRequestRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	*mr = MergeRequest{
+		pathWithNamespace: record.PathWithNamespace,
+		group:             record.Group,
+		projectID:         record.ProjectID,
+		projectName:       record.ProjectName,
+		projectWebURL:     record.ProjectWebURL,
+		mergeReqID:        record.MergeReqID,
+		mergeReqURL:       record.MergeReqURL,
+		mergeReqTitle:     record.MergeReqTitle,
+		workInProgress:    record.WorkInProgress,
+	}
+	return nil
+}
+
 // Accessors
 
 func (mr MergeRequest) PathWithNamespace() string {
@@ -74,6 +127,11 @@ func (mr MergeRequest) WorkInProgress() bool {
 	return mr.workInProgress
 }
 
+// mergeRequestKey: identify a merge request across events by project and merge request id
+func mergeRequestKey(mr MergeRequests) string {
+	return fmt.Sprintf("%d/%d", mr.ProjectID(), mr.MergeReqID())
+}
+
 // Strip the project from the namespace path to determine the group with path
 // namespace and group are the same, the web ui uses group, whilst the api uses namespace
 func groupPath(namespaceWithPath string) (string, error) {
@@ -84,6 +142,14 @@ func groupPath(namespaceWithPath string) (string, error) {
 	return namespaceWithPath[:lastSlash], nil
 }
 
+// Return the http status code of a gitlab response, zero when no response received such as a connection error.
+func responseStatusCode(response *gitlab.Response) int {
+	if response == nil || response.Response == nil {
+		return 0
+	}
+	return response.StatusCode
+}
+
 // Return details of a MergeRequest.
 func (mr MergeRequest) getMR(gc GitlabWrapper) (error, *gitlab.MergeRequest) {
 	options := &gitlab.GetMergeRequestsOptions{}
@@ -91,32 +157,54 @@ func (mr MergeRequest) getMR(gc GitlabWrapper) (error, *gitlab.MergeRequest) {
 	promGitlabReqs.WithLabelValues("merge_requests", "get", mr.group).Inc()
 
 	if err != nil {
-		return fmt.Errorf("failed to get mr: %s, http_code: %d", err, response.StatusCode), nil
+		return fmt.Errorf("failed to get mr: %w, http_code: %d", err, responseStatusCode(response)), nil
 	}
 	return nil, result
 }
 
+// headPipelineStatus: status of the latest pipeline for the merge request, empty when no pipeline has run
+func headPipelineStatus(mrResult *gitlab.MergeRequest) string {
+	if mrResult.HeadPipeline != nil {
+		return mrResult.HeadPipeline.Status
+	}
+	if mrResult.Pipeline != nil {
+		return mrResult.Pipeline.Status
+	}
+	return ""
+}
+
 // Gets list of suggested approvers from merge request - this should match the list found in the CODEOWNERS file.
 func (mr MergeRequest) getMRApprovers(gc GitlabWrapper) ([]*gitlab.BasicUser, int, error) {
 	result, response, err := gc.GetConfiguration(mr.projectID, mr.mergeReqID)
 	promGitlabReqs.WithLabelValues("merge_requests", "get", mr.group).Inc()
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to get approvers: %s, http_code: %d", err, response.StatusCode)
+		return nil, 0, fmt.Errorf("failed to get approvers: %w, http_code: %d", err, responseStatusCode(response))
 	}
 
 	if len(result.SuggestedApprovers) == 0 {
 		promIgnoreActions.WithLabelValues("no_suggested_approvers", mr.group).Inc()
-		return nil, result.ApprovalsRequired, fmt.Errorf("no suggested approvers.")
+		return nil, result.ApprovalsRequired, errNoSuggestedApprovers
 	}
 
 	if result.ApprovalsRequired == 0 {
 		promIgnoreActions.WithLabelValues("approvals_required_zero", mr.Group()).Inc()
-		return nil, result.ApprovalsRequired, fmt.Errorf("approvals required is zero, will not assign reviewer.")
+		return nil, result.ApprovalsRequired, errNoApprovalsRequired
 	}
 
 	return result.SuggestedApprovers, result.ApprovalsRequired, nil
 }
 
+// Gets the commits on the merge request branch, limited to the most recent 100.
+func (mr MergeRequest) getMRCommits(gc GitlabWrapper) ([]*gitlab.Commit, error) {
+	options := &gitlab.GetMergeRequestCommitsOptions{PerPage: 100}
+	result, response, err := gc.GetMergeRequestCommits(mr.projectID, mr.mergeReqID, options)
+	promGitlabReqs.WithLabelValues("merge_requests", "get", mr.group).Inc()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commits: %w, http_code: %d", err, responseStatusCode(response))
+	}
+	return result, nil
+}
+
 // Update MergeRequest assigning Reviewers
 func (mr MergeRequest) setMRReviwer(gc GitlabWrapper, reviewers []*gitlab.BasicUser) error {
 	var reviewerIDs []int
@@ -130,7 +218,7 @@ func (mr MergeRequest) setMRReviwer(gc GitlabWrapper, reviewers []*gitlab.BasicU
 	_, response, err := gc.UpdateMergeRequest(mr.projectID, mr.mergeReqID, options)
 	promGitlabReqs.WithLabelValues("merge_requests", "patch", mr.group).Inc()
 	if err != nil {
-		return fmt.Errorf("failed to assign reviewer on project: %s, http_code: %d", err, response.StatusCode)
+		return fmt.Errorf("failed to assign reviewer on project: %w, http_code: %d", err, responseStatusCode(response))
 	}
 	return nil
 }
@@ -144,7 +232,26 @@ func (mr MergeRequest) unsetMRReviwer(gc GitlabWrapper) error {
 	_, response, err := gc.UpdateMergeRequest(mr.projectID, mr.mergeReqID, options)
 	promGitlabReqs.WithLabelValues("merge_requests", "patch", mr.group).Inc()
 	if err != nil {
-		return fmt.Errorf("failed to unassign reviewer on project: %s, http_code: %d", err, response.StatusCode)
+		return fmt.Errorf("failed to unassign reviewer on project: %w, http_code: %d", err, responseStatusCode(response))
 	}
 	return nil
 }
+
+// Count the open merge requests a user is currently assigned to review. Only a single result is requested as the
+// total is returned in the response pagination headers.
+func getOpenReviewCount(gc GitlabWrapper, userID int, group string) (int, error) {
+	state := "opened"
+	scope := "all"
+	options := &gitlab.ListMergeRequestsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 1},
+		State:       &state,
+		Scope:       &scope,
+		ReviewerID:  gitlab.ReviewerID(userID),
+	}
+	_, response, err := gc.ListMergeRequests(options)
+	promGitlabReqs.WithLabelValues("merge_requests", "list", group).Inc()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get open reviews for user: %d: %w", userID, err)
+	}
+	return response.TotalItems, nil
+}
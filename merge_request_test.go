@@ -16,6 +16,33 @@ import (
 	"github.com/xanzy/go-gitlab"
 )
 
+func (o *mockGitlab) GetUser(user int, opt gitlab.GetUsersOptions, options ...gitlab.RequestOptionFunc) (*gitlab.User, *gitlab.Response, error) {
+	switch user {
+	case 4:
+		return &gitlab.User{ID: user, PublicEmail: "Test2@slack.local"}, &gitlab.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+	case 7:
+		err := fmt.Errorf("GET https://gitlab.local/api/v4/users/%d: 404 {message: 404 User Not Found}", user)
+		return nil, &gitlab.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}, err
+	default:
+		return &gitlab.User{ID: user}, &gitlab.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+	}
+}
+
+// GetUserStatus: user 5 busy, 6 out sick, 7 not found, otherwise not set
+func (o *mockGitlab) GetUserStatus(user int, options ...gitlab.RequestOptionFunc) (*gitlab.UserStatus, *gitlab.Response, error) {
+	switch user {
+	case 5:
+		return &gitlab.UserStatus{Availability: gitlab.Busy, Message: "Focusing"}, &gitlab.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+	case 6:
+		return &gitlab.UserStatus{Availability: gitlab.NotSet, Message: "Out Sick", Emoji: "thermometer"}, &gitlab.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+	case 7:
+		err := fmt.Errorf("GET https://gitlab.local/api/v4/users/%d/status: 404 {message: 404 User Not Found}", user)
+		return nil, &gitlab.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}, err
+	default:
+		return &gitlab.UserStatus{Availability: gitlab.NotSet}, &gitlab.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+	}
+}
+
 // Setup
 
 type mockGitlab struct {
@@ -166,6 +193,50 @@ func (o *mockGitlab) UpdateMergeRequest(pid interface{}, mergeRequest int, opt *
 	return nil, r, err
 }
 
+func (o *mockGitlab) ListMergeRequests(opt *gitlab.ListMergeRequestsOptions, options ...gitlab.RequestOptionFunc) ([]*gitlab.MergeRequest, *gitlab.Response, error) {
+	var totalItems int
+	var err error
+	var http_response *http.Response
+
+	// Reviewer ID value is not exported, the json encoding is the ID itself
+	reviewerID, _ := json.Marshal(opt.ReviewerID)
+	switch string(reviewerID) {
+	case "1":
+		totalItems = 5
+		http_response = &http.Response{StatusCode: http.StatusOK}
+	case "2":
+		totalItems = 0
+		http_response = &http.Response{StatusCode: http.StatusOK}
+	case "3":
+		totalItems = 2
+		http_response = &http.Response{StatusCode: http.StatusOK}
+	default:
+		err = fmt.Errorf("GET https://gitlab.local/api/v4/merge_requests: 500 {message: 500 Internal Server Error}")
+		http_response = &http.Response{StatusCode: http.StatusInternalServerError}
+	}
+
+	r := &gitlab.Response{
+		Response:   http_response,
+		TotalItems: totalItems,
+	}
+
+	return nil, r, err
+}
+
+func (o *mockGitlab) GetMergeRequestCommits(pid interface{}, mergeRequest int, opt *gitlab.GetMergeRequestCommitsOptions, options ...gitlab.RequestOptionFunc) ([]*gitlab.Commit, *gitlab.Response, error) {
+	switch mergeRequest {
+	case 1:
+		commits := []*gitlab.Commit{
+			{AuthorName: "Test 1", AuthorEmail: "test1@gitlab.local"},
+			{AuthorName: "Test 2", AuthorEmail: "test2@gitlab.local"},
+		}
+		return commits, &gitlab.Response{Response: &http.Response{StatusCode: http.StatusOK}}, nil
+	default:
+		err := fmt.Errorf("GET https://gitlab.local/api/v4/projects/%d/merge_requests/%d/commits: 404 {message: 404 Not Found}", pid, mergeRequest)
+		return nil, &gitlab.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}, err
+	}
+}
+
 // Setup
 
 func TestMain(m *testing.M) {
@@ -366,3 +437,84 @@ func TestGroupPath(t *testing.T) {
 		assert.Equal(t, compare, tc.want)
 	}
 }
+
+func TestGetOpenReviewCount(t *testing.T) {
+	type test struct {
+		userID int
+		want   int
+		err    string
+	}
+
+	tests := []test{
+		{userID: 1, want: 5, err: ""},
+		{userID: 2, want: 0, err: ""},
+		{userID: 3, want: 2, err: ""},
+		{userID: 4, want: 0, err: "failed to get open reviews for user: 4: GET https://gitlab.local/api/v4/merge_requests: 500 {message: 500 Internal Server Error}"},
+	}
+
+	m := &mockGitlab{}
+
+	for _, tc := range tests {
+		got, err := getOpenReviewCount(m, tc.userID, "test")
+		if err != nil {
+			assert.Equal(t, tc.err, err.Error())
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.want, got)
+	}
+}
+
+func TestGetMRCommits(t *testing.T) {
+	type test struct {
+		mergeReqID int
+		want       int
+		err        string
+	}
+
+	tests := []test{
+		{mergeReqID: 1, want: 2, err: ""},
+		{mergeReqID: 2, want: 0, err: "failed to get commits: GET https://gitlab.local/api/v4/projects/1/merge_requests/2/commits: 404 {message: 404 Not Found}, http_code: 404"},
+	}
+
+	m := &mockGitlab{}
+
+	for _, tc := range tests {
+		mr := MergeRequest{
+			pathWithNamespace: "test/test",
+			group:             "test",
+			projectID:         1,
+			mergeReqID:        tc.mergeReqID,
+		}
+
+		got, err := mr.getMRCommits(m)
+		if err != nil {
+			assert.Equal(t, tc.err, err.Error())
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Len(t, got, tc.want)
+	}
+}
+
+func TestMergeRequestJSON(t *testing.T) {
+	mr := MergeRequest{
+		pathWithNamespace: "test/test",
+		group:             "test",
+		projectID:         1,
+		projectName:       "test",
+		projectWebURL:     "https://gitlab.local/test/test",
+		mergeReqID:        2,
+		mergeReqURL:       "https://gitlab.local/test/test/-/merge_requests/2",
+		mergeReqTitle:     "test",
+		workInProgress:    true,
+	}
+
+	data, err := json.Marshal(mr)
+	assert.NoError(t, err)
+
+	var got MergeRequest
+	err = json.Unmarshal(data, &got)
+	assert.NoError(t, err)
+	assert.Equal(t, mr, got)
+}
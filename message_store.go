@@ -0,0 +1,150 @@
+// A store for the slack message sent for each merge request, so the message can be updated as the merge request is
+// approved, merged or closed. Messages are removed once the merge request is merged or closed, messages for merge
+// requests left open are dropped after the retention period.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const messageRetention = 30 * 24 * time.Hour
+
+// sentMessage: slack message sent for a merge request and the approvals shown on it
+type sentMessage struct {
+	ChannelID  string    `json:"channel_id"`
+	Timestamp  string    `json:"ts"`
+	Text       string    `json:"text"`
+	Footer     string    `json:"footer,omitempty"`
+	SentAt     time.Time `json:"sent_at"`
+	ApprovedBy []string  `json:"approved_by,omitempty"`
+	// FooterSet: the footer was recorded, messages saved before footers were recorded were sent with the default footer
+	FooterSet bool `json:"footer_set,omitempty"`
+	// Interactive: sent with buttons, kept on the message until merged or closed
+	Interactive bool `json:"interactive,omitempty"`
+}
+
+type messageStore interface {
+	load(key string) (sentMessage, bool)
+	save(key string, m sentMessage) error
+	delete(key string) error
+}
+
+// newMessageStore: create the message store backend set in the configuration, defaults to memory
+func newMessageStore(config Config) (messageStore, error) {
+	switch config.MessageStore.Backend {
+	case storeFile:
+		return newFileMessageStore(config.storePath(config.MessageStore, "messages.json"))
+	default:
+		return newMemoryMessageStore(), nil
+	}
+}
+
+// memoryMessageStore: messages lost on restart, used when no file backend configured
+type memoryMessageStore struct {
+	mu       sync.RWMutex
+	messages map[string]sentMessage
+}
+
+func newMemoryMessageStore() *memoryMessageStore {
+	return &memoryMessageStore{
+		messages: make(map[string]sentMessage),
+	}
+}
+
+func (ms *memoryMessageStore) load(key string) (sentMessage, bool) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	m, ok := ms.messages[key]
+	return m, ok
+}
+
+func (ms *memoryMessageStore) save(key string, m sentMessage) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.messages[key] = m
+	pruneMessages(ms.messages, time.Now())
+	return nil
+}
+
+func (ms *memoryMessageStore) delete(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.messages, key)
+	return nil
+}
+
+// fileMessageStore: messages held in memory and written as json to disk on every change
+type fileMessageStore struct {
+	*memoryMessageStore
+	path string
+}
+
+// newFileMessageStore: create store loading any existing messages, a missing file is treated as no messages
+func newFileMessageStore(path string) (*fileMessageStore, error) {
+	fs := &fileMessageStore{
+		memoryMessageStore: newMemoryMessageStore(),
+		path:               path,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.WithFields(log.Fields{"path": path}).Debug("no slack messages found, starting empty.")
+			return fs, nil
+		}
+		return nil, err
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &fs.messages); err != nil {
+			return nil, fmt.Errorf("'%s' invalid slack messages: %s", path, err)
+		}
+	}
+
+	return fs, nil
+}
+
+func (fs *fileMessageStore) save(key string, m sentMessage) error {
+	if err := fs.memoryMessageStore.save(key, m); err != nil {
+		return err
+	}
+	return fs.write()
+}
+
+func (fs *fileMessageStore) delete(key string) error {
+	if err := fs.memoryMessageStore.delete(key); err != nil {
+		return err
+	}
+	return fs.write()
+}
+
+func (fs *fileMessageStore) write() error {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	data, err := json.Marshal(fs.messages)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(osFS{}, fs.path, data)
+}
+
+// pruneMessages: remove messages older than the retention period, their merge requests are unlikely to be updated
+func pruneMessages(messages map[string]sentMessage, now time.Time) {
+	for key, m := range messages {
+		if now.Sub(m.SentAt) > messageRetention {
+			delete(messages, key)
+		}
+	}
+}
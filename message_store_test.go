@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests
+
+func TestMemoryMessageStore(t *testing.T) {
+	store := newMemoryMessageStore()
+
+	_, ok := store.load("1/1")
+	assert.False(t, ok)
+
+	m := sentMessage{ChannelID: "C1", Timestamp: "1660000000.000100", Text: "text", SentAt: time.Now()}
+	assert.NoError(t, store.save("1/1", m))
+	got, ok := store.load("1/1")
+	assert.True(t, ok)
+	assert.Equal(t, m, got)
+
+	// messages older than the retention period are dropped on save
+	assert.NoError(t, store.save("1/2", sentMessage{ChannelID: "C1", SentAt: time.Now().Add(-messageRetention - time.Hour)}))
+	_, ok = store.load("1/2")
+	assert.False(t, ok)
+
+	assert.NoError(t, store.delete("1/1"))
+	_, ok = store.load("1/1")
+	assert.False(t, ok)
+}
+
+func TestFileMessageStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "messages.json")
+
+	store, err := newFileMessageStore(path)
+	assert.NoError(t, err)
+
+	m := sentMessage{ChannelID: "C1", Timestamp: "1660000000.000100", Text: "text", SentAt: time.Now().UTC().Truncate(time.Second), ApprovedBy: []string{"Test User"}}
+	assert.NoError(t, store.save("1/1", m))
+
+	// messages kept after a restart
+	store, err = newFileMessageStore(path)
+	assert.NoError(t, err)
+	got, ok := store.load("1/1")
+	assert.True(t, ok)
+	assert.Equal(t, m, got)
+
+	assert.NoError(t, store.delete("1/1"))
+	store, err = newFileMessageStore(path)
+	assert.NoError(t, err)
+	_, ok = store.load("1/1")
+	assert.False(t, ok)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"1/1":`), 0600))
+	_, err = newFileMessageStore(path)
+	assert.Error(t, err)
+}
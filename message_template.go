@@ -0,0 +1,166 @@
+// Templates for the slack review message and footer, set per group with a global default. Templates are parsed and
+// rendered against sample data on load so a broken template fails on start up rather than when a merge request is
+// processed.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xanzy/go-gitlab"
+)
+
+const (
+	defaultMessageTemplate = `{{join .Reviewers ", "}} you have been selected to review <{{.URL}}|{{escape .Title}}> in <{{.ProjectURL}}|{{escape .Project}}>`
+	defaultFooterTemplate  = "Selections based on CODEOWNERS file"
+)
+
+var templateFuncs = template.FuncMap{
+	"join":   strings.Join,
+	"escape": escapeSlack,
+}
+
+// defaultTemplates: built in templates used when neither the group nor global templates are set
+var defaultTemplates = &messageTemplate{
+	message: template.Must(template.New("message").Funcs(templateFuncs).Parse(defaultMessageTemplate)),
+	footer:  template.Must(template.New("footer").Funcs(templateFuncs).Parse(defaultFooterTemplate)),
+}
+
+// MessageTemplates - text/template for the slack review message and footer, unset templates fall back to the global
+// templates then the built in templates
+type MessageTemplates struct {
+	Message string `yaml:"message"`
+	Footer  string `yaml:"footer"`
+}
+
+// templateData: merge request details available to templates, reviewers formatted as slack mentions
+type templateData struct {
+	Title             string
+	URL               string
+	Project           string
+	ProjectURL        string
+	Author            string
+	Reviewers         []string
+	Labels            []string
+	Changes           string
+	ApprovalsRequired int
+}
+
+// sampleTemplateData: rendered on load to catch templates referring to unknown fields
+var sampleTemplateData = templateData{
+	Title:             "Add feature",
+	URL:               "https://gitlab.local/group/project/-/merge_requests/1",
+	Project:           "project",
+	ProjectURL:        "https://gitlab.local/group/project",
+	Author:            "Author",
+	Reviewers:         []string{"<@U1>", "Reviewer"},
+	Labels:            []string{"backend"},
+	Changes:           "10",
+	ApprovalsRequired: 2,
+}
+
+type messageTemplate struct {
+	message *template.Template
+	footer  *template.Template
+}
+
+// newMessageTemplate: parse the first template set for each of the message and footer, in order of precedence, falling
+// back to the built in templates. Templates are rendered against sample data to validate them.
+func newMessageTemplate(templates ...MessageTemplates) (*messageTemplate, error) {
+	mt := &messageTemplate{message: defaultTemplates.message, footer: defaultTemplates.footer}
+
+	var message, footer string
+	for _, t := range templates {
+		if message == "" {
+			message = t.Message
+		}
+		if footer == "" {
+			footer = t.Footer
+		}
+	}
+
+	var err error
+	if message != "" {
+		if mt.message, err = template.New("message").Funcs(templateFuncs).Parse(message); err != nil {
+			return nil, fmt.Errorf("message template: %s", err)
+		}
+	}
+	if footer != "" {
+		if mt.footer, err = template.New("footer").Funcs(templateFuncs).Parse(footer); err != nil {
+			return nil, fmt.Errorf("footer template: %s", err)
+		}
+	}
+
+	if _, _, err := mt.render(sampleTemplateData); err != nil {
+		return nil, err
+	}
+	return mt, nil
+}
+
+// render: return the message and footer text for the merge request
+func (mt *messageTemplate) render(data templateData) (string, string, error) {
+	var message, footer bytes.Buffer
+	if err := mt.message.Execute(&message, data); err != nil {
+		return "", "", fmt.Errorf("message template: %s", err)
+	}
+	if err := mt.footer.Execute(&footer, data); err != nil {
+		return "", "", fmt.Errorf("footer template: %s", err)
+	}
+	return message.String(), footer.String(), nil
+}
+
+// newTemplateData: template data for the assignment, each reviewer formatted with the mention for the chat
+func newTemplateData(a assignment, mention func(*gitlab.BasicUser) string) templateData {
+	data := templateData{
+		Title:             a.mr.MergeReqTitle(),
+		URL:               a.mr.MergeReqURL(),
+		Project:           a.mr.ProjectName(),
+		ProjectURL:        a.mr.ProjectWebURL(),
+		Labels:            a.labels,
+		Changes:           a.changes,
+		ApprovalsRequired: a.approvalsRequired,
+	}
+	if a.author != nil {
+		data.Author = a.author.Name
+		if data.Author == "" {
+			data.Author = a.author.Username
+		}
+	}
+	for _, reviewer := range a.reviewers {
+		data.Reviewers = append(data.Reviewers, mention(reviewer))
+	}
+	return data
+}
+
+// loadTemplates: parse the message templates for each group, overriding the global templates
+func (c *Config) loadTemplates() error {
+	c.templates = make(map[string]*messageTemplate)
+	if _, err := newMessageTemplate(c.Templates); err != nil {
+		return fmt.Errorf("templates: %s", err)
+	}
+	for group, groupChannel := range c.GroupChannels {
+		mt, err := newMessageTemplate(groupChannel.Templates, c.Templates)
+		if err != nil {
+			return fmt.Errorf("group: %s: templates: %s", group, err)
+		}
+		c.templates[group] = mt
+	}
+	return nil
+}
+
+// messageTemplate: return the message templates for a group, parsed on load otherwise when the config was not loaded
+// from file. Invalid templates are logged and the built in templates used.
+func (c Config) messageTemplate(group string) *messageTemplate {
+	if mt, ok := c.templates[group]; ok {
+		return mt
+	}
+	mt, err := newMessageTemplate(c.GroupChannels[group].Templates, c.Templates)
+	if err != nil {
+		log.WithFields(log.Fields{"group": group, "error": err}).Error("invalid message template, using default.")
+		return defaultTemplates
+	}
+	return mt
+}
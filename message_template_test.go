@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+// Tests
+
+func TestNewMessageTemplate(t *testing.T) {
+	type test struct {
+		name        string
+		templates   []MessageTemplates
+		wantMessage string
+		wantFooter  string
+		wantErr     bool
+	}
+
+	tests := []test{
+		{
+			name:        "default",
+			wantMessage: "<@U1>, Reviewer you have been selected to review <https://gitlab.local/group/project/-/merge_requests/1|Add feature> in <https://gitlab.local/group/project|project>",
+			wantFooter:  "Selections based on CODEOWNERS file",
+		},
+		{
+			name:        "group overrides global",
+			templates:   []MessageTemplates{{Message: "group {{.Title}}"}, {Message: "global {{.Title}}", Footer: "global footer"}},
+			wantMessage: "group Add feature",
+			wantFooter:  "global footer",
+		},
+		{
+			name:        "all fields",
+			templates:   []MessageTemplates{{Message: "{{.Author}} {{join .Labels \",\"}} {{.Changes}} {{.ApprovalsRequired}} {{index .Reviewers 0}}", Footer: "{{.Project}}"}},
+			wantMessage: "Author backend 10 2 <@U1>",
+			wantFooter:  "project",
+		},
+		{name: "parse error", templates: []MessageTemplates{{Message: "{{.Title"}}, wantErr: true},
+		{name: "unknown field", templates: []MessageTemplates{{Footer: "{{.Size}}"}}, wantErr: true},
+		{name: "unknown function", templates: []MessageTemplates{{Message: "{{upper .Title}}"}}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		mt, err := newMessageTemplate(tc.templates...)
+		if tc.wantErr {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+
+		message, footer, err := mt.render(sampleTemplateData)
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.wantMessage, message, tc.name)
+		assert.Equal(t, tc.wantFooter, footer, tc.name)
+	}
+
+	// default message escapes the title and project name
+	data := sampleTemplateData
+	data.Title = "Fix <!here> a > b & c"
+	data.Project = "<project>"
+	message, _, err := defaultTemplates.render(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "<@U1>, Reviewer you have been selected to review <https://gitlab.local/group/project/-/merge_requests/1|Fix &lt;!here&gt; a &gt; b &amp; c> in <https://gitlab.local/group/project|&lt;project&gt;>", message)
+}
+
+func TestNewTemplateData(t *testing.T) {
+	a := notifierAssignment
+	a.author = &gitlab.BasicUser{ID: 5, Username: "test5"}
+	a.labels = []string{"backend", "bug"}
+	a.changes = "35"
+
+	got := newTemplateData(a, func(u *gitlab.BasicUser) string { return "@" + u.Username })
+	assert.Equal(t, templateData{
+		Title:             "Add feature",
+		URL:               "https://gitlab.local/test/project/-/merge_requests/1",
+		Project:           "project",
+		ProjectURL:        "https://gitlab.local/test/project",
+		Author:            "test5",
+		Reviewers:         []string{"@test1", "@test2"},
+		Labels:            []string{"backend", "bug"},
+		Changes:           "35",
+		ApprovalsRequired: 2,
+	}, got)
+}
+
+func TestConfigMessageTemplate(t *testing.T) {
+	config := Config{
+		Templates: MessageTemplates{Footer: "global"},
+		GroupChannels: map[string]GroupChannel{
+			"custom":  {Templates: MessageTemplates{Message: "{{.Title}}"}},
+			"invalid": {Templates: MessageTemplates{Message: "{{.Title"}},
+		},
+	}
+
+	message, footer, err := config.messageTemplate("custom").render(sampleTemplateData)
+	assert.NoError(t, err)
+	assert.Equal(t, "Add feature", message)
+	assert.Equal(t, "global", footer)
+
+	assert.Equal(t, defaultTemplates, config.messageTemplate("invalid"))
+	assert.Error(t, config.loadTemplates())
+}
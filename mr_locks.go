@@ -0,0 +1,58 @@
+// Locks keyed by merge request so two workers never process the same merge request at the same time
+package main
+
+import (
+	"sync"
+)
+
+// mrLock: reference counted so the lock is removed once no worker holds or waits on it
+type mrLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+type mrLocks struct {
+	mu    sync.Mutex
+	locks map[string]*mrLock
+}
+
+func newMRLocks() *mrLocks {
+	return &mrLocks{
+		locks: make(map[string]*mrLock),
+	}
+}
+
+// lock: block until the lock for the key is held, returning the function to release it and if another worker already
+// held or was waiting on the lock
+func (l *mrLocks) lock(key string) (func(), bool) {
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &mrLock{}
+		l.locks[key] = lock
+	}
+	contended := lock.refs > 0
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, key)
+		}
+	}, contended
+}
+
+// size: number of merge requests locked or waiting on a lock
+func (l *mrLocks) size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.locks)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests
+
+func TestMRLocks(t *testing.T) {
+	locks := newMRLocks()
+
+	unlock, contended := locks.lock("1/1")
+	assert.False(t, contended)
+
+	// a different merge request is not blocked
+	unlockOther, contended := locks.lock("1/2")
+	assert.False(t, contended)
+	unlockOther()
+
+	acquired := make(chan bool, 1)
+	go func() {
+		unlock, contended := locks.lock("1/1")
+		acquired <- contended
+		unlock()
+	}()
+
+	assert.Never(t, func() bool { return len(acquired) > 0 }, 20*time.Millisecond, time.Millisecond)
+
+	unlock()
+	assert.True(t, <-acquired)
+	assert.Eventually(t, func() bool { return locks.size() == 0 }, time.Second, time.Millisecond)
+}
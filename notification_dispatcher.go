@@ -0,0 +1,108 @@
+// Delivery of reviewer notifications once reviewers are assigned. Notifications are sent in the background so the
+// worker releases the merge request lock straight away, failures worth retrying are retried with backoff without
+// failing the merge request as retrying it would find the reviewers already assigned and never notify.
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultNotifyMaxAttempts    = 3
+	defaultNotifyInitialBackoff = time.Second
+	defaultNotifyMaxBackoff     = 10 * time.Second
+)
+
+// defaultNotifyRetry: retry used for notifiers without their own retry settings
+var defaultNotifyRetry = Retry{
+	MaxAttempts:    defaultNotifyMaxAttempts,
+	InitialBackoff: defaultNotifyInitialBackoff,
+	MaxBackoff:     defaultNotifyMaxBackoff,
+}
+
+// retryPolicy: implemented by notifiers with retry settings from the configuration file
+type retryPolicy interface {
+	retryPolicy() Retry
+}
+
+// webhookStatusError: unexpected response from a webhook, worth retrying on rate limiting and 5xx responses
+type webhookStatusError struct {
+	status string
+	code   int
+}
+
+func (e webhookStatusError) Error() string {
+	return fmt.Sprintf("unexpected webhook response: %s", e.status)
+}
+
+func (e webhookStatusError) Retryable() bool {
+	return e.code >= 500 || e.code == 429
+}
+
+type notificationDispatcher struct {
+	wg sync.WaitGroup
+}
+
+func newNotificationDispatcher() *notificationDispatcher {
+	return &notificationDispatcher{}
+}
+
+// send: deliver the assignment in the background
+func (nd *notificationDispatcher) send(notifier Notifier, a assignment) {
+	nd.wg.Add(1)
+	go func() {
+		defer nd.wg.Done()
+		nd.deliver(notifier, a)
+	}()
+}
+
+// deliver: notify retrying transient errors with backoff until reaching max attempts
+func (nd *notificationDispatcher) deliver(notifier Notifier, a assignment) {
+	logger := log.WithFields(log.Fields{"group": a.mr.Group(), "project_id": a.mr.ProjectID(), "merge_request_id": a.mr.MergeReqID(), "notifier": notifier.Name()})
+
+	retry := defaultNotifyRetry
+	if rp, ok := notifier.(retryPolicy); ok {
+		retry = rp.retryPolicy()
+	}
+
+	promNotifications.WithLabelValues(notifier.Name(), a.mr.Group()).Inc()
+	for attempt := 1; ; attempt++ {
+		err := notifier.Notify(a)
+		if err == nil {
+			logger.Debug("sent notification.")
+			return
+		}
+		if classifyError(err) != errorTransient || attempt >= retry.MaxAttempts {
+			promNotificationErrors.WithLabelValues(notifier.Name(), a.mr.Group()).Inc()
+			logger.WithFields(log.Fields{"error": err, "attempts": attempt}).Error("failed to send notification.")
+			return
+		}
+
+		backoff := retry.backoff(attempt)
+		promNotificationRetries.WithLabelValues(notifier.Name(), a.mr.Group()).Inc()
+		logger.WithFields(log.Fields{"error": err, "attempt": attempt, "backoff": backoff}).Warn("failed to send notification, retrying.")
+		time.Sleep(backoff)
+	}
+}
+
+// wait: block until all notifications are delivered or the context is done, false when notifications were still being
+// delivered
+func (nd *notificationDispatcher) wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		nd.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Setup
+
+// flakyNotifier: fails with each error in turn then succeeds, recording the number of attempts
+type flakyNotifier struct {
+	mu       sync.Mutex
+	errs     []error
+	attempts int
+}
+
+func (fn *flakyNotifier) Name() string {
+	return "flaky"
+}
+
+func (fn *flakyNotifier) Notify(a assignment) error {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+
+	fn.attempts++
+	if len(fn.errs) > 0 {
+		var err error
+		err, fn.errs = fn.errs[0], fn.errs[1:]
+		return err
+	}
+	return nil
+}
+
+func (fn *flakyNotifier) retryPolicy() Retry {
+	return Retry{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+}
+
+// slowNotifier: waits before notifying
+type slowNotifier struct {
+	Notifier
+	delay time.Duration
+}
+
+func (sn *slowNotifier) Notify(a assignment) error {
+	time.Sleep(sn.delay)
+	return sn.Notifier.Notify(a)
+}
+
+// Tests
+
+func TestNotificationDispatcher(t *testing.T) {
+	unavailable := webhookStatusError{status: "503 Service Unavailable", code: http.StatusServiceUnavailable}
+
+	type test struct {
+		name         string
+		errs         []error
+		wantAttempts int
+	}
+
+	tests := []test{
+		{name: "sent", wantAttempts: 1},
+		{name: "retried", errs: []error{unavailable, unavailable}, wantAttempts: 3},
+		{name: "max attempts", errs: []error{unavailable, unavailable, unavailable, unavailable}, wantAttempts: 3},
+		// errors which will fail again are not retried
+		{name: "permanent", errs: []error{webhookStatusError{status: "404 Not Found", code: http.StatusNotFound}}, wantAttempts: 1},
+		{name: "unknown", errs: []error{errors.New("channel_not_found")}, wantAttempts: 1},
+	}
+
+	for _, tc := range tests {
+		notifier := &flakyNotifier{errs: tc.errs}
+		dispatcher := newNotificationDispatcher()
+		dispatcher.send(notifier, notifierAssignment)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		assert.True(t, dispatcher.wait(ctx), tc.name)
+		cancel()
+		assert.Equal(t, tc.wantAttempts, notifier.attempts, tc.name)
+	}
+}
+
+func TestNotificationDispatcherWait(t *testing.T) {
+	notifier := &flakyNotifier{errs: []error{webhookStatusError{status: "503 Service Unavailable", code: http.StatusServiceUnavailable}}}
+	dispatcher := newNotificationDispatcher()
+	dispatcher.send(&slowNotifier{Notifier: notifier, delay: time.Second}, notifierAssignment)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.False(t, dispatcher.wait(ctx))
+}
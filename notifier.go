@@ -0,0 +1,317 @@
+// Notification of the reviewers selected for a merge request, sent to the chat set per group. Slack is the default,
+// Mattermost and Microsoft Teams are sent through an incoming webhook. Assignments can also be sent to outbound webhooks
+// set per group in addition to the chat.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xanzy/go-gitlab"
+)
+
+const (
+	notifierSlack      = "slack"
+	notifierMattermost = "mattermost"
+	notifierTeams      = "teams"
+)
+
+const notifierTimeout = 10 * time.Second
+
+// Notifier - sends the reviewers selected for a merge request to a chat or webhook
+type Notifier interface {
+	Name() string
+	Notify(a assignment) error
+}
+
+// assignment: reviewers selected for a merge request and the approvers excluded from selection
+type assignment struct {
+	mr                MergeRequests
+	reviewers         []*gitlab.BasicUser
+	approvalsRequired int
+	exclusions        []exclusion
+	// author, labels, changes, pipelineStatus - merge request details shown in direct messages and message templates
+	author         *gitlab.BasicUser
+	labels         []string
+	changes        string
+	pipelineStatus string
+}
+
+// exclusion: suggested approver excluded from selection, provider set when excluded by an availability provider
+type exclusion struct {
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+	Provider string `json:"provider,omitempty"`
+}
+
+// NotifierConfig - Chat a group is notified through, defaults to slack using the groups slack channel. Webhook
+// notifiers take the url directly or from an environment variable so it can be kept out of the configuration file.
+type NotifierConfig struct {
+	Type          string `yaml:"type"`
+	WebhookURL    string `yaml:"webhook_url"`
+	WebhookURLEnv string `yaml:"webhook_url_env"`
+	// Channel - mattermost channel overriding the channel the webhook was created for
+	Channel string `yaml:"channel"`
+	// DirectMessage - slack notifier also sends each reviewer a direct message, SkipChannel sends only the direct
+	// messages
+	DirectMessage bool `yaml:"direct_message"`
+	SkipChannel   bool `yaml:"skip_channel"`
+}
+
+func (nc NotifierConfig) validate() error {
+	switch nc.Type {
+	case "", notifierSlack:
+		if nc.SkipChannel && !nc.DirectMessage {
+			return errors.New("slack notifier skip_channel requires direct_message.")
+		}
+	case notifierMattermost, notifierTeams:
+		if nc.WebhookURL == "" && nc.WebhookURLEnv == "" {
+			return fmt.Errorf("'%s' notifier requires webhook_url or webhook_url_env.", nc.Type)
+		}
+		if nc.DirectMessage || nc.SkipChannel {
+			return fmt.Errorf("'%s' notifier does not support direct messages.", nc.Type)
+		}
+	default:
+		return fmt.Errorf("'%s' unknown notifier type.", nc.Type)
+	}
+	return nil
+}
+
+// webhookURL: return the url set in the configuration, otherwise read from the environment variable
+func (nc NotifierConfig) webhookURL() (string, error) {
+	return fromEnv(nc.WebhookURL, nc.WebhookURLEnv, "url")
+}
+
+// fromEnv: return the value set in the configuration, otherwise read from the environment variable
+func fromEnv(value string, env string, name string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+	value = os.Getenv(env)
+	if value == "" {
+		return "", fmt.Errorf("'%s' webhook %s environment variable not set.", env, name)
+	}
+	return value, nil
+}
+
+// newWebhookNotifier: create the notifier for a webhook notifier type, slack notifiers are created when processing as
+// they require the slack client
+func newWebhookNotifier(nc NotifierConfig) (Notifier, error) {
+	if err := nc.validate(); err != nil {
+		return nil, err
+	}
+
+	url, err := nc.webhookURL()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: notifierTimeout}
+	switch nc.Type {
+	case notifierMattermost:
+		return &mattermostNotifier{url: url, channel: nc.Channel, client: client}, nil
+	case notifierTeams:
+		return &teamsNotifier{url: url, client: client}, nil
+	}
+	return nil, fmt.Errorf("'%s' is not a webhook notifier.", nc.Type)
+}
+
+// notifier: return the notifier for a group, false when the group has no slack channel set for the default slack
+// notifier. Webhook notifiers are created on load, otherwise when the config was not loaded from file.
+func (c Config) notifier(group string, slack SlackWrapper, cache UserCache) (Notifier, bool) {
+	groupChannel := c.GroupChannels[group]
+	switch groupChannel.Notifier.Type {
+	case "", notifierSlack:
+		if groupChannel.SlackChannelID == "" {
+			return nil, false
+		}
+		sn := newSlackNotifier(slack, cache, groupChannel.SlackChannel, c.messageTemplate(group), c.SlackInteractive, c.messages)
+		if groupChannel.Notifier.DirectMessage {
+			sn.withDirectMessages(groupChannel.Notifier.SkipChannel)
+		}
+		return sn, true
+	}
+
+	if notifier, ok := c.notifiers[group]; ok {
+		return notifier, true
+	}
+	notifier, err := newWebhookNotifier(groupChannel.Notifier)
+	if err != nil {
+		log.WithFields(log.Fields{"group": group, "error": err}).Error("invalid notifier.")
+		return nil, false
+	}
+	return notifier, true
+}
+
+// outboundWebhooks: return the outbound webhooks for a group, created on load otherwise when the config was not loaded
+// from file. Invalid webhooks are logged and skipped.
+func (c Config) outboundWebhooks(group string) []Notifier {
+	if webhooks, ok := c.webhooks[group]; ok {
+		return webhooks
+	}
+
+	var webhooks []Notifier
+	for _, webhook := range c.GroupChannels[group].Webhooks {
+		notifier, err := newOutboundWebhook(webhook)
+		if err != nil {
+			log.WithFields(log.Fields{"group": group, "error": err}).Error("invalid webhook.")
+			continue
+		}
+		webhooks = append(webhooks, notifier)
+	}
+	return webhooks
+}
+
+// slackNotifier: posts the message rendered from the group templates to the groups slack channel through the chat api,
+// interactive messages include buttons for acting on the review. Reviewers are mentioned and sent direct messages by
+// the slack user id held in the cache. Messages are recorded when a message store is set. A notifier is created for
+// each assignment so reviewers already sent a direct message are not messaged again when the notification is retried.
+type slackNotifier struct {
+	slack         SlackWrapper
+	cache         UserCache
+	channel       string
+	templates     *messageTemplate
+	interactive   bool
+	messages      messageStore
+	directMessage bool
+	skipChannel   bool
+	messaged      map[string]bool
+}
+
+func newSlackNotifier(slack SlackWrapper, cache UserCache, channel string, templates *messageTemplate, interactive bool, messages messageStore) *slackNotifier {
+	return &slackNotifier{slack: slack, cache: cache, channel: channel, templates: templates, interactive: interactive, messages: messages, messaged: make(map[string]bool)}
+}
+
+// withDirectMessages: send each reviewer a direct message, skipping the channel post when set
+func (sn *slackNotifier) withDirectMessages(skipChannel bool) {
+	sn.directMessage = true
+	sn.skipChannel = skipChannel
+}
+
+func (sn *slackNotifier) Name() string {
+	return notifierSlack
+}
+
+// Notify: post the message, recording where it was sent so it can be updated with the review status, then message each
+// reviewer directly. Failed direct messages only fail the notification when the channel post is skipped and no reviewer
+// was messaged.
+func (sn *slackNotifier) Notify(a assignment) error {
+	if sn.directMessage {
+		sent := sn.notifyReviewers(a)
+		if sn.skipChannel {
+			if sent == 0 {
+				return errors.New("failed to send any slack direct message!")
+			}
+			return nil
+		}
+	}
+
+	text, footer, err := sn.templates.render(newTemplateData(a, slackMentionFunc(sn.cache)))
+	if err != nil {
+		return err
+	}
+
+	channelID, timestamp, err := sendSlackMsg(sn.slack, sn.channel, text, footer, a.mr, sn.interactive)
+	if err != nil || sn.messages == nil {
+		return err
+	}
+
+	m := sentMessage{ChannelID: channelID, Timestamp: timestamp, Text: text, Footer: footer, FooterSet: true, Interactive: sn.interactive, SentAt: time.Now()}
+	if err := sn.messages.save(mergeRequestKey(a.mr), m); err != nil {
+		promErrors.WithLabelValues("save_slack_message").Inc()
+		log.WithFields(log.Fields{"group": a.mr.Group(), "project_id": a.mr.ProjectID(), "merge_request_id": a.mr.MergeReqID(), "error": err}).Error("failed to save slack message, review status will not be shown.")
+	}
+	return nil
+}
+
+// notifyReviewers: direct message each reviewer mapped to a slack user in the cache and not messaged by an earlier
+// attempt, returning the number sent including earlier attempts
+func (sn *slackNotifier) notifyReviewers(a assignment) int {
+	logger := log.WithFields(log.Fields{"group": a.mr.Group(), "project_id": a.mr.ProjectID(), "merge_request_id": a.mr.MergeReqID()})
+
+	sent := 0
+	for _, reviewer := range a.reviewers {
+		if sn.messaged[reviewer.Username] {
+			sent++
+			continue
+		}
+		slackUserID, ok := cachedSlackUserID(sn.cache, reviewer.Username)
+		if !ok {
+			promSlackMsgsErrors.WithLabelValues("no_slack_user", a.mr.Group(), "").Inc()
+			logger.WithFields(log.Fields{"username": reviewer.Username}).Warn("reviewer without slack user id, not sent direct message.")
+			continue
+		}
+		if err := sendSlackDirectMsg(sn.slack, slackUserID, a); err != nil {
+			logger.WithFields(log.Fields{"username": reviewer.Username, "error": err}).Error("failed to send slack direct message.")
+			continue
+		}
+		sn.messaged[reviewer.Username] = true
+		sent++
+	}
+	return sent
+}
+
+// reviewMessage: the message sent to webhook notifiers, the mention of each reviewer formatted for the chat and the
+// merge request linked with markdown
+func reviewMessage(a assignment, mention func(*gitlab.BasicUser) string) string {
+	var mentions []string
+	for _, reviewer := range a.reviewers {
+		mentions = append(mentions, mention(reviewer))
+	}
+	return fmt.Sprintf("%s you have been selected to review [%s](%s) in [%s](%s)", strings.Join(mentions, ", "), escapeMarkdown(a.mr.MergeReqTitle()), a.mr.MergeReqURL(), escapeMarkdown(a.mr.ProjectName()), a.mr.ProjectWebURL())
+}
+
+// markdownEscaper: backslash escapes markdown syntax and breaks mentions with a zero width space, so text set by merge
+// request authors can not break the link or ping a channel through @all, @channel or @here
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"[", `\[`,
+	"]", `\]`,
+	"(", `\(`,
+	")", `\)`,
+	"*", `\*`,
+	"_", `\_`,
+	"~", `\~`,
+	"`", "\\`",
+	"#", `\#`,
+	"<", `\<`,
+	">", `\>`,
+	"|", `\|`,
+	"@", "@\u200b",
+)
+
+// escapeMarkdown: text shown literally in a markdown message
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// escapeMentions: break mentions in plain text such as notification summaries
+func escapeMentions(text string) string {
+	return strings.ReplaceAll(text, "@", "@\u200b")
+}
+
+// postWebhook: post the payload as json to an incoming webhook
+func postWebhook(client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return webhookStatusError{status: resp.Status, code: resp.StatusCode}
+	}
+	return nil
+}
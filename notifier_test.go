@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+// Setup
+
+// webhookRecorder: incoming webhook responding with the status set, recording the last body received
+type webhookRecorder struct {
+	status int
+	body   map[string]interface{}
+}
+
+func (wr *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wr.body = nil
+	_ = json.NewDecoder(r.Body).Decode(&wr.body)
+	w.WriteHeader(wr.status)
+}
+
+// postRecorder: slack client recording the channel of each message posted, failing posts to the fail channel
+type postRecorder struct {
+	*MockSlack
+	channels []string
+	fail     string
+}
+
+func (pr *postRecorder) PostMessage(channelID string, options ...slack.MsgOption) (string, string, error) {
+	if channelID == pr.fail {
+		return "", "", errors.New("rate limited")
+	}
+	pr.channels = append(pr.channels, channelID)
+	return channelID, "1660000000.000100", nil
+}
+
+var notifierAssignment = assignment{
+	mr: MergeRequest{
+		pathWithNamespace: "test/project",
+		group:             "test",
+		projectID:         1,
+		projectName:       "project",
+		projectWebURL:     "https://gitlab.local/test/project",
+		mergeReqID:        1,
+		mergeReqTitle:     "Add feature",
+		mergeReqURL:       "https://gitlab.local/test/project/-/merge_requests/1",
+	},
+	reviewers: []*gitlab.BasicUser{
+		{ID: 1, Username: "test1", Name: "Test One"},
+		{ID: 2, Username: "test2"},
+	},
+	approvalsRequired: 2,
+	exclusions: []exclusion{
+		{Username: "test3", Reason: "author"},
+		{Username: "test4", Reason: "out sick", Provider: providerSlack},
+	},
+}
+
+// Tests
+
+func TestNotifierConfigValidate(t *testing.T) {
+	type test struct {
+		notifier NotifierConfig
+		wantErr  bool
+	}
+
+	tests := []test{
+		{notifier: NotifierConfig{}},
+		{notifier: NotifierConfig{Type: notifierSlack}},
+		{notifier: NotifierConfig{Type: notifierMattermost, WebhookURL: "http://mattermost.local/hooks/1"}},
+		{notifier: NotifierConfig{Type: notifierTeams, WebhookURLEnv: "TEAMS_WEBHOOK_URL"}},
+		{notifier: NotifierConfig{Type: notifierSlack, DirectMessage: true, SkipChannel: true}},
+		{notifier: NotifierConfig{Type: notifierMattermost}, wantErr: true},
+		{notifier: NotifierConfig{Type: notifierSlack, SkipChannel: true}, wantErr: true},
+		{notifier: NotifierConfig{Type: notifierMattermost, WebhookURL: "http://mattermost.local/hooks/1", DirectMessage: true}, wantErr: true},
+		{notifier: NotifierConfig{Type: "irc", WebhookURL: "http://irc.local"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		err := tc.notifier.validate()
+		if tc.wantErr {
+			assert.Error(t, err, tc.notifier.Type)
+		} else {
+			assert.NoError(t, err, tc.notifier.Type)
+		}
+	}
+}
+
+func TestNewWebhookNotifier(t *testing.T) {
+	os.Setenv("TEST_TEAMS_WEBHOOK_URL", "http://teams.local/webhook")
+	defer os.Unsetenv("TEST_TEAMS_WEBHOOK_URL")
+
+	type test struct {
+		notifier NotifierConfig
+		wantURL  string
+		wantErr  bool
+	}
+
+	tests := []test{
+		{notifier: NotifierConfig{Type: notifierMattermost, WebhookURL: "http://mattermost.local/hooks/1"}, wantURL: "http://mattermost.local/hooks/1"},
+		{notifier: NotifierConfig{Type: notifierTeams, WebhookURLEnv: "TEST_TEAMS_WEBHOOK_URL"}, wantURL: "http://teams.local/webhook"},
+		{notifier: NotifierConfig{Type: notifierTeams, WebhookURLEnv: "TEST_UNSET_WEBHOOK_URL"}, wantErr: true},
+		{notifier: NotifierConfig{Type: notifierSlack}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		notifier, err := newWebhookNotifier(tc.notifier)
+		if tc.wantErr {
+			assert.Error(t, err, tc.notifier.Type)
+			continue
+		}
+		assert.NoError(t, err, tc.notifier.Type)
+		assert.Equal(t, tc.notifier.Type, notifier.Name())
+		switch n := notifier.(type) {
+		case *mattermostNotifier:
+			assert.Equal(t, tc.wantURL, n.url)
+		case *teamsNotifier:
+			assert.Equal(t, tc.wantURL, n.url)
+		}
+	}
+}
+
+func TestConfigNotifier(t *testing.T) {
+	config := Config{
+		GroupChannels: map[string]GroupChannel{
+			"slack":      {SlackChannel: "general", SlackChannelID: "C1"},
+			"no_channel": {},
+			"mattermost": {Notifier: NotifierConfig{Type: notifierMattermost, WebhookURL: "http://mattermost.local/hooks/1"}},
+			"invalid":    {Notifier: NotifierConfig{Type: notifierTeams, WebhookURLEnv: "TEST_UNSET_WEBHOOK_URL"}},
+		},
+	}
+
+	type test struct {
+		group    string
+		wantName string
+		wantOk   bool
+	}
+
+	tests := []test{
+		{group: "slack", wantName: notifierSlack, wantOk: true},
+		{group: "no_channel"},
+		{group: "mattermost", wantName: notifierMattermost, wantOk: true},
+		{group: "invalid"},
+	}
+
+	for _, tc := range tests {
+		notifier, ok := config.notifier(tc.group, &MockSlack{}, newLocalCache())
+		assert.Equal(t, tc.wantOk, ok, tc.group)
+		if ok {
+			assert.Equal(t, tc.wantName, notifier.Name(), tc.group)
+		}
+	}
+}
+
+func TestSlackNotifierDirectMessages(t *testing.T) {
+	type test struct {
+		name         string
+		slackUserID  string
+		skipChannel  bool
+		wantChannels []string
+		wantErr      bool
+	}
+
+	tests := []test{
+		{name: "direct and channel", slackUserID: "U1", wantChannels: []string{"DU1", "general"}},
+		{name: "direct only", slackUserID: "U1", skipChannel: true, wantChannels: []string{"DU1"}},
+		// reviewer without a slack user is still notified in the channel
+		{name: "direct failed", slackUserID: "E", wantChannels: []string{"general"}},
+		{name: "direct only failed", slackUserID: "E", skipChannel: true, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		cache := newLocalCache()
+		cache.update(userMeta{username: "test1", slackUserID: tc.slackUserID}, time.Now().Add(time.Hour).Unix())
+		recorder := &postRecorder{MockSlack: &MockSlack{}}
+
+		notifier := newSlackNotifier(recorder, cache, "general", defaultTemplates, false, nil)
+		notifier.withDirectMessages(tc.skipChannel)
+
+		err := notifier.Notify(notifierAssignment)
+		if tc.wantErr {
+			assert.Error(t, err, tc.name)
+		} else {
+			assert.NoError(t, err, tc.name)
+		}
+		assert.Equal(t, tc.wantChannels, recorder.channels, tc.name)
+	}
+}
+
+func TestSlackNotifierRetryDirectMessages(t *testing.T) {
+	cache := newLocalCache()
+	cache.update(userMeta{username: "test1", slackUserID: "U1"}, time.Now().Add(time.Hour).Unix())
+	recorder := &postRecorder{MockSlack: &MockSlack{}, fail: "general"}
+
+	notifier := newSlackNotifier(recorder, cache, "general", defaultTemplates, false, nil)
+	notifier.withDirectMessages(false)
+
+	// channel post failing after the direct message was sent
+	assert.Error(t, notifier.Notify(notifierAssignment))
+	assert.Equal(t, []string{"DU1"}, recorder.channels)
+
+	// retry only posts to the channel
+	recorder.fail = ""
+	assert.NoError(t, notifier.Notify(notifierAssignment))
+	assert.Equal(t, []string{"DU1", "general"}, recorder.channels)
+}
+
+func TestMattermostNotify(t *testing.T) {
+	recorder := &webhookRecorder{status: http.StatusOK}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	notifier, err := newWebhookNotifier(NotifierConfig{Type: notifierMattermost, WebhookURL: server.URL, Channel: "reviews"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, notifier.Notify(notifierAssignment))
+	assert.Equal(t, "reviews", recorder.body["channel"])
+	assert.Equal(t, "@test1, @test2 you have been selected to review [Add feature](https://gitlab.local/test/project/-/merge_requests/1) in [project](https://gitlab.local/test/project)", recorder.body["text"])
+
+	recorder.status = http.StatusBadRequest
+	assert.Error(t, notifier.Notify(notifierAssignment))
+}
+
+func TestTeamsNotify(t *testing.T) {
+	recorder := &webhookRecorder{status: http.StatusOK}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	notifier, err := newWebhookNotifier(NotifierConfig{Type: notifierTeams, WebhookURL: server.URL})
+	assert.NoError(t, err)
+
+	assert.NoError(t, notifier.Notify(notifierAssignment))
+	assert.Equal(t, "MessageCard", recorder.body["@type"])
+	assert.Equal(t, "Review requested: Add feature", recorder.body["summary"])
+	assert.Equal(t, "**Test One**, **test2** you have been selected to review [Add feature](https://gitlab.local/test/project/-/merge_requests/1) in [project](https://gitlab.local/test/project)", recorder.body["text"])
+
+	recorder.status = http.StatusInternalServerError
+	assert.Error(t, notifier.Notify(notifierAssignment))
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "plain", text: "Add feature", want: "Add feature"},
+		{name: "link", text: "Fix [bug](https://example.com)", want: `Fix \[bug\]\(https://example.com\)`},
+		{name: "emphasis", text: "*bold* _italic_ `code`", want: "\\*bold\\* \\_italic\\_ \\`code\\`"},
+		{name: "mentions", text: "@all @channel", want: "@\u200ball @\u200bchannel"},
+		{name: "backslash", text: `a\]`, want: `a\\\]`},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, escapeMarkdown(tc.text), tc.name)
+	}
+
+	mr := notifierAssignment.mr.(MergeRequest)
+	mr.mergeReqTitle = "Fix [bug]) @here"
+	assert.Equal(t, "@test1 you have been selected to review [Fix \\[bug\\]\\) @\u200bhere](https://gitlab.local/test/project/-/merge_requests/1) in [project](https://gitlab.local/test/project)",
+		reviewMessage(assignment{mr: mr, reviewers: notifierAssignment.reviewers[:1]}, func(u *gitlab.BasicUser) string { return "@" + u.Username }))
+	assert.Equal(t, "@\u200bhere", escapeMentions("@here"))
+}
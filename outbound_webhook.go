@@ -0,0 +1,208 @@
+// Outbound webhooks sending reviewer assignments as json to other tooling, set per group in addition to the chat. The
+// body and time sent are signed with a shared secret so receivers can verify the sender and reject replayed requests,
+// deliveries are sent in the background by the notification dispatcher which retries failures with backoff.
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	notifierWebhook = "webhook"
+
+	webhookEventAssigned = "reviewers_assigned"
+
+	webhookSignatureHeader = "X-MR-Webhook-Signature"
+	webhookTimestampHeader = "X-MR-Webhook-Timestamp"
+	webhookEventHeader     = "X-MR-Webhook-Event"
+
+	defaultWebhookMaxAttempts    = 3
+	defaultWebhookInitialBackoff = time.Second
+	defaultWebhookMaxBackoff     = 10 * time.Second
+)
+
+// OutboundWebhook - Url sent a json document for each reviewer assignment, signed with the secret. The url and secret
+// can be read from environment variables so they can be kept out of the configuration file.
+type OutboundWebhook struct {
+	URL       string `yaml:"url"`
+	URLEnv    string `yaml:"url_env"`
+	Secret    string `yaml:"secret"`
+	SecretEnv string `yaml:"secret_env"`
+	// Retry - unset values default to 3 attempts with a backoff from 1s up to 10s
+	Retry Retry `yaml:"retry"`
+}
+
+func (ow OutboundWebhook) validate() error {
+	if ow.URL == "" && ow.URLEnv == "" {
+		return errors.New("webhook requires url or url_env.")
+	}
+	if ow.Secret == "" && ow.SecretEnv == "" {
+		return errors.New("webhook requires secret or secret_env.")
+	}
+	if ow.Retry.MaxAttempts < 0 {
+		return fmt.Errorf("'%d' webhook max_attempts must not be negative.", ow.Retry.MaxAttempts)
+	}
+	return nil
+}
+
+// withDefaults: fill in any retry settings not set in the configuration file, defaults are shorter than retrying a merge
+// request as each delivery holds a notification open until shutdown
+func (ow OutboundWebhook) withDefaults() OutboundWebhook {
+	if ow.Retry.MaxAttempts <= 0 {
+		ow.Retry.MaxAttempts = defaultWebhookMaxAttempts
+	}
+	if ow.Retry.InitialBackoff <= 0 {
+		ow.Retry.InitialBackoff = defaultWebhookInitialBackoff
+	}
+	if ow.Retry.MaxBackoff <= 0 {
+		ow.Retry.MaxBackoff = defaultWebhookMaxBackoff
+	}
+	return ow
+}
+
+// outboundWebhook: notifier posting the assignment document to the url
+type outboundWebhook struct {
+	url    string
+	secret []byte
+	retry  Retry
+	client *http.Client
+}
+
+func newOutboundWebhook(ow OutboundWebhook) (*outboundWebhook, error) {
+	if err := ow.validate(); err != nil {
+		return nil, err
+	}
+	ow = ow.withDefaults()
+
+	url, err := fromEnv(ow.URL, ow.URLEnv, "url")
+	if err != nil {
+		return nil, err
+	}
+	secret, err := fromEnv(ow.Secret, ow.SecretEnv, "secret")
+	if err != nil {
+		return nil, err
+	}
+
+	return &outboundWebhook{url: url, secret: []byte(secret), retry: ow.Retry, client: &http.Client{Timeout: notifierTimeout}}, nil
+}
+
+// webhookAssignment: json document sent to outbound webhooks
+type webhookAssignment struct {
+	Event             string            `json:"event"`
+	SentAt            time.Time         `json:"sent_at"`
+	Group             string            `json:"group"`
+	Project           webhookProject    `json:"project"`
+	MergeRequest      webhookMR         `json:"merge_request"`
+	Reviewers         []webhookReviewer `json:"reviewers"`
+	ApprovalsRequired int               `json:"approvals_required"`
+	Exclusions        []exclusion       `json:"exclusions"`
+}
+
+type webhookProject struct {
+	ID                int    `json:"id"`
+	Name              string `json:"name"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	URL               string `json:"url"`
+}
+
+type webhookMR struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+type webhookReviewer struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+func newWebhookAssignment(a assignment, now time.Time) webhookAssignment {
+	reviewers := []webhookReviewer{}
+	for _, reviewer := range a.reviewers {
+		reviewers = append(reviewers, webhookReviewer{ID: reviewer.ID, Username: reviewer.Username, Name: reviewer.Name})
+	}
+	exclusions := a.exclusions
+	if exclusions == nil {
+		exclusions = []exclusion{}
+	}
+
+	return webhookAssignment{
+		Event:  webhookEventAssigned,
+		SentAt: now.UTC(),
+		Group:  a.mr.Group(),
+		Project: webhookProject{
+			ID:                a.mr.ProjectID(),
+			Name:              a.mr.ProjectName(),
+			PathWithNamespace: a.mr.PathWithNamespace(),
+			URL:               a.mr.ProjectWebURL(),
+		},
+		MergeRequest:      webhookMR{ID: a.mr.MergeReqID(), Title: a.mr.MergeReqTitle(), URL: a.mr.MergeReqURL()},
+		Reviewers:         reviewers,
+		ApprovalsRequired: a.approvalsRequired,
+		Exclusions:        exclusions,
+	}
+}
+
+func (ow *outboundWebhook) Name() string {
+	return notifierWebhook
+}
+
+// retryPolicy: deliveries are retried by the notification dispatcher using the webhook retry settings
+func (ow *outboundWebhook) retryPolicy() Retry {
+	return ow.retry
+}
+
+// Notify: post the assignment once, connection errors, rate limiting and 5xx responses are retried by the dispatcher
+func (ow *outboundWebhook) Notify(a assignment) error {
+	now := time.Now()
+	body, err := json.Marshal(newWebhookAssignment(a, now))
+	if err != nil {
+		return err
+	}
+	if err := ow.post(body, now); err != nil {
+		return fmt.Errorf("webhook: failed to send assignment: %w", err)
+	}
+	return nil
+}
+
+// post: send the body signed with the time sent
+func (ow *outboundWebhook) post(body []byte, now time.Time) error {
+	req, err := http.NewRequest(http.MethodPost, ow.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, webhookEventAssigned)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(ow.secret, timestamp, body))
+
+	resp, err := ow.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return webhookStatusError{status: resp.Status, code: resp.StatusCode}
+	}
+	return nil
+}
+
+// signWebhook: hex encoded hmac sha256 of the timestamp and body joined by ".", sent as "sha256=<hex>"
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
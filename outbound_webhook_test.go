@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Setup
+
+// webhookReceiver: responds with each status in turn then 200, recording the requests received
+type webhookReceiver struct {
+	statuses   []int
+	bodies     [][]byte
+	signatures []string
+	timestamps []string
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	wr.bodies = append(wr.bodies, body)
+	wr.signatures = append(wr.signatures, r.Header.Get(webhookSignatureHeader))
+	wr.timestamps = append(wr.timestamps, r.Header.Get(webhookTimestampHeader))
+
+	status := http.StatusOK
+	if len(wr.statuses) > 0 {
+		status, wr.statuses = wr.statuses[0], wr.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+var webhookRetry = Retry{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+// Tests
+
+func TestOutboundWebhookValidate(t *testing.T) {
+	type test struct {
+		webhook OutboundWebhook
+		wantErr bool
+	}
+
+	tests := []test{
+		{webhook: OutboundWebhook{URL: "http://tooling.local/hook", Secret: "secret"}},
+		{webhook: OutboundWebhook{URLEnv: "WEBHOOK_URL", SecretEnv: "WEBHOOK_SECRET"}},
+		{webhook: OutboundWebhook{Secret: "secret"}, wantErr: true},
+		{webhook: OutboundWebhook{URL: "http://tooling.local/hook"}, wantErr: true},
+		{webhook: OutboundWebhook{URL: "http://tooling.local/hook", Secret: "secret", Retry: Retry{MaxAttempts: -1}}, wantErr: true},
+	}
+
+	for i, tc := range tests {
+		err := tc.webhook.validate()
+		if tc.wantErr {
+			assert.Error(t, err, i)
+		} else {
+			assert.NoError(t, err, i)
+		}
+	}
+}
+
+func TestNewOutboundWebhook(t *testing.T) {
+	os.Setenv("TEST_WEBHOOK_SECRET", "env-secret")
+	defer os.Unsetenv("TEST_WEBHOOK_SECRET")
+
+	webhook, err := newOutboundWebhook(OutboundWebhook{URL: "http://tooling.local/hook", SecretEnv: "TEST_WEBHOOK_SECRET"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("env-secret"), webhook.secret)
+	assert.Equal(t, defaultWebhookMaxAttempts, webhook.retry.MaxAttempts)
+
+	_, err = newOutboundWebhook(OutboundWebhook{URL: "http://tooling.local/hook", SecretEnv: "TEST_UNSET_WEBHOOK_SECRET"})
+	assert.Error(t, err)
+}
+
+func TestOutboundWebhookNotify(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	webhook, err := newOutboundWebhook(OutboundWebhook{URL: server.URL, Secret: "secret", Retry: webhookRetry})
+	assert.NoError(t, err)
+	assert.NoError(t, webhook.Notify(notifierAssignment))
+
+	assert.Len(t, receiver.bodies, 1)
+	assert.Equal(t, signWebhook([]byte("secret"), receiver.timestamps[0], receiver.bodies[0]), receiver.signatures[0])
+	timestamp, err := strconv.ParseInt(receiver.timestamps[0], 10, 64)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), time.Unix(timestamp, 0), time.Minute)
+	assert.NotEqual(t, signWebhook([]byte("secret"), "0", receiver.bodies[0]), receiver.signatures[0])
+
+	var got webhookAssignment
+	assert.NoError(t, json.Unmarshal(receiver.bodies[0], &got))
+	assert.Equal(t, webhookEventAssigned, got.Event)
+	assert.Equal(t, "test", got.Group)
+	assert.Equal(t, webhookProject{ID: 1, Name: "project", PathWithNamespace: "test/project", URL: "https://gitlab.local/test/project"}, got.Project)
+	assert.Equal(t, webhookMR{ID: 1, Title: "Add feature", URL: "https://gitlab.local/test/project/-/merge_requests/1"}, got.MergeRequest)
+	assert.Equal(t, []webhookReviewer{{ID: 1, Username: "test1", Name: "Test One"}, {ID: 2, Username: "test2"}}, got.Reviewers)
+	assert.Equal(t, 2, got.ApprovalsRequired)
+	assert.Equal(t, notifierAssignment.exclusions, got.Exclusions)
+}
+
+func TestOutboundWebhookRetry(t *testing.T) {
+	type test struct {
+		statuses     []int
+		wantClass    errorClass
+		wantAttempts int
+	}
+
+	tests := []test{
+		{statuses: []int{http.StatusBadGateway, http.StatusTooManyRequests}, wantClass: errorTransient, wantAttempts: 3},
+		{statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}, wantClass: errorTransient, wantAttempts: 3},
+		// client errors will fail again
+		{statuses: []int{http.StatusUnauthorized}, wantClass: errorPermanent, wantAttempts: 1},
+	}
+
+	for _, tc := range tests {
+		receiver := &webhookReceiver{statuses: tc.statuses[:1]}
+		server := httptest.NewServer(receiver)
+
+		webhook, err := newOutboundWebhook(OutboundWebhook{URL: server.URL, Secret: "secret", Retry: webhookRetry})
+		assert.NoError(t, err)
+		assert.Equal(t, webhookRetry, webhook.retryPolicy())
+
+		// single attempt per notify, retries are left to the dispatcher
+		err = webhook.Notify(notifierAssignment)
+		assert.Equal(t, tc.wantClass, classifyError(err), tc.statuses)
+		assert.Len(t, receiver.bodies, 1, tc.statuses)
+
+		receiver.statuses, receiver.bodies = tc.statuses, nil
+		newNotificationDispatcher().deliver(webhook, notifierAssignment)
+		assert.Len(t, receiver.bodies, tc.wantAttempts, tc.statuses)
+		server.Close()
+	}
+
+	// connection errors are retried
+	server := httptest.NewServer(&webhookReceiver{})
+	server.Close()
+	webhook, err := newOutboundWebhook(OutboundWebhook{URL: server.URL, Secret: "secret", Retry: webhookRetry})
+	assert.NoError(t, err)
+	assert.Equal(t, errorTransient, classifyError(webhook.Notify(notifierAssignment)))
+}
@@ -0,0 +1,97 @@
+// Slack presence of approvers. When enabled for a group, approvers who are away or have do not disturb on are only
+// selected when there are not enough present approvers to meet the approvals required. Presence is cached for a minute
+// so a burst of merge requests does not request the presence of every approver each time.
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"github.com/xanzy/go-gitlab"
+)
+
+const (
+	presenceAway = "away"
+	presenceDND  = "dnd"
+
+	presenceTTL = time.Minute
+)
+
+// getSlackPresence: return why a slack user is not present, empty when the user is active without do not disturb
+func getSlackPresence(sw SlackWrapper, slackUserID string, now time.Time) (string, error) {
+	presence, err := getUserPresence(sw, slackUserID)
+	if err != nil {
+		return "", err
+	}
+	if presence.Presence == presenceAway {
+		return presenceAway, nil
+	}
+
+	dnd, err := getDNDInfo(sw, slackUserID)
+	if err != nil {
+		return "", err
+	}
+	if dndActive(dnd, now) {
+		return presenceDND, nil
+	}
+	return "", nil
+}
+
+// dndActive: do not disturb is on when snoozed or within the scheduled do not disturb hours
+func dndActive(dnd *slack.DNDStatus, now time.Time) bool {
+	if dnd.SnoozeEnabled && int64(dnd.SnoozeEndTime) > now.Unix() {
+		return true
+	}
+	return dnd.Enabled && int64(dnd.NextStartTimestamp) <= now.Unix() && now.Unix() < int64(dnd.NextEndTimestamp)
+}
+
+// cachedSlackPresence: return the presence held in the presences cache, otherwise request it from slack and cache it.
+// Presence is not cached when presences is nil.
+func cachedSlackPresence(sw SlackWrapper, presences UserCache, slackUserID string, now time.Time) (string, error) {
+	if presences != nil {
+		if cached, err := presences.read(slackUserID); err == nil {
+			return cached.status, nil
+		}
+	}
+
+	reason, err := getSlackPresence(sw, slackUserID, now)
+	if err != nil {
+		return "", err
+	}
+	if presences != nil {
+		presences.update(userMeta{username: slackUserID, slackUserID: slackUserID, status: reason}, now.Add(presenceTTL).Unix())
+	}
+	return reason, nil
+}
+
+// splitByPresence: split approvers into those present on slack and those away or in do not disturb. Approvers
+// without a slack user id or whose presence can not be retrieved are treated as present.
+func splitByPresence(sw SlackWrapper, cache UserCache, presences UserCache, approvers []*gitlab.BasicUser, mr MergeRequests) ([]*gitlab.BasicUser, []*gitlab.BasicUser) {
+	logger := log.WithFields(log.Fields{"group": mr.Group(), "project_id": mr.ProjectID(), "merge_request_id": mr.MergeReqID()})
+
+	var present, away []*gitlab.BasicUser
+	now := time.Now()
+	for _, approver := range approvers {
+		cachedUser, _ := cache.read(approver.Username)
+		if cachedUser.slackUserID == "" {
+			present = append(present, approver)
+			continue
+		}
+
+		reason, err := cachedSlackPresence(sw, presences, cachedUser.slackUserID, now)
+		if err != nil {
+			logger.WithFields(log.Fields{"error": err, "username": approver.Username}).Warn("failed to get slack presence, treating as present.")
+			present = append(present, approver)
+			continue
+		}
+		if reason != "" {
+			promAwayApprovers.WithLabelValues(reason, mr.Group()).Inc()
+			logger.WithFields(log.Fields{"reason": reason, "username": approver.Username}).Debug("user not present on slack.")
+			away = append(away, approver)
+			continue
+		}
+		present = append(present, approver)
+	}
+	return present, away
+}
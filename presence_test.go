@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests
+
+func TestDNDActive(t *testing.T) {
+	now := time.Now()
+	hour := int(time.Hour.Seconds())
+	nowTS := int(now.Unix())
+
+	type test struct {
+		dnd  slack.DNDStatus
+		want bool
+	}
+
+	tests := []test{
+		{dnd: slack.DNDStatus{}, want: false},
+		{dnd: slack.DNDStatus{Enabled: true, NextStartTimestamp: nowTS - hour, NextEndTimestamp: nowTS + hour}, want: true},
+		{dnd: slack.DNDStatus{Enabled: true, NextStartTimestamp: nowTS + hour, NextEndTimestamp: nowTS + 2*hour}, want: false},
+		{dnd: slack.DNDStatus{Enabled: false, NextStartTimestamp: nowTS - hour, NextEndTimestamp: nowTS + hour}, want: false},
+		{dnd: slack.DNDStatus{SnoozeInfo: slack.SnoozeInfo{SnoozeEnabled: true, SnoozeEndTime: nowTS + hour}}, want: true},
+		{dnd: slack.DNDStatus{SnoozeInfo: slack.SnoozeInfo{SnoozeEnabled: true, SnoozeEndTime: nowTS - hour}}, want: false},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, dndActive(&tc.dnd, now))
+	}
+}
+
+func TestGetSlackPresence(t *testing.T) {
+	type test struct {
+		slackUserID string
+		want        string
+		wantErr     bool
+	}
+
+	tests := []test{
+		{slackUserID: "1", want: ""},
+		{slackUserID: "2", want: presenceAway},
+		{slackUserID: "3", want: presenceDND},
+		{slackUserID: "4", want: presenceDND},
+		{slackUserID: "E", want: "", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := getSlackPresence(&MockSlack{}, tc.slackUserID, time.Now())
+		assert.Equal(t, tc.want, got, tc.slackUserID)
+		assert.Equal(t, tc.wantErr, err != nil, tc.slackUserID)
+	}
+}
+
+func TestCachedSlackPresence(t *testing.T) {
+	now := time.Now()
+	presences := newLocalCache()
+
+	// requested from slack and cached
+	got, err := cachedSlackPresence(&MockSlack{}, presences, "2", now)
+	assert.NoError(t, err)
+	assert.Equal(t, presenceAway, got)
+	cached, err := presences.read("2")
+	assert.NoError(t, err)
+	assert.Equal(t, presenceAway, cached.status)
+
+	// cached presence used until it expires
+	presences.update(userMeta{username: "1", slackUserID: "1", status: presenceDND}, now.Add(presenceTTL).Unix())
+	got, _ = cachedSlackPresence(&MockSlack{}, presences, "1", now)
+	assert.Equal(t, presenceDND, got)
+	presences.update(userMeta{username: "1", slackUserID: "1", status: presenceDND}, now.Add(-time.Second).Unix())
+	got, _ = cachedSlackPresence(&MockSlack{}, presences, "1", now)
+	assert.Equal(t, "", got)
+
+	// failures are not cached
+	_, err = cachedSlackPresence(&MockSlack{}, presences, "E", now)
+	assert.Error(t, err)
+	_, err = presences.read("E")
+	assert.Equal(t, errUserNotInCache, err)
+
+	// not cached without a presences cache
+	got, err = cachedSlackPresence(&MockSlack{}, nil, "3", now)
+	assert.NoError(t, err)
+	assert.Equal(t, presenceDND, got)
+}
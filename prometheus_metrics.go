@@ -91,6 +91,65 @@ var (
 		},
 	)
 
+	promSlackDirectMsgs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_slack_direct_msgs",
+		Help: "Slack direct messages sent to selected reviewers.",
+	},
+		[]string{
+			"group",
+		},
+	)
+
+	promNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_notifications",
+		Help: "Notifications of selected reviewers sent, by notifier.",
+	},
+		[]string{
+			"notifier",
+			"group",
+		},
+	)
+
+	promNotificationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_notification_errors",
+		Help: "Notifications of selected reviewers which failed to send, by notifier.",
+	},
+		[]string{
+			"notifier",
+			"group",
+		},
+	)
+
+	promSlackMsgUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_slack_msg_updates",
+		Help: "Slack messages updated with the review status, by status.",
+	},
+		[]string{
+			"status",
+			"group",
+		},
+	)
+
+	promSlackInteractions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_slack_interactions",
+		Help: "Slack message buttons clicked, by action and result.",
+	},
+		[]string{
+			"action",
+			"result",
+		},
+	)
+
+	promNotificationRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_notification_retries",
+		Help: "Notifications of selected reviewers retried after a failed attempt, by notifier.",
+	},
+		[]string{
+			"notifier",
+			"group",
+		},
+	)
+
 	promSlackMsgsErrors = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "gitlab_mr_wh_slack_msgs_errors",
 		Help: "Errors encountered when attempting to send slack messages",
@@ -138,11 +197,25 @@ var (
 		Help: "Cache entry cleared.",
 	})
 
+	promCacheRefresh = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_cache_refresh",
+		Help: "Cache entries refreshed, evicted or failed to refresh by the background refresh.",
+	},
+		[]string{
+			"result",
+		},
+	)
+
 	promCacheAdmin = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "gitlab_mr_wh_cache_admin",
 		Help: "Cache Admin page accessed.",
 	})
 
+	promQueueAdmin = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_queue_admin",
+		Help: "Queue Admin page accessed.",
+	})
+
 	promSlackUsersMissing = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "gitlab_mr_wh_no_matching_slack_user",
 		Help: "A gitlab user in the codeowners does not have a matching entry in slack.",
@@ -162,11 +235,120 @@ var (
 		},
 	)
 
+	promExcludedApprovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_excluded_approvers",
+		Help: "Suggested approvers excluded from selection as the author or a commit author of the merge request.",
+	},
+		[]string{
+			"reason",
+			"group",
+		},
+	)
+
+	promUserMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_user_matches",
+		Help: "Gitlab users matched to a slack user, by override, email or username.",
+	},
+		[]string{
+			"match",
+			"group",
+		},
+	)
+
+	promUsersUnmatched = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gitlab_mr_wh_users_unmatched",
+		Help: "Number of gitlab users suggested as approvers without a matching slack user.",
+	})
+
 	promWorkers = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "gitlab_mr_wh_workers",
 		Help: "Number of workers created.",
 	})
 
+	promQueueReplayed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_queue_replayed",
+		Help: "Merge requests replayed from the queue on start up which were not processed before a restart.",
+	})
+
+	promRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_retries",
+		Help: "Merge requests returned to the queue to retry after failing with a transient error.",
+	},
+		[]string{
+			"group",
+		},
+	)
+
+	promProviderUnavailable = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_provider_unavailable",
+		Help: "Approvers answered unavailable by an availability provider, such as their slack status or a calendar.",
+	},
+		[]string{
+			"provider",
+			"group",
+		},
+	)
+
+	promCalendarReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_calendar_reads",
+		Help: "Calendar feeds read for out of office events.",
+	},
+		[]string{
+			"result",
+		},
+	)
+
+	promAwayApprovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_away_approvers",
+		Help: "Approvers away, in do not disturb on slack or outside working hours, only selected when not enough other approvers are available.",
+	},
+		[]string{
+			"reason",
+			"group",
+		},
+	)
+
+	promCoalesced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_coalesced",
+		Help: "Merge request events replaced by a later event for the same merge request before processing.",
+	},
+		[]string{
+			"group",
+		},
+	)
+
+	promLockContention = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_lock_contention",
+		Help: "Merge requests a worker waited to process while another worker was processing the same merge request.",
+	},
+		[]string{
+			"group",
+		},
+	)
+
+	promShutdownUndrained = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_shutdown_undrained",
+		Help: "Merge requests not processed before shutdown.",
+	},
+		[]string{
+			"group",
+		},
+	)
+
+	promDeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_mr_wh_dead_letters",
+		Help: "Merge requests parked in the dead letter list after a permanent error or exceeding max attempts.",
+	},
+		[]string{
+			"group",
+		},
+	)
+
+	promDeadLettersParked = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gitlab_mr_wh_dead_letters_parked",
+		Help: "Number of merge requests currently in the dead letter list.",
+	})
+
 	promWorkersWorking = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "gitlab_mr_wh_workers_working",
 		Help: "Number of workers working.",
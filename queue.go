@@ -0,0 +1,326 @@
+// A queue of merge requests accepted through the webhook waiting to be processed by the workers
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const queueSize = 10000
+
+// job: a merge request in the queue, the id is used to acknowledge the job once processed and attempts records the
+// number of times processing has failed
+type job struct {
+	id       uint64
+	mr       MergeRequest
+	attempts int
+}
+
+type requestQueue interface {
+	push(mr MergeRequest) error
+	retry(j job) error
+	jobs() <-chan job
+	ack(j job) error
+	size() int
+	// durable: jobs not acknowledged before close are replayed on the next start up
+	durable() bool
+	close() error
+}
+
+// newRequestQueue: create the queue backend set in the configuration, defaults to memory
+func newRequestQueue(config Config) (requestQueue, error) {
+	switch config.RequestQueue.Backend {
+	case storeFile:
+		return newFileQueue(config.storePath(config.RequestQueue, "queue.log"), queueSize)
+	default:
+		return newMemoryQueue(queueSize), nil
+	}
+}
+
+// memoryQueue: queued jobs are lost on restart
+type memoryQueue struct {
+	mu     sync.Mutex
+	nextID uint64
+	queue  chan job
+}
+
+func newMemoryQueue(size int) *memoryQueue {
+	return &memoryQueue{
+		queue: make(chan job, size),
+	}
+}
+
+func (mq *memoryQueue) push(mr MergeRequest) error {
+	mq.mu.Lock()
+	mq.nextID++
+	j := job{id: mq.nextID, mr: mr}
+	mq.mu.Unlock()
+
+	mq.queue <- j
+	return nil
+}
+
+func (mq *memoryQueue) retry(j job) error {
+	mq.queue <- j
+	return nil
+}
+
+func (mq *memoryQueue) jobs() <-chan job {
+	return mq.queue
+}
+
+func (mq *memoryQueue) ack(j job) error {
+	return nil
+}
+
+func (mq *memoryQueue) size() int {
+	return len(mq.queue)
+}
+
+func (mq *memoryQueue) durable() bool {
+	return false
+}
+
+func (mq *memoryQueue) close() error {
+	return nil
+}
+
+// fileQueue: every pushed and acknowledged job is appended to a log on disk before being accepted, on start up any
+// job without an acknowledgement is replayed onto the queue.
+type fileQueue struct {
+	path    string
+	mu      sync.Mutex
+	file    *os.File
+	nextID  uint64
+	pending map[uint64]job
+	queue   chan job
+}
+
+type queueRecord struct {
+	Op       string        `json:"op"`
+	ID       uint64        `json:"id"`
+	MR       *MergeRequest `json:"mr,omitempty"`
+	Attempts int           `json:"attempts,omitempty"`
+}
+
+const (
+	queueOpPush = "push"
+	queueOpAck  = "ack"
+)
+
+func newFileQueue(path string, size int) (*fileQueue, error) {
+	fq := &fileQueue{
+		path:    path,
+		pending: make(map[uint64]job),
+	}
+
+	if err := fq.replay(); err != nil {
+		return nil, err
+	}
+
+	if len(fq.pending) > size {
+		size = len(fq.pending)
+	}
+	fq.queue = make(chan job, size)
+
+	// Rewrite the log with only the pending jobs so it does not grow between restarts
+	if err := fq.compact(); err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for id := range fq.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		fq.queue <- fq.pending[id]
+	}
+
+	if len(ids) > 0 {
+		promQueueReplayed.Add(float64(len(ids)))
+		log.WithFields(log.Fields{"path": path, "num_replayed": len(ids)}).Info("replayed unprocessed merge requests from queue.")
+	}
+
+	return fq, nil
+}
+
+// replay: read the log building the list of pending jobs, a partially written last line is ignored
+func (fq *fileQueue) replay() error {
+	f, err := os.Open(fq.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record queueRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			promErrors.WithLabelValues("queue_replay").Inc()
+			log.WithFields(log.Fields{"path": fq.path, "error": err}).Warn("skipping invalid queue record.")
+			continue
+		}
+
+		switch record.Op {
+		case queueOpPush:
+			if record.MR != nil {
+				fq.pending[record.ID] = job{id: record.ID, mr: *record.MR, attempts: record.Attempts}
+			}
+		case queueOpAck:
+			delete(fq.pending, record.ID)
+		}
+		if record.ID > fq.nextID {
+			fq.nextID = record.ID
+		}
+	}
+	return scanner.Err()
+}
+
+// compact: replace the log with one holding only the pending jobs
+func (fq *fileQueue) compact() error {
+	if fq.file != nil {
+		fq.file.Close()
+	}
+
+	tmp := fq.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, j := range fq.pending {
+		mr := j.mr
+		data, err := json.Marshal(queueRecord{Op: queueOpPush, ID: j.id, MR: &mr, Attempts: j.attempts})
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, fq.path); err != nil {
+		return err
+	}
+
+	fq.file, err = os.OpenFile(fq.path, os.O_APPEND|os.O_WRONLY, 0600)
+	return err
+}
+
+// write: append a record to the log and sync to disk, must hold lock
+func (fq *fileQueue) write(record queueRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := fq.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return fq.file.Sync()
+}
+
+func (fq *fileQueue) push(mr MergeRequest) error {
+	fq.mu.Lock()
+	fq.nextID++
+	j := job{id: fq.nextID, mr: mr}
+	err := fq.write(queueRecord{Op: queueOpPush, ID: j.id, MR: &mr})
+	if err == nil {
+		fq.pending[j.id] = j
+	}
+	fq.mu.Unlock()
+
+	if err != nil {
+		promErrors.WithLabelValues("queue_push").Inc()
+		return fmt.Errorf("failed to write merge request to queue: %s", err)
+	}
+
+	fq.queue <- j
+	return nil
+}
+
+// retry: record the number of attempts against the job before returning it to the queue
+func (fq *fileQueue) retry(j job) error {
+	fq.mu.Lock()
+	mr := j.mr
+	err := fq.write(queueRecord{Op: queueOpPush, ID: j.id, MR: &mr, Attempts: j.attempts})
+	if err == nil {
+		fq.pending[j.id] = j
+	}
+	fq.mu.Unlock()
+
+	if err != nil {
+		promErrors.WithLabelValues("queue_retry").Inc()
+		return fmt.Errorf("failed to write merge request retry to queue: %s", err)
+	}
+
+	fq.queue <- j
+	return nil
+}
+
+func (fq *fileQueue) jobs() <-chan job {
+	return fq.queue
+}
+
+// ack: record the job as processed, once nothing is pending the log is truncated
+func (fq *fileQueue) ack(j job) error {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+
+	if _, ok := fq.pending[j.id]; !ok {
+		return nil
+	}
+
+	if err := fq.write(queueRecord{Op: queueOpAck, ID: j.id}); err != nil {
+		promErrors.WithLabelValues("queue_ack").Inc()
+		return fmt.Errorf("failed to acknowledge merge request in queue: %s", err)
+	}
+	delete(fq.pending, j.id)
+
+	if len(fq.pending) == 0 {
+		if err := fq.compact(); err != nil {
+			promErrors.WithLabelValues("queue_compact").Inc()
+			return fmt.Errorf("failed to compact queue: %s", err)
+		}
+	}
+	return nil
+}
+
+func (fq *fileQueue) size() int {
+	return len(fq.queue)
+}
+
+func (fq *fileQueue) durable() bool {
+	return true
+}
+
+// close: close the log, any further writes fail
+func (fq *fileQueue) close() error {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+
+	return fq.file.Close()
+}
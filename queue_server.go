@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type queueHandler struct {
+	scheduler *Scheduler
+}
+
+type queueResponseData struct {
+	DeadLetters []deadLetterEntry
+	Response    queueFormResponse
+	QueueSize   int
+	ServerTime  time.Time
+}
+
+type queueFormResponse struct {
+	Result string
+	ID     string
+	Error  string
+}
+
+func (q queueHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var qfr queueFormResponse
+	t := time.Now()
+
+	if request.Method == http.MethodPost {
+		id := request.FormValue("id")
+		letterID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			qfr = queueFormResponse{"ignored", id, "invalid id"}
+		} else if request.FormValue("retry") == "retry" {
+			log.Debug("queue admin: retrying dead letter: ", id)
+			err := q.scheduler.redrive(letterID)
+			if err != nil {
+				qfr = queueFormResponse{"retried", id, err.Error()}
+			} else {
+				qfr = queueFormResponse{Result: "retried", ID: id}
+			}
+		} else if request.FormValue("discard") == "discard" {
+			log.Debug("queue admin: discarding dead letter: ", id)
+			err := q.scheduler.discard(letterID)
+			if err != nil {
+				qfr = queueFormResponse{"discarded", id, err.Error()}
+			} else {
+				qfr = queueFormResponse{Result: "discarded", ID: id}
+			}
+		} else {
+			log.Error("queue admin: unexpected form entry when dealing with: ", id)
+		}
+	}
+
+	promQueueAdmin.Inc()
+
+	queueTemplate, err := template.New("queue.html").ParseFiles("./templates/queue.html")
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("handling queue admin request.")
+		writer.WriteHeader(500)
+		_, err := writer.Write([]byte(fmt.Sprintf("error handling the request: %v", err)))
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("failed to write fail header to external connection.")
+		}
+		return
+	}
+
+	err = queueTemplate.Execute(writer, queueResponseData{q.scheduler.deadLetters.getList(), qfr, q.scheduler.size(), t.Local()})
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("handling queue admin request.")
+		writer.WriteHeader(500)
+		_, err := writer.Write([]byte(fmt.Sprintf("error handling the request: %v", err)))
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("failed to write fail header to external connection.")
+		}
+		return
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests
+
+func TestMemoryQueue(t *testing.T) {
+	queue := newMemoryQueue(10)
+
+	mr1 := MergeRequest{projectID: 1, mergeReqID: 1}
+	mr2 := MergeRequest{projectID: 1, mergeReqID: 2}
+	assert.NoError(t, queue.push(mr1))
+	assert.NoError(t, queue.push(mr2))
+	assert.Equal(t, 2, queue.size())
+
+	j := <-queue.jobs()
+	assert.Equal(t, job{id: 1, mr: mr1}, j)
+	assert.NoError(t, queue.ack(j))
+	assert.Equal(t, 1, queue.size())
+}
+
+func TestFileQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+
+	mr1 := MergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 1, mergeReqTitle: "one"}
+	mr2 := MergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 2, mergeReqTitle: "two"}
+	mr3 := MergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 3, mergeReqTitle: "three", workInProgress: true}
+
+	queue, err := newFileQueue(path, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, queue.size())
+
+	for _, mr := range []MergeRequest{mr1, mr2, mr3} {
+		assert.NoError(t, queue.push(mr))
+	}
+
+	// process first job only before a restart
+	j := <-queue.jobs()
+	assert.Equal(t, mr1, j.mr)
+	assert.NoError(t, queue.ack(j))
+
+	restarted, err := newFileQueue(path, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, restarted.size())
+
+	j2 := <-restarted.jobs()
+	j3 := <-restarted.jobs()
+	assert.Equal(t, job{id: 2, mr: mr2}, j2)
+	assert.Equal(t, job{id: 3, mr: mr3}, j3)
+
+	// new jobs continue the id sequence
+	assert.NoError(t, restarted.push(mr1))
+	j4 := <-restarted.jobs()
+	assert.Equal(t, uint64(4), j4.id)
+
+	// acknowledging everything truncates the log
+	for _, j := range []job{j2, j3, j4} {
+		assert.NoError(t, restarted.ack(j))
+	}
+	stat, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), stat.Size())
+}
+
+func TestFileQueueReplayInvalidRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+
+	contents := `{"op":"push","id":1,"mr":{"project_id":1,"merge_request_id":1}}
+{"op":"push","id":2,"mr":{"project_id":1,"merge_request_id":2}}
+{"op":"ack","id":1}
+{"op":"push","id":3,"mr":{"proj`
+	assert.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+
+	queue, err := newFileQueue(path, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, queue.size())
+
+	j := <-queue.jobs()
+	assert.Equal(t, job{id: 2, mr: MergeRequest{projectID: 1, mergeReqID: 2}}, j)
+}
+
+func TestFileQueueRetry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+	mr := MergeRequest{projectID: 1, mergeReqID: 1}
+
+	queue, err := newFileQueue(path, 10)
+	assert.NoError(t, err)
+	assert.NoError(t, queue.push(mr))
+
+	j := <-queue.jobs()
+	j.attempts = 2
+	assert.NoError(t, queue.retry(j))
+	<-queue.jobs()
+
+	// attempts kept after a restart
+	restarted, err := newFileQueue(path, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, job{id: 1, mr: mr, attempts: 2}, <-restarted.jobs())
+}
+
+func TestFileQueueClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+	mr := MergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 1}
+
+	queue, err := newFileQueue(path, 10)
+	assert.NoError(t, err)
+	assert.True(t, queue.durable())
+	assert.NoError(t, queue.push(mr))
+	assert.NoError(t, queue.close())
+	assert.Error(t, queue.push(mr))
+
+	// job pushed before close is replayed
+	queue, err = newFileQueue(path, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, queue.size())
+}
@@ -0,0 +1,108 @@
+// Classification of errors returned when processing a merge request to determine if it should be retried
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+type errorClass uint8
+
+const (
+	// errorPermanent: will fail again if retried, parked in the dead letter list
+	errorPermanent errorClass = iota
+	// errorTransient: expected to succeed at a later time, retried with backoff
+	errorTransient
+	// errorIgnored: merge request does not require a reviewer, nothing to retry or park
+	errorIgnored
+)
+
+func (ec errorClass) String() string {
+	switch ec {
+	case errorTransient:
+		return "transient"
+	case errorIgnored:
+		return "ignored"
+	default:
+		return "permanent"
+	}
+}
+
+const (
+	defaultRetryMaxAttempts    = 5
+	defaultRetryInitialBackoff = 30 * time.Second
+	defaultRetryMaxBackoff     = 10 * time.Minute
+)
+
+// retryable: implemented by slack api errors such as rate limiting and 5xx responses
+type retryable interface {
+	Retryable() bool
+}
+
+// classifyError: determine if an error processing a merge request is worth retrying
+func classifyError(err error) errorClass {
+	if err == nil {
+		return errorIgnored
+	}
+
+	// Projects without a group configured are never assigned reviewers
+	if errors.Is(err, errNoSuggestedApprovers) || errors.Is(err, errNoApprovalsRequired) || errors.Is(err, errNoGroupChannel) {
+		return errorIgnored
+	}
+
+	// Approvers may become available again as their status changes
+	if errors.Is(err, errNoApprovers) {
+		return errorTransient
+	}
+
+	var gitlabErr *gitlab.ErrorResponse
+	if errors.As(err, &gitlabErr) && gitlabErr.Response != nil {
+		code := gitlabErr.Response.StatusCode
+		if code >= http.StatusInternalServerError || code == http.StatusTooManyRequests {
+			return errorTransient
+		}
+		return errorPermanent
+	}
+
+	var slackErr retryable
+	if errors.As(err, &slackErr) && slackErr.Retryable() {
+		return errorTransient
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return errorTransient
+	}
+
+	return errorPermanent
+}
+
+// withDefaults: fill in any retry settings not set in the configuration file
+func (r Retry) withDefaults() Retry {
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if r.InitialBackoff <= 0 {
+		r.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = defaultRetryMaxBackoff
+	}
+	return r
+}
+
+// backoff: exponential delay before the next attempt, doubling from the initial backoff up to the max backoff
+func (r Retry) backoff(attempts int) time.Duration {
+	delay := r.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= r.MaxBackoff {
+			return r.MaxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+// Tests
+
+func TestClassifyError(t *testing.T) {
+	gitlabErr := func(code int) error {
+		return &gitlab.ErrorResponse{Response: &http.Response{StatusCode: code, Request: &http.Request{Method: "GET"}}, Message: "error"}
+	}
+
+	type test struct {
+		err  error
+		want errorClass
+	}
+
+	tests := []test{
+		{err: nil, want: errorIgnored},
+		{err: errNoSuggestedApprovers, want: errorIgnored},
+		{err: errNoApprovalsRequired, want: errorIgnored},
+		{err: errNoApprovers, want: errorTransient},
+		{err: fmt.Errorf("failed to get mr: %w, http_code: %d", gitlabErr(502), 502), want: errorTransient},
+		{err: fmt.Errorf("failed to get mr: %w, http_code: %d", gitlabErr(429), 429), want: errorTransient},
+		{err: fmt.Errorf("failed to get mr: %w, http_code: %d", gitlabErr(404), 404), want: errorPermanent},
+		{err: fmt.Errorf("slack: failed to get user details: %w\n", &slack.RateLimitedError{RetryAfter: time.Second}), want: errorTransient},
+		{err: fmt.Errorf("failed to get mr: %w, http_code: %d", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, 0), want: errorTransient},
+		{err: errNoGroupChannel, want: errorIgnored},
+		{err: errors.New("unexpected error"), want: errorPermanent},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, classifyError(tc.err), fmt.Sprintf("%v", tc.err))
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	retry := Retry{}.withDefaults()
+	assert.Equal(t, Retry{MaxAttempts: 5, InitialBackoff: 30 * time.Second, MaxBackoff: 10 * time.Minute}, retry)
+
+	type test struct {
+		attempts int
+		want     time.Duration
+	}
+
+	tests := []test{
+		{attempts: 1, want: 30 * time.Second},
+		{attempts: 2, want: time.Minute},
+		{attempts: 3, want: 2 * time.Minute},
+		{attempts: 5, want: 8 * time.Minute},
+		{attempts: 6, want: 10 * time.Minute},
+		{attempts: 20, want: 10 * time.Minute},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, retry.backoff(tc.attempts))
+	}
+}
@@ -0,0 +1,145 @@
+// Review status shown on the slack message sent for a merge request. Approve, merge and close events received through
+// the gitlab webhook edit the message so the channel shows the status of each review at a glance.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	reviewApproved   = "approved"
+	reviewUnapproved = "unapproved"
+	reviewMerged     = "merged"
+	reviewClosed     = "closed"
+)
+
+// reviewStatus: the review status for a merge request event action, false when the action does not change the status
+func reviewStatus(action string) (string, bool) {
+	switch strings.ToLower(action) {
+	case "approved", "approval":
+		return reviewApproved, true
+	case "unapproved", "unapproval":
+		return reviewUnapproved, true
+	case "merge", "merged":
+		return reviewMerged, true
+	case "close", "closed":
+		return reviewClosed, true
+	}
+	return "", false
+}
+
+// reviewStatusUpdater: updates are made one at a time as gitlab sends an approval and approved event together
+type reviewStatusUpdater struct {
+	mu       sync.Mutex
+	slack    SlackWrapper
+	messages messageStore
+}
+
+func newReviewStatusUpdater(slack SlackWrapper, messages messageStore) *reviewStatusUpdater {
+	return &reviewStatusUpdater{slack: slack, messages: messages}
+}
+
+// update: edit the message sent for the merge request to show the status, merge requests without a message sent are
+// ignored. The message is forgotten once merged or closed.
+func (u *reviewStatusUpdater) update(mr MergeRequests, status string, user string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	logger := log.WithFields(log.Fields{"group": mr.Group(), "project_id": mr.ProjectID(), "merge_request_id": mr.MergeReqID(), "status": status})
+
+	key := mergeRequestKey(mr)
+	m, ok := u.messages.load(key)
+	if !ok {
+		logger.Debug("no slack message sent for merge request, nothing to update.")
+		return nil
+	}
+
+	switch status {
+	case reviewApproved:
+		if !containsString(m.ApprovedBy, user) {
+			m.ApprovedBy = append(m.ApprovedBy, user)
+		}
+	case reviewUnapproved:
+		m.ApprovedBy = removeString(m.ApprovedBy, user)
+	}
+
+	if err := updateSlackMsg(u.slack, m.ChannelID, m.Timestamp, m.Text, statusBlocks(m, mr, status, user), mr); err != nil {
+		return err
+	}
+	promSlackMsgUpdates.WithLabelValues(status, mr.Group()).Inc()
+	logger.Debug("updated slack message with review status.")
+
+	if status == reviewMerged || status == reviewClosed {
+		return u.messages.delete(key)
+	}
+	return u.messages.save(key, m)
+}
+
+// statusBlocks: the review message with the approvals and, once merged or closed, struck through. Buttons are kept
+// on interactive messages until merged or closed as the review is still open.
+func statusBlocks(m sentMessage, mr MergeRequests, status string, user string) []slack.Block {
+	text := m.Text
+	var lines []string
+	if len(m.ApprovedBy) > 0 {
+		lines = append(lines, fmt.Sprintf(":white_check_mark: Approved by %s", strings.Join(m.ApprovedBy, ", ")))
+	}
+	done := false
+	switch status {
+	case reviewMerged:
+		text, done = strikeThrough(text), true
+		lines = append(lines, fmt.Sprintf(":tada: Merged by %s", user))
+	case reviewClosed:
+		text, done = strikeThrough(text), true
+		lines = append(lines, fmt.Sprintf(":no_entry_sign: Closed by %s", user))
+	}
+
+	footer := m.Footer
+	if !m.FooterSet {
+		footer = defaultFooterTemplate
+	}
+	blocks := reviewBlocks(text, footer, nil, false)
+	if len(lines) > 0 {
+		blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, strings.Join(lines, "\n"), false, false)))
+	}
+	if m.Interactive && !done {
+		if actions, ok := reviewActions(mr); ok {
+			blocks = append(blocks, actions)
+		}
+	}
+	return blocks
+}
+
+// strikeThrough: strike through each line, slack does not strike through text spanning lines
+func strikeThrough(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if trimmed := strings.TrimSpace(line); trimmed != "" {
+			lines[i] = fmt.Sprintf("~%s~", trimmed)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	var kept []string
+	for _, l := range list {
+		if l != s {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
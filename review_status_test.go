@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests
+
+func TestReviewStatus(t *testing.T) {
+	type test struct {
+		action string
+		want   string
+		wantOk bool
+	}
+
+	tests := []test{
+		{action: "approved", want: reviewApproved, wantOk: true},
+		{action: "approval", want: reviewApproved, wantOk: true},
+		{action: "unapproval", want: reviewUnapproved, wantOk: true},
+		{action: "merge", want: reviewMerged, wantOk: true},
+		{action: "close", want: reviewClosed, wantOk: true},
+		{action: "open"},
+		{action: "update"},
+	}
+
+	for _, tc := range tests {
+		got, ok := reviewStatus(tc.action)
+		assert.Equal(t, tc.wantOk, ok, tc.action)
+		assert.Equal(t, tc.want, got, tc.action)
+	}
+}
+
+func TestReviewStatusUpdate(t *testing.T) {
+	mr := MergeRequest{group: "test", projectID: 1, mergeReqID: 1}
+	key := mergeRequestKey(mr)
+	store := newMemoryMessageStore()
+	updater := newReviewStatusUpdater(&MockSlack{}, store)
+
+	// no message sent for the merge request
+	assert.NoError(t, updater.update(mr, reviewApproved, "Test User 1"))
+	_, ok := store.load(key)
+	assert.False(t, ok)
+
+	assert.NoError(t, store.save(key, sentMessage{ChannelID: "C1", Timestamp: "1660000000.000100", Text: "text", SentAt: time.Now()}))
+
+	assert.NoError(t, updater.update(mr, reviewApproved, "Test User 1"))
+	assert.NoError(t, updater.update(mr, reviewApproved, "Test User 2"))
+	assert.NoError(t, updater.update(mr, reviewApproved, "Test User 2"))
+	m, _ := store.load(key)
+	assert.Equal(t, []string{"Test User 1", "Test User 2"}, m.ApprovedBy)
+
+	assert.NoError(t, updater.update(mr, reviewUnapproved, "Test User 1"))
+	m, _ = store.load(key)
+	assert.Equal(t, []string{"Test User 2"}, m.ApprovedBy)
+
+	// forgotten once merged
+	assert.NoError(t, updater.update(mr, reviewMerged, "Test User 3"))
+	_, ok = store.load(key)
+	assert.False(t, ok)
+
+	// failed update keeps the message
+	assert.NoError(t, store.save(key, sentMessage{ChannelID: "E", Timestamp: "1660000000.000100", Text: "text", SentAt: time.Now()}))
+	assert.Error(t, updater.update(mr, reviewClosed, "Test User 3"))
+	_, ok = store.load(key)
+	assert.True(t, ok)
+}
+
+func TestStatusBlocks(t *testing.T) {
+	mr := MergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 1}
+	m := sentMessage{Text: "text", ApprovedBy: []string{"Test User 1"}}
+
+	blocks := statusBlocks(m, mr, reviewApproved, "Test User 1")
+	assert.Len(t, blocks, 3)
+	assert.Equal(t, "text", blocks[0].(*slack.SectionBlock).Text.Text)
+	status := blocks[2].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject)
+	assert.Equal(t, ":white_check_mark: Approved by Test User 1", status.Text)
+
+	blocks = statusBlocks(m, mr, reviewMerged, "Test User 2")
+	assert.Equal(t, "~text~", blocks[0].(*slack.SectionBlock).Text.Text)
+	status = blocks[2].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject)
+	assert.Equal(t, ":white_check_mark: Approved by Test User 1\n:tada: Merged by Test User 2", status.Text)
+
+	// nothing to show once all approvals removed
+	assert.Len(t, statusBlocks(sentMessage{Text: "text"}, mr, reviewUnapproved, "Test User 1"), 2)
+
+	// messages saved before footers were recorded keep the default footer, an empty footer is kept empty
+	footer := statusBlocks(sentMessage{Text: "text"}, mr, reviewUnapproved, "Test User 1")[1].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject)
+	assert.Equal(t, defaultFooterTemplate, footer.Text)
+	footer = statusBlocks(sentMessage{Text: "text", Footer: "custom", FooterSet: true}, mr, reviewUnapproved, "Test User 1")[1].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject)
+	assert.Equal(t, "custom", footer.Text)
+	assert.Len(t, statusBlocks(sentMessage{Text: "text", FooterSet: true}, mr, reviewUnapproved, "Test User 1"), 1)
+
+	// buttons kept on interactive messages until merged or closed
+	m.Interactive = true
+	blocks = statusBlocks(m, mr, reviewApproved, "Test User 1")
+	assert.Len(t, blocks, 4)
+	actions, ok := blocks[3].(*slack.ActionBlock)
+	assert.True(t, ok)
+	assert.Equal(t, reviewActionsBlockID, actions.BlockID)
+	assert.Len(t, statusBlocks(m, mr, reviewClosed, "Test User 2"), 3)
+
+	// each line struck through separately
+	blocks = statusBlocks(sentMessage{Text: "first line\n\nsecond line ", FooterSet: true}, mr, reviewMerged, "Test User 2")
+	assert.Equal(t, "~first line~\n\n~second line~", blocks[0].(*slack.SectionBlock).Text.Text)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xanzy/go-gitlab"
+)
+
+// ReviewerSelector - selects reviewers for a merge request from the list of available approvers
+type ReviewerSelector interface {
+	Select(gitClient GitlabWrapper, mr MergeRequests, approvers []*gitlab.BasicUser, approvalsRequired int) []*gitlab.BasicUser
+}
+
+// newReviewerSelector: create the reviewer selector for the strategy set in a groups selection configuration, the
+// store holds the rotation state for round robin selection
+func newReviewerSelector(group string, selection Selection, store rotationStore) (ReviewerSelector, error) {
+	if err := selection.validate(); err != nil {
+		return nil, err
+	}
+
+	switch selection.Strategy {
+	case selectionLeastLoaded:
+		return &leastLoadedSelector{}, nil
+	case selectionRoundRobin:
+		return &roundRobinSelector{group: group, store: store}, nil
+	case selectionWeighted:
+		return &weightedSelector{weights: selection.Weights}, nil
+	default:
+		return &randomSelector{}, nil
+	}
+}
+
+// tieredSelector - a selector choosing across all preference tiers at once, given the full ordered list of approvers
+// before any were filtered as unavailable
+type tieredSelector interface {
+	SelectTiers(gitClient GitlabWrapper, mr MergeRequests, order []*gitlab.BasicUser, tiers [][]*gitlab.BasicUser, approvalsRequired int) []*gitlab.BasicUser
+}
+
+// selectReviewers: select reviewers preferring approvers inside working hours and then those present on slack, when
+// enabled for the group, falling back to the remaining approvers when there are not enough preferred approvers. The
+// order is the full list of approvers from gitlab, approvers are those still available
+func selectReviewers(selector ReviewerSelector, gitClient GitlabWrapper, slack SlackWrapper, cache UserCache, presences UserCache, mr MergeRequests, selection Selection, order []*gitlab.BasicUser, approvers []*gitlab.BasicUser, approvalsRequired int) []*gitlab.BasicUser {
+	tiers := [][]*gitlab.BasicUser{approvers}
+	if selection.WorkingHours != nil {
+		now := time.Now()
+		tiers = splitTiers(tiers, func(approvers []*gitlab.BasicUser) ([]*gitlab.BasicUser, []*gitlab.BasicUser) {
+			return splitByWorkingHours(cache, approvers, *selection.WorkingHours, mr, now)
+		})
+	}
+	if selection.PreferPresent {
+		tiers = splitTiers(tiers, func(approvers []*gitlab.BasicUser) ([]*gitlab.BasicUser, []*gitlab.BasicUser) {
+			return splitByPresence(slack, cache, presences, approvers, mr)
+		})
+	}
+	if tiered, ok := selector.(tieredSelector); ok {
+		return tiered.SelectTiers(gitClient, mr, order, tiers, approvalsRequired)
+	}
+	return selectFromTiers(selector, gitClient, mr, tiers, approvalsRequired)
+}
+
+// splitTiers: split each tier into the preferred and other approvers, keeping the order of the tiers
+func splitTiers(tiers [][]*gitlab.BasicUser, split func([]*gitlab.BasicUser) ([]*gitlab.BasicUser, []*gitlab.BasicUser)) [][]*gitlab.BasicUser {
+	var splitTiers [][]*gitlab.BasicUser
+	for _, tier := range tiers {
+		preferred, other := split(tier)
+		splitTiers = append(splitTiers, preferred, other)
+	}
+	return splitTiers
+}
+
+// selectFromTiers: select reviewers from each tier in order with the selector until the approvals required are met
+func selectFromTiers(selector ReviewerSelector, gitClient GitlabWrapper, mr MergeRequests, tiers [][]*gitlab.BasicUser, approvalsRequired int) []*gitlab.BasicUser {
+	var selectedApprovers []*gitlab.BasicUser
+	for i, tier := range tiers {
+		remaining := approvalsRequired - len(selectedApprovers)
+		if remaining <= 0 {
+			break
+		}
+		if len(tier) == 0 {
+			continue
+		}
+		if i > 0 {
+			logNextTier(mr, i, remaining)
+		}
+		selectedApprovers = append(selectedApprovers, selector.Select(gitClient, mr, tier, remaining)...)
+	}
+	return selectedApprovers
+}
+
+// logNextTier: log selecting from a less preferred tier
+func logNextTier(mr MergeRequests, tier int, remaining int) {
+	log.WithFields(log.Fields{"group": mr.Group(), "project_id": mr.ProjectID(), "merge_request_id": mr.MergeReqID(), "tier": tier, "remaining": remaining}).Debug("not enough preferred approvers, selecting from next tier.")
+}
+
+// randomSelector: select random reviewers
+type randomSelector struct{}
+
+func (s *randomSelector) Select(gitClient GitlabWrapper, mr MergeRequests, approvers []*gitlab.BasicUser, approvalsRequired int) []*gitlab.BasicUser {
+	return selectApprovers(approvers, approvalsRequired)
+}
+
+// leastLoadedSelector: select reviewers with the fewest open reviews
+type leastLoadedSelector struct{}
+
+func (s *leastLoadedSelector) Select(gitClient GitlabWrapper, mr MergeRequests, approvers []*gitlab.BasicUser, approvalsRequired int) []*gitlab.BasicUser {
+	return selectLeastLoadedApprovers(gitClient, approvers, approvalsRequired, mr)
+}
+
+// roundRobinSelector: select the next reviewers in the order gitlab returns the approvers, following the last selected
+// reviewer. The last selected reviewer is kept in the rotation store so the rotation continues after a restart. The
+// position is found in the full approver list so unavailable approvers, including the last selected reviewer, are
+// skipped without restarting the rotation. When the last selected reviewer is no longer an approver the rotation starts
+// again from the first approver.
+type roundRobinSelector struct {
+	group string
+	store rotationStore
+	mu    sync.Mutex
+}
+
+func (s *roundRobinSelector) Select(gitClient GitlabWrapper, mr MergeRequests, approvers []*gitlab.BasicUser, approvalsRequired int) []*gitlab.BasicUser {
+	return s.SelectTiers(gitClient, mr, approvers, [][]*gitlab.BasicUser{approvers}, approvalsRequired)
+}
+
+// SelectTiers: walk the rotation once from the reviewer after the last selected, taking approvers from the most
+// preferred tier first. Only reviewers from the first tier selected from move the rotation, so approvers topped up from
+// a less preferred tier keep their turn.
+func (s *roundRobinSelector) SelectTiers(gitClient GitlabWrapper, mr MergeRequests, order []*gitlab.BasicUser, tiers [][]*gitlab.BasicUser, approvalsRequired int) []*gitlab.BasicUser {
+	var selectedApprovers []*gitlab.BasicUser
+
+	logger := log.WithFields(log.Fields{"group": mr.Group(), "project_id": mr.ProjectID(), "merge_request_id": mr.MergeReqID(), "rotation": s.group})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last, err := s.store.load(s.group)
+	if err != nil {
+		promErrors.WithLabelValues("rotation_state_load").Inc()
+		logger.WithFields(log.Fields{"error": err}).Warn("failed to load rotation state, starting from the beginning.")
+	}
+
+	// Turn of each approver counting from the one after the last selected reviewer, wrapping round to the start of the
+	// list. Approvers missing from the order take their turn after everyone else.
+	start := 0
+	for i, approver := range order {
+		if approver.Username == last {
+			start = i + 1
+			break
+		}
+	}
+	turns := make(map[string]int, len(order))
+	for i := range order {
+		turns[order[(start+i)%len(order)].Username] = i
+	}
+	turn := func(approver *gitlab.BasicUser) int {
+		if t, ok := turns[approver.Username]; ok {
+			return t
+		}
+		return len(order)
+	}
+
+	var next string
+	for i, tier := range tiers {
+		remaining := approvalsRequired - len(selectedApprovers)
+		if remaining <= 0 {
+			break
+		}
+		if len(tier) == 0 {
+			continue
+		}
+		if i > 0 {
+			logNextTier(mr, i, remaining)
+		}
+
+		ordered := make([]*gitlab.BasicUser, len(tier))
+		copy(ordered, tier)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return turn(ordered[i]) < turn(ordered[j])
+		})
+		if remaining < len(ordered) {
+			ordered = ordered[:remaining]
+		}
+		selectedApprovers = append(selectedApprovers, ordered...)
+		if next == "" {
+			next = ordered[len(ordered)-1].Username
+		}
+	}
+
+	if next != "" {
+		if err := s.store.save(s.group, next); err != nil {
+			promErrors.WithLabelValues("rotation_state_save").Inc()
+			logger.WithFields(log.Fields{"error": err}).Error("failed to save rotation state.")
+		}
+		logger.WithFields(log.Fields{"previous": last, "last": next}).Debug("rotation updated.")
+	}
+
+	return selectedApprovers
+}
+
+// weightedSelector: select random reviewers with a chance proportional to their configured weight, users without a
+// weight default to 1 and a weight of 0 is only selected when no one else remains
+type weightedSelector struct {
+	weights map[string]int
+}
+
+func (s *weightedSelector) Select(gitClient GitlabWrapper, mr MergeRequests, approvers []*gitlab.BasicUser, approvalsRequired int) []*gitlab.BasicUser {
+	var selectedApprovers []*gitlab.BasicUser
+
+	if approvalsRequired > len(approvers) {
+		return approvers
+	}
+
+	remaining := make([]*gitlab.BasicUser, len(approvers))
+	copy(remaining, approvers)
+
+	rand.Seed(time.Now().UnixNano())
+	for i := 0; i < approvalsRequired; i++ {
+		total := 0
+		for _, approver := range remaining {
+			total += s.weight(approver.Username)
+		}
+
+		pick := 0
+		if total == 0 {
+			pick = rand.Intn(len(remaining))
+		} else {
+			r := rand.Intn(total)
+			for j, approver := range remaining {
+				r -= s.weight(approver.Username)
+				if r < 0 {
+					pick = j
+					break
+				}
+			}
+		}
+
+		selectedApprovers = append(selectedApprovers, remaining[pick])
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
+	}
+
+	return selectedApprovers
+}
+
+func (s *weightedSelector) weight(username string) int {
+	if weight, ok := s.weights[username]; ok {
+		return weight
+	}
+	return 1
+}
+
+// Select random reviewers upto approvals required
+func selectApprovers(approvers []*gitlab.BasicUser, approvalsRequired int) []*gitlab.BasicUser {
+	var selectedApprovers []*gitlab.BasicUser
+
+	if approvalsRequired > len(approvers) {
+		return approvers
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	// Random is by the index of the result.
+	randomApprovalIndexes := rand.Perm(len(approvers))
+
+	for i := 0; i < approvalsRequired; i++ {
+		randID := randomApprovalIndexes[i]
+		selectedApprovers = append(selectedApprovers, approvers[randID])
+	}
+
+	return selectedApprovers
+}
+
+// Select reviewers with the fewest open reviews currently assigned upto approvals required. Approvers are shuffled
+// before sorting so that a random pick breaks any ties. Falls back to a random selection when the number of open
+// reviews can not be retrieved for every approver.
+func selectLeastLoadedApprovers(gitClient GitlabWrapper, approvers []*gitlab.BasicUser, approvalsRequired int, mr MergeRequests) []*gitlab.BasicUser {
+	logger := log.WithFields(log.Fields{"group": mr.Group(), "project_id": mr.ProjectID(), "merge_request_id": mr.MergeReqID()})
+
+	if approvalsRequired > len(approvers) {
+		return approvers
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	shuffled := make([]*gitlab.BasicUser, len(approvers))
+	for i, randID := range rand.Perm(len(approvers)) {
+		shuffled[i] = approvers[randID]
+	}
+
+	openReviews := make(map[int]int)
+	for _, approver := range shuffled {
+		count, err := getOpenReviewCount(gitClient, approver.ID, mr.Group())
+		if err != nil {
+			promErrors.WithLabelValues("open_review_count").Inc()
+			logger.WithFields(log.Fields{"error": err, "username": approver.Username}).Warn("failed to get open reviews, falling back to random selection.")
+			return shuffled[:approvalsRequired]
+		}
+		openReviews[approver.ID] = count
+	}
+
+	sort.SliceStable(shuffled, func(i, j int) bool {
+		return openReviews[shuffled[i].ID] < openReviews[shuffled[j].ID]
+	})
+	logger.WithFields(log.Fields{"open_reviews": openReviews}).Debug("open reviews per approver.")
+
+	return shuffled[:approvalsRequired]
+}
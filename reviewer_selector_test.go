@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+// Tests
+
+func TestNewReviewerSelector(t *testing.T) {
+	type test struct {
+		selection Selection
+		want      ReviewerSelector
+		err       error
+	}
+
+	store := newMemoryRotationStore()
+
+	tests := []test{
+		{selection: Selection{}, want: &randomSelector{}, err: nil},
+		{selection: Selection{Strategy: "random"}, want: &randomSelector{}, err: nil},
+		{selection: Selection{Strategy: "least_loaded"}, want: &leastLoadedSelector{}, err: nil},
+		{selection: Selection{Strategy: "round_robin"}, want: &roundRobinSelector{group: "test", store: store}, err: nil},
+		{selection: Selection{Strategy: "weighted", Weights: map[string]int{"test1": 2}}, want: &weightedSelector{weights: map[string]int{"test1": 2}}, err: nil},
+		{selection: Selection{Strategy: "weighted", Weights: map[string]int{"test1": -1}}, want: nil, err: errors.New("'-1' weight for user: test1 must not be negative.")},
+		{selection: Selection{Strategy: "unknown"}, want: nil, err: errors.New("'unknown' unknown selection strategy.")},
+	}
+
+	for _, tc := range tests {
+		got, err := newReviewerSelector("test", tc.selection, store)
+		if err != nil {
+			assert.Equal(t, tc.err.Error(), err.Error())
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.want, got)
+	}
+}
+
+func TestSelectApprovers(t *testing.T) {
+	type test struct {
+		approvers         []*gitlab.BasicUser
+		approvalsRequired int
+		validIDs          []int
+	}
+
+	a1 := &gitlab.BasicUser{
+		ID:       1,
+		Name:     "Test User 1",
+		Username: "test.user1",
+	}
+	a2 := &gitlab.BasicUser{
+		ID:       2,
+		Name:     "Test User 2",
+		Username: "test.user2",
+	}
+	a3 := &gitlab.BasicUser{
+		ID:       3,
+		Name:     "Test User 3",
+		Username: "test.user3",
+	}
+	approvers := []*gitlab.BasicUser{a1, a2, a3}
+
+	tests := []test{
+		// should return empty list
+		{approvers: approvers, approvalsRequired: 0, validIDs: []int{}},
+		{approvers: approvers, approvalsRequired: 1, validIDs: []int{1, 2, 3}},
+		{approvers: approvers, approvalsRequired: 2, validIDs: []int{1, 2, 3}},
+		// should return 3 approvers
+		{approvers: approvers, approvalsRequired: 4, validIDs: []int{1, 2, 3}},
+	}
+
+	for _, tc := range tests {
+		selectedApprovers := selectApprovers(tc.approvers, tc.approvalsRequired)
+		if len(tc.approvers) > tc.approvalsRequired {
+			assert.Equal(t, tc.approvalsRequired, len(selectedApprovers))
+		}
+		for _, sa := range selectedApprovers {
+			assert.Contains(t, tc.validIDs, sa.ID)
+		}
+	}
+}
+
+func TestSelectLeastLoadedApprovers(t *testing.T) {
+	type test struct {
+		approvers         []*gitlab.BasicUser
+		approvalsRequired int
+		validIDs          []int
+	}
+
+	mockGitClient := &mockGitlab{}
+	mockMR := MockMergeRequest{
+		pathWithNamespace: "test/test",
+		group:             "test",
+		projectID:         1,
+		mergeReqID:        1,
+	}
+
+	// Open reviews returned by the mock: 1 => 5, 2 => 0, 3 => 2, 4 => error
+	a1 := &gitlab.BasicUser{ID: 1, Username: "test.user1"}
+	a2 := &gitlab.BasicUser{ID: 2, Username: "test.user2"}
+	a3 := &gitlab.BasicUser{ID: 3, Username: "test.user3"}
+	a4 := &gitlab.BasicUser{ID: 4, Username: "test.user4"}
+	approvers := []*gitlab.BasicUser{a1, a2, a3}
+
+	tests := []test{
+		{approvers: approvers, approvalsRequired: 0, validIDs: []int{}},
+		{approvers: approvers, approvalsRequired: 1, validIDs: []int{2}},
+		{approvers: approvers, approvalsRequired: 2, validIDs: []int{2, 3}},
+		// should return 3 approvers
+		{approvers: approvers, approvalsRequired: 4, validIDs: []int{1, 2, 3}},
+		// failing to get open reviews falls back to random
+		{approvers: []*gitlab.BasicUser{a1, a2, a4}, approvalsRequired: 2, validIDs: []int{1, 2, 4}},
+	}
+
+	for _, tc := range tests {
+		selectedApprovers := selectLeastLoadedApprovers(mockGitClient, tc.approvers, tc.approvalsRequired, mockMR)
+		if len(tc.approvers) > tc.approvalsRequired {
+			assert.Equal(t, tc.approvalsRequired, len(selectedApprovers))
+		}
+		for _, sa := range selectedApprovers {
+			assert.Contains(t, tc.validIDs, sa.ID)
+		}
+	}
+}
+
+func TestRoundRobinSelector(t *testing.T) {
+	a1 := &gitlab.BasicUser{ID: 1, Username: "test.user1"}
+	a2 := &gitlab.BasicUser{ID: 2, Username: "test.user2"}
+	a3 := &gitlab.BasicUser{ID: 3, Username: "test.user3"}
+	a4 := &gitlab.BasicUser{ID: 4, Username: "test.user4"}
+	all := []*gitlab.BasicUser{a1, a2, a3, a4}
+
+	type test struct {
+		order             []*gitlab.BasicUser
+		tiers             [][]*gitlab.BasicUser
+		approvalsRequired int
+		wantIDs           []int
+	}
+
+	// Each test continues the rotation from the previous one
+	tests := []test{
+		{order: all, tiers: [][]*gitlab.BasicUser{all}, approvalsRequired: 1, wantIDs: []int{1}},
+		{order: all, tiers: [][]*gitlab.BasicUser{all}, approvalsRequired: 1, wantIDs: []int{2}},
+		// last selected reviewer test.user2 unavailable, rotation moves on to test.user3
+		{order: all, tiers: [][]*gitlab.BasicUser{{a1, a3, a4}}, approvalsRequired: 1, wantIDs: []int{3}},
+		// test.user4 unavailable so is skipped
+		{order: all, tiers: [][]*gitlab.BasicUser{{a1, a2, a3}}, approvalsRequired: 2, wantIDs: []int{1, 2}},
+		{order: all, tiers: [][]*gitlab.BasicUser{all}, approvalsRequired: 0, wantIDs: nil},
+		// preferred tier first, topping up from the next tier does not move the rotation
+		{order: all, tiers: [][]*gitlab.BasicUser{{a4}, {a1, a3}}, approvalsRequired: 2, wantIDs: []int{4, 3}},
+		{order: all, tiers: [][]*gitlab.BasicUser{all}, approvalsRequired: 1, wantIDs: []int{1}},
+		// more approvals required than approvers
+		{order: all, tiers: [][]*gitlab.BasicUser{{a3, a2}}, approvalsRequired: 3, wantIDs: []int{2, 3}},
+		// order gitlab returns the approvers in is kept, not username order
+		{order: []*gitlab.BasicUser{a3, a1, a2}, tiers: [][]*gitlab.BasicUser{{a3, a1, a2}}, approvalsRequired: 1, wantIDs: []int{1}},
+		{order: []*gitlab.BasicUser{a3, a1, a2}, tiers: [][]*gitlab.BasicUser{{a3, a1, a2}}, approvalsRequired: 1, wantIDs: []int{2}},
+		// last selected reviewer no longer an approver starts from the first approver
+		{order: []*gitlab.BasicUser{a3, a1}, tiers: [][]*gitlab.BasicUser{{a3, a1}}, approvalsRequired: 1, wantIDs: []int{3}},
+	}
+
+	path := filepath.Join(t.TempDir(), "rotation.json")
+	mockMR := MockMergeRequest{group: "test", projectID: 1, mergeReqID: 1}
+
+	for _, tc := range tests {
+		// Recreate the store for each test to confirm the rotation survives a restart
+		store, err := newFileRotationStore(&osFS{}, path)
+		assert.NoError(t, err)
+		selector := &roundRobinSelector{group: "test", store: store}
+
+		var gotIDs []int
+		for _, sa := range selector.SelectTiers(&mockGitlab{}, mockMR, tc.order, tc.tiers, tc.approvalsRequired) {
+			gotIDs = append(gotIDs, sa.ID)
+		}
+		assert.Equal(t, tc.wantIDs, gotIDs)
+	}
+
+	// Rotation is kept per group
+	store, err := newFileRotationStore(&osFS{}, path)
+	assert.NoError(t, err)
+	selector := &roundRobinSelector{group: "other", store: store}
+	got := selector.Select(&mockGitlab{}, mockMR, []*gitlab.BasicUser{a1, a2, a3}, 1)
+	assert.Equal(t, []*gitlab.BasicUser{a1}, got)
+}
+
+func TestWeightedSelector(t *testing.T) {
+	a1 := &gitlab.BasicUser{ID: 1, Username: "test.user1"}
+	a2 := &gitlab.BasicUser{ID: 2, Username: "test.user2"}
+	a3 := &gitlab.BasicUser{ID: 3, Username: "test.user3"}
+	approvers := []*gitlab.BasicUser{a1, a2, a3}
+
+	type test struct {
+		weights           map[string]int
+		approvalsRequired int
+		validIDs          []int
+	}
+
+	tests := []test{
+		{weights: nil, approvalsRequired: 1, validIDs: []int{1, 2, 3}},
+		{weights: map[string]int{"test.user1": 0, "test.user2": 0}, approvalsRequired: 1, validIDs: []int{3}},
+		{weights: map[string]int{"test.user1": 0, "test.user2": 0}, approvalsRequired: 2, validIDs: []int{1, 2, 3}},
+		{weights: map[string]int{"test.user1": 0, "test.user2": 0, "test.user3": 0}, approvalsRequired: 1, validIDs: []int{1, 2, 3}},
+		// should return 3 approvers
+		{weights: nil, approvalsRequired: 4, validIDs: []int{1, 2, 3}},
+	}
+
+	mockMR := MockMergeRequest{group: "test", projectID: 1, mergeReqID: 1}
+
+	for _, tc := range tests {
+		selector := &weightedSelector{weights: tc.weights}
+		selectedApprovers := selector.Select(&mockGitlab{}, mockMR, approvers, tc.approvalsRequired)
+		if len(approvers) > tc.approvalsRequired {
+			assert.Equal(t, tc.approvalsRequired, len(selectedApprovers))
+		}
+		for _, sa := range selectedApprovers {
+			assert.Contains(t, tc.validIDs, sa.ID)
+		}
+		// approvers passed in must not be modified
+		assert.Equal(t, []*gitlab.BasicUser{a1, a2, a3}, approvers)
+	}
+}
+
+func TestSelectReviewers(t *testing.T) {
+	mockMR := MockMergeRequest{group: "test", projectID: 1, mergeReqID: 1}
+
+	// Working hours an hour either side of now in UTC, users twelve hours ahead are outside working hours
+	now := time.Now().UTC()
+	workingHours := &WorkingHours{
+		Start: now.Add(-time.Hour).Format(workingHoursLayout),
+		End:   now.Add(time.Hour).Format(workingHoursLayout),
+		Days:  []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"},
+	}
+
+	cache := newLocalCache()
+	expire := now.Add(time.Hour).Unix()
+	users := []userMeta{
+		{username: "a.active", slackUserID: "1", timezone: "UTC"},
+		{username: "b.away", slackUserID: "2", timezone: "UTC"},
+		{username: "c.snoozed", slackUserID: "3", timezone: "UTC"},
+		{username: "d.error", slackUserID: "E", timezone: "UTC"},
+		{username: "e.unmapped", timezone: "UTC"},
+		{username: "f.asleep", slackUserID: "1", timezone: "Etc/GMT-12"},
+		{username: "g.no_timezone", slackUserID: "1"},
+	}
+	for _, u := range users {
+		cache.update(u, expire)
+	}
+
+	type test struct {
+		selection         Selection
+		approvers         []string
+		approvalsRequired int
+		want              []string
+	}
+
+	tests := []test{
+		// no preference, first in the order given
+		{selection: Selection{}, approvers: []string{"b.away", "a.active"}, approvalsRequired: 1, want: []string{"b.away"}},
+		{selection: Selection{}, approvers: []string{"b.away", "a.active"}, approvalsRequired: 0, want: nil},
+		// present approvers only, failed lookups and users without a slack user id are treated as present
+		{selection: Selection{PreferPresent: true}, approvers: []string{"a.active", "b.away", "c.snoozed"}, approvalsRequired: 1, want: []string{"a.active"}},
+		{selection: Selection{PreferPresent: true}, approvers: []string{"b.away", "d.error"}, approvalsRequired: 1, want: []string{"d.error"}},
+		{selection: Selection{PreferPresent: true}, approvers: []string{"c.snoozed", "e.unmapped"}, approvalsRequired: 1, want: []string{"e.unmapped"}},
+		// not enough present approvers, topped up from away approvers
+		{selection: Selection{PreferPresent: true}, approvers: []string{"a.active", "b.away"}, approvalsRequired: 2, want: []string{"a.active", "b.away"}},
+		{selection: Selection{PreferPresent: true}, approvers: []string{"b.away", "c.snoozed"}, approvalsRequired: 1, want: []string{"b.away"}},
+		// inside working hours first, users without a timezone are treated as inside
+		{selection: Selection{WorkingHours: workingHours}, approvers: []string{"f.asleep", "g.no_timezone"}, approvalsRequired: 1, want: []string{"g.no_timezone"}},
+		{selection: Selection{WorkingHours: workingHours}, approvers: []string{"f.asleep", "b.away"}, approvalsRequired: 1, want: []string{"b.away"}},
+		{selection: Selection{WorkingHours: workingHours}, approvers: []string{"f.asleep"}, approvalsRequired: 1, want: []string{"f.asleep"}},
+		// working hours take priority over presence
+		{selection: Selection{WorkingHours: workingHours, PreferPresent: true}, approvers: []string{"f.asleep", "b.away"}, approvalsRequired: 1, want: []string{"b.away"}},
+		{selection: Selection{WorkingHours: workingHours, PreferPresent: true}, approvers: []string{"f.asleep", "b.away", "a.active"}, approvalsRequired: 2, want: []string{"a.active", "b.away"}},
+		{selection: Selection{WorkingHours: workingHours, PreferPresent: true}, approvers: []string{"f.asleep", "b.away", "a.active"}, approvalsRequired: 3, want: []string{"a.active", "b.away", "f.asleep"}},
+	}
+
+	for _, tc := range tests {
+		var approvers []*gitlab.BasicUser
+		for _, username := range tc.approvers {
+			approvers = append(approvers, &gitlab.BasicUser{Username: username})
+		}
+
+		selector := &roundRobinSelector{group: "test", store: newMemoryRotationStore()}
+		selected := selectReviewers(selector, &mockGitlab{}, &MockSlack{}, cache, nil, mockMR, tc.selection, approvers, approvers, tc.approvalsRequired)
+
+		var got []string
+		for _, s := range selected {
+			got = append(got, s.Username)
+		}
+		assert.Equal(t, tc.want, got, tc.approvers)
+	}
+}
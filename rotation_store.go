@@ -0,0 +1,113 @@
+// A store for the round robin rotation state of each group, recording the last selected reviewer
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type rotationStore interface {
+	load(group string) (string, error)
+	save(group string, username string) error
+}
+
+// memoryRotationStore: rotation state lost on restart, used when no file backend configured
+type memoryRotationStore struct {
+	mu    sync.RWMutex
+	state map[string]string
+}
+
+func newMemoryRotationStore() *memoryRotationStore {
+	return &memoryRotationStore{
+		state: make(map[string]string),
+	}
+}
+
+func (ms *memoryRotationStore) load(group string) (string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.state[group], nil
+}
+
+func (ms *memoryRotationStore) save(group string, username string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.state[group] = username
+	return nil
+}
+
+// fileRotationStore: rotation state held in memory and written as json to disk on every change
+type fileRotationStore struct {
+	fs    fileSystem
+	path  string
+	mu    sync.RWMutex
+	state map[string]string
+}
+
+// newFileRotationStore: create store loading any existing state, a missing file is treated as an empty state
+func newFileRotationStore(fs fileSystem, path string) (*fileRotationStore, error) {
+	store := &fileRotationStore{
+		fs:    fs,
+		path:  path,
+		state: make(map[string]string),
+	}
+
+	f, err := fs.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.WithFields(log.Fields{"path": path}).Debug("no rotation state found, starting new rotation.")
+			return store, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &store.state); err != nil {
+			return nil, fmt.Errorf("'%s' invalid rotation state: %s", path, err)
+		}
+	}
+
+	return store, nil
+}
+
+func (fs *fileRotationStore) load(group string) (string, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	return fs.state[group], nil
+}
+
+func (fs *fileRotationStore) save(group string, username string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.state[group] = username
+
+	data, err := json.Marshal(fs.state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.fs, fs.path, data)
+}
+
+// writeFileAtomic: write to a temporary file and rename so a crash never leaves a partially written file
+func writeFileAtomic(fs fileSystem, path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := fs.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return fs.Rename(tmp, path)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests
+
+func TestMemoryRotationStore(t *testing.T) {
+	store := newMemoryRotationStore()
+
+	got, err := store.load("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "", got)
+
+	err = store.save("test", "test1")
+	assert.NoError(t, err)
+
+	got, err = store.load("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "test1", got)
+}
+
+func TestFileRotationStore(t *testing.T) {
+	dir := t.TempDir()
+
+	type test struct {
+		contents string
+		group    string
+		want     string
+		err      string
+	}
+
+	tests := []test{
+		// missing file starts a new rotation
+		{contents: "", group: "test", want: "", err: ""},
+		{contents: `{"test":"test2"}`, group: "test", want: "test2", err: ""},
+		{contents: `{"test":"test2"}`, group: "other", want: "", err: ""},
+		{contents: `{"test":`, group: "test", want: "", err: "invalid rotation state: unexpected end of JSON input"},
+	}
+
+	for i, tc := range tests {
+		path := filepath.Join(dir, "rotation.json")
+		if tc.contents != "" {
+			err := ioutil.WriteFile(path, []byte(tc.contents), 0600)
+			assert.NoError(t, err)
+		} else {
+			path = filepath.Join(dir, "missing.json")
+		}
+
+		store, err := newFileRotationStore(&osFS{}, path)
+		if err != nil {
+			assert.Contains(t, err.Error(), tc.err, "test %d", i)
+			continue
+		}
+		assert.NoError(t, err)
+
+		got, err := store.load(tc.group)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.want, got)
+	}
+
+	// saved state is written to disk
+	path := filepath.Join(dir, "saved.json")
+	store, err := newFileRotationStore(&osFS{}, path)
+	assert.NoError(t, err)
+	assert.NoError(t, store.save("test", "test1"))
+
+	reloaded, err := newFileRotationStore(&osFS{}, path)
+	assert.NoError(t, err)
+	got, err := reloaded.load("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "test1", got)
+
+	// saved state written through the file system
+	store, err = newFileRotationStore(&MockFS{}, "saved-rotation.json")
+	assert.NoError(t, err)
+	assert.NoError(t, store.save("test", "test1"))
+	data, err := afero.ReadFile(mockFS, "saved-rotation.json")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"test":"test1"}`, string(data))
+	_, err = os.Stat("saved-rotation.json")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -1,24 +1,51 @@
 package main
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 )
 
 type MRResponse struct {
+	job    job
 	status string
 	err    error
 }
 
 type Scheduler struct {
-	requests     chan MergeRequest
+	queue        requestQueue
+	retry        Retry
+	deadLetters  *deadLetterList
+	coalescer    *coalescer
+	dispatch     chan job
 	responses    chan MRResponse
 	status       chan WorkerStatus
 	workingCount uint
 	workers      []*Worker
+
+	// shutdown: context sent to the message pump to stop dispatching, undrained jobs are returned once stopped
+	shutdown  chan context.Context
+	undrained chan []job
+
+	// retrying: jobs waiting for their backoff to pass before returning to the queue
+	mu       sync.Mutex
+	stopped  bool
+	retrying map[uint64]job
 }
 
-func NewScheduler() (*Scheduler, error) {
-	scheduler := &Scheduler{}
+func NewScheduler(queue requestQueue, deadLetters *deadLetterList, retry Retry, coalesceWindow time.Duration) (*Scheduler, error) {
+	scheduler := &Scheduler{
+		queue:       queue,
+		retry:       retry.withDefaults(),
+		deadLetters: deadLetters,
+		coalescer:   newCoalescer(coalesceWindow),
+		dispatch:    make(chan job),
+		shutdown:    make(chan context.Context, 1),
+		undrained:   make(chan []job, 1),
+		retrying:    make(map[uint64]job),
+	}
 	return scheduler, nil
 }
 
@@ -26,25 +53,105 @@ func (s *Scheduler) AddWorker(w *Worker) {
 	s.workers = append(s.workers, w)
 }
 
-func (s *Scheduler) Run(gitClient GitlabWrapper, slack SlackWrapper, config Config, cache *localCache) {
-	s.requests = make(chan MergeRequest, 10000)
-	defer close(s.requests)
-
+// Run: start the workers and message pump, returns once shutdown. The responses and status channels are not closed as
+// a worker still processing when the shutdown deadline passes may send to them.
+func (s *Scheduler) Run(gitClient GitlabWrapper, slack SlackWrapper, config Config, cache UserCache) {
 	s.responses = make(chan MRResponse, 100)
-	defer close(s.responses)
-
 	s.status = make(chan WorkerStatus, 100)
-	defer close(s.status)
 
+	var wg sync.WaitGroup
 	for i, worker := range s.workers {
 		log.Debugf("schedule worker: starting : %d.", i)
-		go worker.Run(s.requests, s.responses, s.status, gitClient, slack, config, cache)
+		wg.Add(1)
+		go func(worker *Worker) {
+			defer wg.Done()
+			worker.Run(s.dispatch, s.responses, s.status, gitClient, slack, config, cache)
+		}(worker)
+	}
+
+	workersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(workersDone)
+	}()
+
+	s.messagePump(workersDone)
+}
+
+// Shutdown: stop dispatching jobs to workers and wait for the workers to finish their current job or the context
+// deadline to pass. Returns the jobs which were not processed.
+func (s *Scheduler) Shutdown(ctx context.Context) []job {
+	s.shutdown <- ctx
+	return <-s.undrained
+}
+
+// messagePump: jobs from the queue are coalesced by merge request and only sent to a worker once ready, so only one
+// job per merge request is in flight at a time
+func (s *Scheduler) messagePump(workersDone <-chan struct{}) {
+	jobs := s.queue.jobs()
+	var stopping bool
+	var deadline <-chan struct{}
+	var finished <-chan struct{}
+
+	for {
+		// A nil channel is never selected so nothing is dispatched until a job is ready
+		var dispatch chan job
+		next, ok := s.coalescer.next()
+		if ok && !stopping {
+			dispatch = s.dispatch
+		}
+
+		select {
+		case ctx := <-s.shutdown:
+			log.Info("schedule worker: stopping, waiting for workers to finish.")
+			stopping = true
+			jobs = nil
+			deadline = ctx.Done()
+			finished = workersDone
+			close(s.dispatch)
+		case <-finished:
+			log.Info("schedule worker: workers finished.")
+			s.flush()
+			s.undrained <- s.drain()
+			return
+		case <-deadline:
+			log.Warn("schedule worker: shutdown deadline passed before workers finished.")
+			s.flush()
+			s.undrained <- s.drain()
+			return
+		case j := <-jobs:
+			s.coalesce(j)
+		case key := <-s.coalescer.expired:
+			s.coalescer.expire(key)
+		case dispatch <- next:
+			s.coalescer.dispatched()
+		case response := <-s.responses:
+			log.Debugf("schedule worker: mr processed: %s.", response.status)
+			s.handleResponse(response)
+		case status := <-s.status:
+			s.adjustStatus(status)
+			log.Debugf("schedule worker: working: %d. request queue size %d.", s.workingCount, s.size())
+		}
 	}
+}
 
-	s.messagePump()
+// coalesce: hold a job from the queue until ready, acknowledging any earlier job for the merge request it replaces
+func (s *Scheduler) coalesce(j job) {
+	superseded, ok := s.coalescer.add(j)
+	if !ok {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{"group": j.mr.Group(), "project_id": j.mr.ProjectID(), "merge_request_id": j.mr.MergeReqID()})
+	logger.WithFields(log.Fields{"job_id": j.id, "superseded_job_id": superseded.id}).Debug("schedule worker: coalesced merge request event.")
+	if err := s.queue.ack(superseded); err != nil {
+		logger.WithFields(log.Fields{"error": err}).Error("schedule worker: failed to acknowledge coalesced merge request.")
+	}
 }
 
-func (s *Scheduler) messagePump() {
+// flush: handle the responses and status updates buffered by workers before they finished, so jobs which were
+// processed are acknowledged rather than reported as undrained
+func (s *Scheduler) flush() {
 	for {
 		select {
 		case response := <-s.responses:
@@ -52,12 +159,123 @@ func (s *Scheduler) messagePump() {
 			s.handleResponse(response)
 		case status := <-s.status:
 			s.adjustStatus(status)
-			log.Debugf("schedule worker: working: %d. request queue size %d.", s.workingCount, len(s.requests))
+		default:
+			return
 		}
 	}
 }
 
+// drain: collect every job not yet processed from the queue, coalescer and those waiting to retry
+func (s *Scheduler) drain() []job {
+	s.mu.Lock()
+	s.stopped = true
+	var jobs []job
+	for _, j := range s.retrying {
+		jobs = append(jobs, j)
+	}
+	s.mu.Unlock()
+
+	jobs = append(jobs, s.coalescer.undrained()...)
+	for {
+		select {
+		case j := <-s.queue.jobs():
+			jobs = append(jobs, j)
+		default:
+			return jobs
+		}
+	}
+}
+
+// size: number of jobs waiting in the queue or held for coalescing
+func (s *Scheduler) size() int {
+	return s.queue.size() + s.coalescer.size()
+}
+
+// handleResponse: acknowledge the processed merge request so it is removed from the queue. Failures with a transient
+// error are returned to the queue after a backoff until reaching max attempts, any other failures are parked in the
+// dead letter list. Merge requests which could not be parked are left unacknowledged so a durable queue replays them.
 func (s *Scheduler) handleResponse(response MRResponse) {
+	j := response.job
+	logger := log.WithFields(log.Fields{"group": j.mr.Group(), "project_id": j.mr.ProjectID(), "merge_request_id": j.mr.MergeReqID()})
+
+	// Allow the next job for the merge request to be dispatched, a retry is coalesced again when returned to the queue
+	defer s.coalescer.done(j)
+
+	if response.err != nil {
+		class := classifyError(response.err)
+		switch class {
+		case errorTransient:
+			j.attempts++
+			if j.attempts < s.retry.MaxAttempts {
+				delay := s.retry.backoff(j.attempts)
+				promRetries.WithLabelValues(j.mr.Group()).Inc()
+				logger.WithFields(log.Fields{"attempts": j.attempts, "backoff": delay}).Warn("schedule worker: retrying merge request.")
+				s.mu.Lock()
+				s.retrying[j.id] = j
+				s.mu.Unlock()
+				time.AfterFunc(delay, func() {
+					if !s.retried(j) {
+						return
+					}
+					if err := s.queue.retry(j); err != nil {
+						logger.WithFields(log.Fields{"error": err}).Error("schedule worker: failed to retry merge request.")
+					}
+				})
+				return
+			}
+			logger.WithFields(log.Fields{"attempts": j.attempts}).Error("schedule worker: max attempts reached, parking merge request.")
+			if !s.park(j, response.err) {
+				return
+			}
+		case errorPermanent:
+			j.attempts++
+			logger.WithFields(log.Fields{"error_class": class}).Error("schedule worker: parking merge request.")
+			if !s.park(j, response.err) {
+				return
+			}
+		}
+	}
+
+	if err := s.queue.ack(j); err != nil {
+		logger.WithFields(log.Fields{"error": err}).Error("schedule worker: failed to acknowledge merge request.")
+	}
+}
+
+// park: add the job to the dead letter list, false when it could not be written so the job is left unacknowledged
+func (s *Scheduler) park(j job, err error) bool {
+	if err := s.deadLetters.add(j, err); err != nil {
+		promErrors.WithLabelValues("dead_letter_write").Inc()
+		log.WithFields(log.Fields{"group": j.mr.Group(), "project_id": j.mr.ProjectID(), "merge_request_id": j.mr.MergeReqID(), "error": err}).Error("schedule worker: failed to park merge request, leaving in queue.")
+		return false
+	}
+	return true
+}
+
+// retried: remove a job once its backoff has passed, false when shutdown so it is reported as undrained instead
+func (s *Scheduler) retried(j job) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return false
+	}
+	delete(s.retrying, j.id)
+	return true
+}
+
+// redrive: return a parked merge request to the queue as a new job
+func (s *Scheduler) redrive(id uint64) error {
+	mr, err := s.deadLetters.remove(id)
+	if err != nil {
+		return err
+	}
+	return s.queue.push(mr)
+}
+
+// discard: remove a parked merge request without processing
+func (s *Scheduler) discard(id uint64) error {
+	_, err := s.deadLetters.remove(id)
+	return err
 }
 
 func (s *Scheduler) adjustStatus(status WorkerStatus) {
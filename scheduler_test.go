@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+// blockingGitlab: blocks getting a merge request until released, returning an error so nothing further is called
+type blockingGitlab struct {
+	GitlabWrapper
+	started chan struct{}
+	release chan struct{}
+}
+
+func (o *blockingGitlab) GetMergeRequest(pid interface{}, mergeRequest int, opt *gitlab.GetMergeRequestsOptions, options ...gitlab.RequestOptionFunc) (*gitlab.MergeRequest, *gitlab.Response, error) {
+	o.started <- struct{}{}
+	<-o.release
+	return nil, nil, errors.New("not found")
+}
+
+// Tests
+
+func TestHandleResponse(t *testing.T) {
+	mr := MergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 1}
+	serverErr := &gitlab.ErrorResponse{Response: &http.Response{StatusCode: 500, Request: &http.Request{Method: "GET"}}}
+
+	type test struct {
+		err             error
+		attempts        int
+		wantRetry       bool
+		wantDeadLetters int
+	}
+
+	tests := []test{
+		{err: nil, attempts: 0, wantRetry: false, wantDeadLetters: 0},
+		{err: errNoSuggestedApprovers, attempts: 0, wantRetry: false, wantDeadLetters: 0},
+		{err: errNoApprovers, attempts: 0, wantRetry: true, wantDeadLetters: 0},
+		{err: serverErr, attempts: 1, wantRetry: true, wantDeadLetters: 0},
+		// max attempts reached
+		{err: errNoApprovers, attempts: 2, wantRetry: false, wantDeadLetters: 1},
+		{err: errors.New("no slack channel configured."), attempts: 0, wantRetry: false, wantDeadLetters: 1},
+	}
+
+	for _, tc := range tests {
+		queue := newMemoryQueue(10)
+		scheduler, err := NewScheduler(queue, newDeadLetterList(), Retry{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}, 0)
+		assert.NoError(t, err)
+
+		scheduler.handleResponse(MRResponse{job: job{id: 1, mr: mr, attempts: tc.attempts}, err: tc.err})
+
+		if tc.wantRetry {
+			select {
+			case j := <-queue.jobs():
+				assert.Equal(t, tc.attempts+1, j.attempts)
+			case <-time.After(time.Second):
+				assert.Fail(t, "merge request not returned to queue.")
+			}
+		} else {
+			time.Sleep(5 * time.Millisecond)
+			assert.Equal(t, 0, queue.size())
+		}
+		assert.Len(t, scheduler.deadLetters.getList(), tc.wantDeadLetters)
+	}
+}
+
+func TestRedrive(t *testing.T) {
+	mr := MergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 1, mergeReqTitle: "test"}
+
+	queue := newMemoryQueue(10)
+	scheduler, err := NewScheduler(queue, newDeadLetterList(), Retry{}, 0)
+	assert.NoError(t, err)
+
+	scheduler.handleResponse(MRResponse{job: job{id: 1, mr: mr}, err: errors.New("no slack channel configured.")})
+	scheduler.handleResponse(MRResponse{job: job{id: 2, mr: mr}, err: errors.New("no slack channel configured.")})
+
+	letters := scheduler.deadLetters.getList()
+	assert.Len(t, letters, 2)
+	assert.Equal(t, 1, letters[0].Attempts)
+	assert.Equal(t, "no slack channel configured.", letters[0].Error)
+	assert.Equal(t, "test", letters[0].MergeReqTitle)
+
+	assert.NoError(t, scheduler.redrive(1))
+	j := <-queue.jobs()
+	assert.Equal(t, job{id: 1, mr: mr, attempts: 0}, j)
+
+	assert.NoError(t, scheduler.discard(2))
+	assert.Equal(t, 0, queue.size())
+	assert.Len(t, scheduler.deadLetters.getList(), 0)
+
+	assert.Equal(t, errDeadLetterNotFound, scheduler.redrive(3))
+	assert.Equal(t, errDeadLetterNotFound, scheduler.discard(3))
+}
+
+func TestFileDeadLetters(t *testing.T) {
+	mr := MergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 1, mergeReqTitle: "test"}
+	path := filepath.Join(t.TempDir(), "dead_letters.json")
+
+	deadLetters, err := newFileDeadLetterList(path)
+	assert.NoError(t, err)
+	queue := newMemoryQueue(10)
+	scheduler, err := NewScheduler(queue, deadLetters, Retry{}, 0)
+	assert.NoError(t, err)
+	scheduler.handleResponse(MRResponse{job: job{id: 1, mr: mr}, err: errors.New("no slack channel configured.")})
+
+	// parked merge requests kept after a restart
+	deadLetters, err = newFileDeadLetterList(path)
+	assert.NoError(t, err)
+	letters := deadLetters.getList()
+	assert.Len(t, letters, 1)
+	assert.Equal(t, "no slack channel configured.", letters[0].Error)
+	assert.Equal(t, "test", letters[0].MergeReqTitle)
+
+	scheduler, err = NewScheduler(queue, deadLetters, Retry{}, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, scheduler.redrive(letters[0].ID))
+	assert.Equal(t, mr, (<-queue.jobs()).mr)
+
+	deadLetters, err = newFileDeadLetterList(path)
+	assert.NoError(t, err)
+	assert.Len(t, deadLetters.getList(), 0)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"1":`), 0600))
+	_, err = newFileDeadLetterList(path)
+	assert.Error(t, err)
+}
+
+func TestSchedulerShutdown(t *testing.T) {
+	mr1 := MergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 1}
+	mr2 := MergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 2}
+	mr3 := MergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 3}
+
+	type test struct {
+		name          string
+		finish        bool
+		wantUndrained []int
+	}
+
+	tests := []test{
+		// in flight merge request finishes within the deadline
+		{name: "drained", finish: true, wantUndrained: []int{2, 3}},
+		{name: "deadline", finish: false, wantUndrained: []int{1, 2, 3}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			git := &blockingGitlab{started: make(chan struct{}, 1), release: make(chan struct{})}
+			defer close(git.release)
+
+			queue := newMemoryQueue(10)
+			scheduler, err := NewScheduler(queue, newDeadLetterList(), Retry{InitialBackoff: time.Hour}, time.Millisecond)
+			assert.NoError(t, err)
+			scheduler.AddWorker(NewWorker(newMRLocks(), newUnmatchedUsers(), newGitlabEmails(), newNotificationDispatcher()))
+
+			// waiting for backoff to pass before retrying
+			scheduler.handleResponse(MRResponse{job: job{id: 10, mr: mr3}, err: errNoApprovers})
+
+			go scheduler.Run(git, &MockSlack{}, Config{}, newLocalCache())
+
+			assert.NoError(t, queue.push(mr1))
+			<-git.started
+			assert.NoError(t, queue.push(mr2))
+			assert.Eventually(t, func() bool { return queue.size() == 0 }, time.Second, time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if tc.finish {
+				git.release <- struct{}{}
+			}
+			undrained := scheduler.Shutdown(ctx)
+
+			var got []int
+			for _, j := range undrained {
+				got = append(got, j.mr.MergeReqID())
+			}
+			assert.ElementsMatch(t, tc.wantUndrained, got)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -15,7 +16,7 @@ func getUsersInConversation(sw SlackWrapper, channelID string) ([]string, error)
 	users, _, err := sw.GetUsersInConversation(&options)
 	if err != nil {
 		promSlackAPIErrs.WithLabelValues("get_users_in_coversation", err.Error()).Inc()
-		return nil, fmt.Errorf("slack: failed to get users in channel: %s\n", err)
+		return nil, fmt.Errorf("slack: failed to get users in channel: %w\n", err)
 	}
 	return users, nil
 }
@@ -26,7 +27,7 @@ func getUsersInfo(sw SlackWrapper, users ...string) (*[]slack.User, []string, er
 	userInfo, err := sw.GetUsersInfo(users...)
 	if err != nil {
 		promSlackAPIErrs.WithLabelValues("get_users_info", err.Error()).Inc()
-		return nil, nil, fmt.Errorf("slack: failed to get user details: %s\n", err)
+		return nil, nil, fmt.Errorf("slack: failed to get user details: %w\n", err)
 	}
 
 	// Returned user info is less than requested, determine missing.
@@ -44,30 +45,175 @@ func getUsersInfo(sw SlackWrapper, users ...string) (*[]slack.User, []string, er
 	return userInfo, missingIDs, nil
 }
 
-// Post message to slack channel via chat api
-func sendSlackMsg(sw SlackWrapper, channel string, reviewers []*gitlab.BasicUser, mr MergeRequests) error {
-	promSlackMsgs.WithLabelValues(mr.Group(), channel).Inc()
+func getUserPresence(sw SlackWrapper, user string) (*slack.UserPresence, error) {
+	promSlackAPIReqs.WithLabelValues("get_user_presence").Inc()
+	presence, err := sw.GetUserPresence(user)
+	if err != nil {
+		promSlackAPIErrs.WithLabelValues("get_user_presence", err.Error()).Inc()
+		return nil, fmt.Errorf("slack: failed to get user presence: %w\n", err)
+	}
+	return presence, nil
+}
 
-	var usernames []string
-	for _, reviewer := range reviewers {
-		usernames = append(usernames, reviewer.Username)
+func getDNDInfo(sw SlackWrapper, user string) (*slack.DNDStatus, error) {
+	promSlackAPIReqs.WithLabelValues("get_dnd_info").Inc()
+	dnd, err := sw.GetDNDInfo(&user)
+	if err != nil {
+		promSlackAPIErrs.WithLabelValues("get_dnd_info", err.Error()).Inc()
+		return nil, fmt.Errorf("slack: failed to get user dnd info: %w\n", err)
 	}
-	fmtUsernames := fmt.Sprintf("<@%s>", strings.Join(usernames, ">, <@"))
+	return dnd, nil
+}
 
-	attachment := slack.Attachment{
-		Color:  "#1f81d1",
-		Text:   fmt.Sprintf("%s you have been selected to review <%s|%s> in <%s|%s>", fmtUsernames, mr.MergeReqURL(), mr.MergeReqTitle(), mr.ProjectWebURL(), mr.ProjectName()),
-		Footer: "Selections based on CODEOWNERS file",
+func postEphemeral(sw SlackWrapper, channelID string, userID string, text string) error {
+	promSlackAPIReqs.WithLabelValues("post_ephemeral").Inc()
+	_, err := sw.PostEphemeral(channelID, userID, slack.MsgOptionText(text, false))
+	if err != nil {
+		promSlackAPIErrs.WithLabelValues("post_ephemeral", err.Error()).Inc()
+		return fmt.Errorf("slack: failed to post ephemeral message: %w\n", err)
 	}
+	return nil
+}
+
+// Post message to slack channel via chat api, with buttons for acting on the review when interactive. Returns the
+// channel id and timestamp identifying the message.
+func sendSlackMsg(sw SlackWrapper, channel string, text string, footer string, mr MergeRequests, interactive bool) (string, string, error) {
+	promSlackMsgs.WithLabelValues(mr.Group(), channel).Inc()
 
-	_, _, err := sw.PostMessage(
+	channelID, timestamp, err := sw.PostMessage(
 		channel,
-		slack.MsgOptionAttachments(attachment),
+		slack.MsgOptionText(text, false), // notification fallback for clients which do not show blocks
+		slack.MsgOptionBlocks(reviewBlocks(text, footer, mr, interactive)...),
 		slack.MsgOptionAsUser(true), // Add this if you want that the bot would post message as a user, otherwise it will send response using the default slackbot
 	)
 	if err != nil {
 		promSlackMsgsErrors.WithLabelValues("msg_failed", mr.Group(), channel).Inc()
-		return errors.New("failed to send slack message!")
+		return "", "", fmt.Errorf("failed to send slack message: %w", err)
+	}
+	return channelID, timestamp, nil
+}
+
+// Post a direct message to a slack user, opening the conversation with the user first
+func sendSlackDirectMsg(sw SlackWrapper, userID string, a assignment) error {
+	promSlackAPIReqs.WithLabelValues("open_conversation").Inc()
+	channel, _, _, err := sw.OpenConversation(&slack.OpenConversationParameters{Users: []string{userID}, ReturnIM: true})
+	if err != nil {
+		promSlackAPIErrs.WithLabelValues("open_conversation", err.Error()).Inc()
+		promSlackMsgsErrors.WithLabelValues("direct_msg_failed", a.mr.Group(), "").Inc()
+		return fmt.Errorf("slack: failed to open conversation: %w\n", err)
+	}
+
+	promSlackDirectMsgs.WithLabelValues(a.mr.Group()).Inc()
+	text := directText(a)
+	_, _, err = sw.PostMessage(
+		channel.ID,
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionBlocks(slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)),
+		slack.MsgOptionAsUser(true),
+	)
+	if err != nil {
+		promSlackMsgsErrors.WithLabelValues("direct_msg_failed", a.mr.Group(), channel.ID).Inc()
+		return fmt.Errorf("slack: failed to send direct message: %w\n", err)
 	}
 	return nil
 }
+
+// Replace the blocks of a message sent to a slack channel
+func updateSlackMsg(sw SlackWrapper, channelID string, timestamp string, text string, blocks []slack.Block, mr MergeRequests) error {
+	promSlackAPIReqs.WithLabelValues("update_message").Inc()
+	_, _, _, err := sw.UpdateMessage(channelID, timestamp, slack.MsgOptionText(text, false), slack.MsgOptionBlocks(blocks...))
+	if err != nil {
+		promSlackAPIErrs.WithLabelValues("update_message", err.Error()).Inc()
+		promSlackMsgsErrors.WithLabelValues("update_failed", mr.Group(), channelID).Inc()
+		return fmt.Errorf("slack: failed to update message: %w\n", err)
+	}
+	return nil
+}
+
+// slackMention: mention the user by the slack user id held in the cache as slack only resolves mentions of user ids,
+// otherwise name the user in plain text
+func slackMention(cache UserCache, user *gitlab.BasicUser) string {
+	if slackUserID, ok := cachedSlackUserID(cache, user.Username); ok {
+		return fmt.Sprintf("<@%s>", slackUserID)
+	}
+	if user.Name != "" {
+		return user.Name
+	}
+	return user.Username
+}
+
+// slackEscaper: escapes the control characters of slack mrkdwn so text set by merge request authors can not break a
+// link or ping a channel through <!channel> or <!here>
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeSlack: text shown literally in a slack message
+func escapeSlack(text string) string {
+	return slackEscaper.Replace(text)
+}
+
+// directText: the merge request with its size and pipeline status, sent to each reviewer directly
+func directText(a assignment) string {
+	text := fmt.Sprintf("You have been selected to review <%s|%s> in <%s|%s>", a.mr.MergeReqURL(), escapeSlack(a.mr.MergeReqTitle()), a.mr.ProjectWebURL(), escapeSlack(a.mr.ProjectName()))
+	var details []string
+	if a.changes != "" {
+		details = append(details, fmt.Sprintf("*Changes:* %s", a.changes))
+	}
+	if a.pipelineStatus != "" {
+		details = append(details, fmt.Sprintf("*Pipeline:* %s", a.pipelineStatus))
+	}
+	if len(details) > 0 {
+		text = fmt.Sprintf("%s\n%s", text, strings.Join(details, "  "))
+	}
+	return text
+}
+
+// slackMentionFunc: mention users through the cache, for rendering message templates
+func slackMentionFunc(cache UserCache) func(*gitlab.BasicUser) string {
+	return func(user *gitlab.BasicUser) string {
+		return slackMention(cache, user)
+	}
+}
+
+// cachedSlackUserID: slack user id mapped to the gitlab user in the cache, expired entries are used as the slack user id
+// does not change when the status expires
+func cachedSlackUserID(cache UserCache, username string) (string, bool) {
+	cachedUser, err := cache.read(username)
+	if err != nil && !errors.Is(err, errUserExpired) {
+		return "", false
+	}
+	return cachedUser.slackUserID, cachedUser.slackUserID != ""
+}
+
+// reviewBlocks: block kit layout of the review message, buttons carry the merge request so the interaction can be
+// handled without looking up the message. The footer is left out when empty as slack rejects empty context blocks.
+func reviewBlocks(text string, footer string, mr MergeRequests, interactive bool) []slack.Block {
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+	}
+	if strings.TrimSpace(footer) != "" {
+		blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, footer, false, false)))
+	}
+	if !interactive {
+		return blocks
+	}
+	if actions, ok := reviewActions(mr); ok {
+		blocks = append(blocks, actions)
+	}
+	return blocks
+}
+
+// reviewActions: the buttons shown on the review message, false when the merge request can not be encoded
+func reviewActions(mr MergeRequests) (slack.Block, bool) {
+	value, err := json.Marshal(mr)
+	if err != nil {
+		return nil, false
+	}
+	button := func(actionID string, text string) *slack.ButtonBlockElement {
+		return slack.NewButtonBlockElement(actionID, string(value), slack.NewTextBlockObject(slack.PlainTextType, text, false, false))
+	}
+	return slack.NewActionBlock(reviewActionsBlockID,
+		button(actionReassign, "Reassign"),
+		button(actionTake, "I'll take it").WithStyle(slack.StylePrimary),
+		button(actionOutToday, "Out today"),
+	), true
+}
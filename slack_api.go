@@ -7,8 +7,13 @@ import (
 
 type SlackWrapper interface {
 	PostMessage(channelID string, options ...slack.MsgOption) (string, string, error)
+	PostEphemeral(channelID string, userID string, options ...slack.MsgOption) (string, error)
+	UpdateMessage(channelID string, timestamp string, options ...slack.MsgOption) (string, string, string, error)
+	OpenConversation(params *slack.OpenConversationParameters) (*slack.Channel, bool, bool, error)
 	GetUsersInfo(users ...string) (*[]slack.User, error)
 	GetUsersInConversation(params *slack.GetUsersInConversationParameters) ([]string, string, error)
+	GetUserPresence(user string) (*slack.UserPresence, error)
+	GetDNDInfo(user *string) (*slack.DNDStatus, error)
 }
 
 type Slack struct {
@@ -19,6 +24,18 @@ func (s *Slack) PostMessage(channelID string, options ...slack.MsgOption) (strin
 	return s.client.PostMessage(channelID, options...)
 }
 
+func (s *Slack) PostEphemeral(channelID string, userID string, options ...slack.MsgOption) (string, error) {
+	return s.client.PostEphemeral(channelID, userID, options...)
+}
+
+func (s *Slack) UpdateMessage(channelID string, timestamp string, options ...slack.MsgOption) (string, string, string, error) {
+	return s.client.UpdateMessage(channelID, timestamp, options...)
+}
+
+func (s *Slack) OpenConversation(params *slack.OpenConversationParameters) (*slack.Channel, bool, bool, error) {
+	return s.client.OpenConversation(params)
+}
+
 func (s *Slack) GetUsersInConversation(params *slack.GetUsersInConversationParameters) ([]string, string, error) {
 	return s.client.GetUsersInConversation(params)
 }
@@ -27,6 +44,14 @@ func (s *Slack) GetUsersInfo(users ...string) (*[]slack.User, error) {
 	return s.client.GetUsersInfo(users...)
 }
 
+func (s *Slack) GetUserPresence(user string) (*slack.UserPresence, error) {
+	return s.client.GetUserPresence(user)
+}
+
+func (s *Slack) GetDNDInfo(user *string) (*slack.DNDStatus, error) {
+	return s.client.GetDNDInfo(user)
+}
+
 func newSlackClient(token string) *Slack {
 	api := slack.New(token)
 	return &Slack{client: api}
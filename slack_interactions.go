@@ -0,0 +1,330 @@
+// Slack interactivity for the buttons on review messages. Requests are verified with the slack signing secret and
+// acknowledged straight away as slack expects a response within 3 seconds, the action is then carried out holding the
+// merge request lock so it does not race a worker processing the same merge request.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"github.com/xanzy/go-gitlab"
+)
+
+const (
+	reviewActionsBlockID = "review_actions"
+
+	actionReassign = "reassign"
+	actionTake     = "take"
+	actionOutToday = "out_today"
+
+	// statusOutToday: slack status set in the cache by the out today button, unavailable unless a rule says otherwise
+	statusOutToday = "out today"
+
+	maxInteractionBodySize = 1 << 20
+)
+
+var (
+	errNotMapped          = errors.New("your slack user is not mapped to a gitlab user, ask for a mapping through the /cache admin page.")
+	errNotApprover        = errors.New("you are not a suggested approver for this merge request.")
+	errNoReviewerAssigned = errors.New("no reviewer is assigned to this merge request.")
+	errNotReviewing       = errors.New("you are not reviewing this merge request.")
+	errNoOtherApprovers   = errors.New("no other approvers are available for this merge request.")
+)
+
+type slackInteractionHandler struct {
+	signingSecret string
+	gitClient     GitlabWrapper
+	slack         SlackWrapper
+	cache         UserCache
+	config        Config
+	locks         *mrLocks
+}
+
+// reviewAction: button clicked on a review message and who clicked it
+type reviewAction struct {
+	actionID    string
+	mr          MergeRequest
+	slackUserID string
+	channelID   string
+	messageTs   string
+}
+
+func (h slackInteractionHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	action, status, err := h.parse(request)
+	if err != nil {
+		promSlackInteractions.WithLabelValues("", "rejected").Inc()
+		log.WithFields(log.Fields{"error": err}).Error("could not parse the slack interaction.")
+		writer.WriteHeader(status)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+	if action == nil {
+		return
+	}
+
+	go h.handle(*action)
+}
+
+// parse: verify the request signature and return the review action, nil when the interaction is not a review button
+func (h slackInteractionHandler) parse(request *http.Request) (*reviewAction, int, error) {
+	if request.Method != http.MethodPost {
+		return nil, http.StatusMethodNotAllowed, errors.New("invalid http method")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(request.Body, maxInteractionBodySize))
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("error reading request body")
+	}
+
+	verifier, err := slack.NewSecretsVerifier(request.Header, h.signingSecret)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+	if _, err := verifier.Write(body); err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+	if err := verifier.Ensure(); err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	var callback slack.InteractionCallback
+	if err := json.Unmarshal([]byte(form.Get("payload")), &callback); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid interaction payload: %w", err)
+	}
+
+	if callback.Type != slack.InteractionTypeBlockActions || len(callback.ActionCallback.BlockActions) == 0 {
+		return nil, http.StatusOK, nil
+	}
+	blockAction := callback.ActionCallback.BlockActions[0]
+	if blockAction.BlockID != reviewActionsBlockID {
+		return nil, http.StatusOK, nil
+	}
+
+	action := reviewAction{
+		actionID:    blockAction.ActionID,
+		slackUserID: callback.User.ID,
+		channelID:   callback.Container.ChannelID,
+		messageTs:   callback.Container.MessageTs,
+	}
+	if err := json.Unmarshal([]byte(blockAction.Value), &action.mr); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid merge request in action value: %w", err)
+	}
+	return &action, http.StatusOK, nil
+}
+
+// handle: carry out the action, replying in the message thread when reviewers change otherwise only to the user who
+// clicked the button
+func (h slackInteractionHandler) handle(action reviewAction) {
+	mr := action.mr
+	logger := log.WithFields(log.Fields{"group": mr.Group(), "project_id": mr.ProjectID(), "merge_request_id": mr.MergeReqID(), "action": action.actionID, "slack_user_id": action.slackUserID})
+
+	reply, err := h.act(action, time.Now())
+	if err != nil {
+		promSlackInteractions.WithLabelValues(action.actionID, "failed").Inc()
+		logger.WithFields(log.Fields{"error": err}).Warn("failed to handle slack interaction.")
+		if err := postEphemeral(h.slack, action.channelID, action.slackUserID, err.Error()); err != nil {
+			logger.WithFields(log.Fields{"error": err}).Error("failed to reply to slack interaction.")
+		}
+		return
+	}
+	promSlackInteractions.WithLabelValues(action.actionID, "success").Inc()
+	logger.WithFields(log.Fields{"reply": reply}).Debug("handled slack interaction.")
+
+	_, _, err = h.slack.PostMessage(action.channelID, slack.MsgOptionText(reply, false), slack.MsgOptionTS(action.messageTs))
+	if err != nil {
+		promSlackMsgsErrors.WithLabelValues("msg_failed", mr.Group(), action.channelID).Inc()
+		logger.WithFields(log.Fields{"error": err}).Error("failed to reply to slack interaction.")
+	}
+}
+
+// act: carry out the action for the gitlab user mapped to the slack user, returning the reply for the message thread
+func (h slackInteractionHandler) act(action reviewAction, now time.Time) (string, error) {
+	username, ok := cachedUsername(h.cache, action.slackUserID)
+	if !ok {
+		return "", errNotMapped
+	}
+	clicker := fmt.Sprintf("<@%s>", action.slackUserID)
+
+	unlock, _ := h.locks.lock(mergeRequestKey(action.mr))
+	defer unlock()
+
+	switch action.actionID {
+	case actionReassign:
+		from, to, err := h.reassign(action.mr, username, false)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s reassigned the review from %s to %s.", clicker, from, slackMention(h.cache, to)), nil
+	case actionTake:
+		from, err := h.take(action.mr, username)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s took the review from %s.", clicker, from), nil
+	case actionOutToday:
+		markOutToday(h.cache, username, now)
+		from, to, err := h.reassign(action.mr, username, true)
+		switch {
+		case errors.Is(err, errNotReviewing):
+			return fmt.Sprintf("%s is out today.", clicker), nil
+		case err != nil:
+			return "", fmt.Errorf("marked out today but could not reassign the review: %w", err)
+		}
+		return fmt.Sprintf("%s is out today, reassigned the review from %s to %s.", clicker, from, slackMention(h.cache, to)), nil
+	}
+	return "", fmt.Errorf("'%s' unknown action.", action.actionID)
+}
+
+// take: swap the user in as reviewer, replacing the first reviewer assigned. Returns the username replaced.
+func (h slackInteractionHandler) take(mr MergeRequest, username string) (string, error) {
+	err, mrResult := mr.getMR(h.gitClient)
+	if err != nil {
+		return "", err
+	}
+	if reviewerIndex(mrResult.Reviewers, username) >= 0 {
+		return "", errors.New("you are already reviewing this merge request.")
+	}
+
+	approvers, err := h.eligibleApprovers(mr, mrResult)
+	if err != nil {
+		return "", err
+	}
+	index := reviewerIndex(approvers, username)
+	if index < 0 {
+		return "", errNotApprover
+	}
+
+	reviewers := append([]*gitlab.BasicUser{}, mrResult.Reviewers...)
+	from := "nobody"
+	if len(reviewers) == 0 {
+		reviewers = append(reviewers, approvers[index])
+	} else {
+		from = reviewers[0].Username
+		reviewers[0] = approvers[index]
+	}
+	if err := mr.setMRReviwer(h.gitClient, reviewers); err != nil {
+		return "", err
+	}
+	return from, nil
+}
+
+// reassign: replace the user as reviewer with another available approver, or the first reviewer when the user is not
+// reviewing unless only replacing the user. Returns the username replaced and the reviewer selected.
+func (h slackInteractionHandler) reassign(mr MergeRequest, username string, onlyUser bool) (string, *gitlab.BasicUser, error) {
+	err, mrResult := mr.getMR(h.gitClient)
+	if err != nil {
+		return "", nil, err
+	}
+	index := reviewerIndex(mrResult.Reviewers, username)
+	switch {
+	case index < 0 && onlyUser:
+		return "", nil, errNotReviewing
+	case len(mrResult.Reviewers) == 0:
+		return "", nil, errNoReviewerAssigned
+	case index < 0:
+		index = 0
+	}
+
+	eligible, err := h.eligibleApprovers(mr, mrResult)
+	if err != nil {
+		return "", nil, err
+	}
+	approvers, _ := checkCache(h.gitClient, h.slack, h.cache, eligible, mr, h.config)
+
+	var candidates []*gitlab.BasicUser
+	for _, approver := range approvers {
+		if reviewerIndex(mrResult.Reviewers, approver.Username) < 0 {
+			candidates = append(candidates, approver)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", nil, errNoOtherApprovers
+	}
+
+	groupKey, groupChannel, err := getGroupChannel(mr.PathWithNamespace(), h.config.GroupChannels)
+	if err != nil {
+		return "", nil, err
+	}
+	selected := selectReviewers(h.config.reviewerSelector(groupKey), h.gitClient, h.slack, h.cache, h.config.presences, mr, groupChannel.Selection, eligible, candidates, 1)
+	if len(selected) == 0 {
+		return "", nil, errNoOtherApprovers
+	}
+
+	reviewers := append([]*gitlab.BasicUser{}, mrResult.Reviewers...)
+	from := reviewers[index].Username
+	reviewers[index] = selected[0]
+	if err := mr.setMRReviwer(h.gitClient, reviewers); err != nil {
+		return "", nil, err
+	}
+	return from, selected[0], nil
+}
+
+// eligibleApprovers: suggested approvers for the merge request excluding authors as when selecting reviewers
+func (h slackInteractionHandler) eligibleApprovers(mr MergeRequest, mrResult *gitlab.MergeRequest) ([]*gitlab.BasicUser, error) {
+	approvers, _, err := mr.getMRApprovers(h.gitClient)
+	if err != nil {
+		return nil, err
+	}
+
+	_, groupChannel, err := getGroupChannel(mr.PathWithNamespace(), h.config.GroupChannels)
+	if err != nil {
+		return nil, err
+	}
+	var commits []*gitlab.Commit
+	if groupChannel.Selection.ExcludeCommitters {
+		commits, err = mr.getMRCommits(h.gitClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+	approvers, _ = excludeAuthors(approvers, mrResult.Author, commits, groupChannel.Selection, mr)
+	return approvers, nil
+}
+
+// markOutToday: set the user unavailable in the cache until the end of the day in their own timezone, the slack status
+// is read again once the entry expires
+func markOutToday(cache UserCache, username string, now time.Time) {
+	cachedUser, err := cache.read(username)
+	if errors.Is(err, errUserNotInCache) {
+		cachedUser = userMeta{username: username}
+	}
+	if loc, ok := cachedUser.location(); ok {
+		now = now.In(loc)
+	}
+	endOfDay := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+
+	cachedUser.status = statusOutToday
+	cache.update(cachedUser, endOfDay.Unix())
+	log.WithFields(log.Fields{"username": username, "until": endOfDay}).Info("user marked out today.")
+}
+
+// cachedUsername: return the gitlab username mapped to a slack user in the cache
+func cachedUsername(cache UserCache, slackUserID string) (string, bool) {
+	for _, u := range cache.getUserList() {
+		if u.SlackUserID == slackUserID {
+			return u.Username, true
+		}
+	}
+	return "", false
+}
+
+// reviewerIndex: position of the user in the list, -1 when not found
+func reviewerIndex(users []*gitlab.BasicUser, username string) int {
+	for i, u := range users {
+		if u.Username == username {
+			return i
+		}
+	}
+	return -1
+}
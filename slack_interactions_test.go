@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+// Setup
+
+// mockReviewGitlab: merge request with the reviewers and suggested approvers set, recording reviewers updated
+type mockReviewGitlab struct {
+	GitlabWrapper
+	author    *gitlab.BasicUser
+	reviewers []*gitlab.BasicUser
+	approvers []*gitlab.BasicUser
+	updated   []int
+}
+
+func (o *mockReviewGitlab) GetMergeRequest(pid interface{}, mergeRequest int, opt *gitlab.GetMergeRequestsOptions, options ...gitlab.RequestOptionFunc) (*gitlab.MergeRequest, *gitlab.Response, error) {
+	return &gitlab.MergeRequest{Author: o.author, Reviewers: o.reviewers}, nil, nil
+}
+
+func (o *mockReviewGitlab) GetConfiguration(pid interface{}, mr int, options ...gitlab.RequestOptionFunc) (*gitlab.MergeRequestApprovals, *gitlab.Response, error) {
+	return &gitlab.MergeRequestApprovals{SuggestedApprovers: o.approvers, ApprovalsRequired: 1}, nil, nil
+}
+
+func (o *mockReviewGitlab) UpdateMergeRequest(pid interface{}, mergeRequest int, opt *gitlab.UpdateMergeRequestOptions, options ...gitlab.RequestOptionFunc) (*gitlab.MergeRequest, *gitlab.Response, error) {
+	o.updated = *opt.ReviewerIDs
+	return nil, nil, nil
+}
+
+// signedInteraction: interaction request signed as slack would with the signing secret
+func signedInteraction(secret string, callback slack.InteractionCallback, timestamp time.Time) *http.Request {
+	payload, _ := json.Marshal(&callback)
+	body := url.Values{"payload": {string(payload)}}.Encode()
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("v0:%s:%s", ts, body)))
+
+	request := httptest.NewRequest(http.MethodPost, "/slack/interactions", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("X-Slack-Request-Timestamp", ts)
+	request.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return request
+}
+
+func buttonCallback(blockID string, actionID string, value string) slack.InteractionCallback {
+	callback := slack.InteractionCallback{
+		Type:      slack.InteractionTypeBlockActions,
+		User:      slack.User{ID: "U2"},
+		Container: slack.Container{ChannelID: "C1", MessageTs: "1660000000.000100"},
+	}
+	callback.ActionCallback.BlockActions = []*slack.BlockAction{{BlockID: blockID, ActionID: actionID, Value: value}}
+	return callback
+}
+
+// Tests
+
+func TestSlackInteractionParse(t *testing.T) {
+	mr := MergeRequest{pathWithNamespace: "test/project", group: "test", projectID: 1, mergeReqID: 1}
+	value, _ := json.Marshal(mr)
+	handler := slackInteractionHandler{signingSecret: "secret"}
+
+	type test struct {
+		name       string
+		request    *http.Request
+		wantStatus int
+		wantAction *reviewAction
+	}
+
+	tests := []test{
+		{
+			name:       "review button",
+			request:    signedInteraction("secret", buttonCallback(reviewActionsBlockID, actionTake, string(value)), time.Now()),
+			wantStatus: http.StatusOK,
+			wantAction: &reviewAction{actionID: actionTake, mr: mr, slackUserID: "U2", channelID: "C1", messageTs: "1660000000.000100"},
+		},
+		{
+			name:       "other block ignored",
+			request:    signedInteraction("secret", buttonCallback("other", actionTake, string(value)), time.Now()),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid merge request",
+			request:    signedInteraction("secret", buttonCallback(reviewActionsBlockID, actionTake, "{"), time.Now()),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "wrong secret",
+			request:    signedInteraction("other", buttonCallback(reviewActionsBlockID, actionTake, string(value)), time.Now()),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "stale timestamp",
+			request:    signedInteraction("secret", buttonCallback(reviewActionsBlockID, actionTake, string(value)), time.Now().Add(-time.Hour)),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid method",
+			request:    httptest.NewRequest(http.MethodGet, "/slack/interactions", nil),
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range tests {
+		action, status, err := handler.parse(tc.request)
+		assert.Equal(t, tc.wantStatus, status, tc.name)
+		assert.Equal(t, tc.wantAction, action, tc.name)
+		if tc.wantStatus != http.StatusOK {
+			assert.Error(t, err, tc.name)
+		}
+	}
+}
+
+func TestSlackInteractionAct(t *testing.T) {
+	mr := MergeRequest{pathWithNamespace: "test/project", group: "test", projectID: 1, mergeReqID: 1}
+	config := Config{
+		GroupChannels: map[string]GroupChannel{"test": {SlackChannel: "#test", SlackChannelID: "C1"}},
+	}
+
+	author := &gitlab.BasicUser{ID: 1, Username: "test1"}
+	reviewer := &gitlab.BasicUser{ID: 2, Username: "test2"}
+	approver := &gitlab.BasicUser{ID: 3, Username: "test3"}
+	unmapped := &gitlab.BasicUser{ID: 4, Username: "test4"}
+
+	type test struct {
+		name        string
+		actionID    string
+		slackUserID string
+		reviewers   []*gitlab.BasicUser
+		want        string
+		wantUpdated []int
+		wantErr     error
+	}
+
+	tests := []test{
+		{name: "take", actionID: actionTake, slackUserID: "U3", reviewers: []*gitlab.BasicUser{reviewer}, want: "<@U3> took the review from test2.", wantUpdated: []int{3}},
+		{name: "take without reviewer", actionID: actionTake, slackUserID: "U3", want: "<@U3> took the review from nobody.", wantUpdated: []int{3}},
+		{name: "take as author", actionID: actionTake, slackUserID: "U1", reviewers: []*gitlab.BasicUser{reviewer}, wantErr: errNotApprover},
+		{name: "reassign", actionID: actionReassign, slackUserID: "U2", reviewers: []*gitlab.BasicUser{reviewer}, want: "<@U2> reassigned the review from test2 to <@U3>.", wantUpdated: []int{3}},
+		{name: "reassign other reviewer", actionID: actionReassign, slackUserID: "U3", reviewers: []*gitlab.BasicUser{reviewer}, want: "<@U3> reassigned the review from test2 to <@U3>.", wantUpdated: []int{3}},
+		{name: "reassign without reviewer", actionID: actionReassign, slackUserID: "U2", wantErr: errNoReviewerAssigned},
+		{name: "reassign without other approvers", actionID: actionReassign, slackUserID: "U2", reviewers: []*gitlab.BasicUser{reviewer, approver}, wantErr: errNoOtherApprovers},
+		{name: "out today reviewing", actionID: actionOutToday, slackUserID: "U2", reviewers: []*gitlab.BasicUser{reviewer}, want: "<@U2> is out today, reassigned the review from test2 to <@U3>.", wantUpdated: []int{3}},
+		{name: "out today not reviewing", actionID: actionOutToday, slackUserID: "U3", reviewers: []*gitlab.BasicUser{reviewer}, want: "<@U3> is out today."},
+		{name: "unmapped slack user", actionID: actionTake, slackUserID: "U4", wantErr: errNotMapped},
+	}
+
+	for _, tc := range tests {
+		cache := newLocalCache()
+		expire := time.Now().Add(time.Hour).Unix()
+		cache.update(userMeta{username: "test1", slackUserID: "U1"}, expire)
+		cache.update(userMeta{username: "test2", slackUserID: "U2"}, expire)
+		cache.update(userMeta{username: "test3", slackUserID: "U3"}, expire)
+
+		gitClient := &mockReviewGitlab{author: author, reviewers: tc.reviewers, approvers: []*gitlab.BasicUser{author, reviewer, approver, unmapped}}
+		handler := slackInteractionHandler{gitClient: gitClient, slack: &MockSlack{}, cache: cache, config: config, locks: newMRLocks()}
+
+		got, err := handler.act(reviewAction{actionID: tc.actionID, mr: mr, slackUserID: tc.slackUserID}, time.Now())
+		if tc.wantErr != nil {
+			assert.ErrorIs(t, err, tc.wantErr, tc.name)
+			assert.Nil(t, gitClient.updated, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.want, got, tc.name)
+		assert.Equal(t, tc.wantUpdated, gitClient.updated, tc.name)
+	}
+}
+
+func TestMarkOutToday(t *testing.T) {
+	now := time.Date(2022, time.August, 10, 22, 30, 0, 0, time.UTC)
+	cache := newLocalCache()
+	cache.update(userMeta{username: "test1", slackUserID: "U1", timezone: "Asia/Tokyo", tzOffset: 32400}, 0)
+
+	markOutToday(cache, "test1", now)
+	markOutToday(cache, "test2", now)
+
+	users := cache.getUserList()
+	assert.Equal(t, statusOutToday, users[0].SlackStatus)
+	assert.Equal(t, "U1", users[0].SlackUserID, "slack user id kept")
+	// already the 11th in tokyo
+	assert.Equal(t, time.Date(2022, time.August, 12, 0, 0, 0, 0, time.FixedZone("JST", 32400)).Unix(), users[0].CacheExpire.Unix())
+	assert.Equal(t, time.Date(2022, time.August, 11, 0, 0, 0, 0, time.UTC).Unix(), users[1].CacheExpire.Unix())
+
+	reason, unavailable := statusUnavailable(nil, statusOutToday, "")
+	assert.True(t, unavailable)
+	assert.Equal(t, statusOutToday, reason)
+}
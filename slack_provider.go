@@ -0,0 +1,72 @@
+// Availability from the slack status held in the user cache. Expired entries are refreshed in the background, unless
+// background refresh is disabled in which case the slack status is requested when the entry is read.
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xanzy/go-gitlab"
+)
+
+// slackProvider: created per merge request as it requires the slack client and user cache
+type slackProvider struct {
+	slack  SlackWrapper
+	cache  UserCache
+	config Config
+	mr     MergeRequests
+}
+
+func newSlackProvider(slack SlackWrapper, cache UserCache, config Config, mr MergeRequests) *slackProvider {
+	return &slackProvider{slack: slack, cache: cache, config: config, mr: mr}
+}
+
+func (sp *slackProvider) Name() string {
+	return providerSlack
+}
+
+// Availability: users in the cache with a status matching a user status rule are known, unavailable when the rule is
+// marked unavailable. Users without a matching status, or missing from the cache for groups without a slack channel,
+// are unknown so later providers can decide under first_known.
+func (sp *slackProvider) Availability(user *gitlab.BasicUser, now time.Time) (availability, error) {
+	logger := log.WithFields(log.Fields{"group": sp.mr.Group(), "project_id": sp.mr.ProjectID(), "merge_request_id": sp.mr.MergeReqID(), "username": user.Username})
+
+	cachedUser, err := sp.cache.read(user.Username)
+	switch err {
+	case nil:
+	case errUserNotInCache:
+		// Groups notified through another chat have no slack channel to match missing users against
+		if _, groupChannel, err := getGroupChannel(sp.mr.PathWithNamespace(), sp.config.GroupChannels); err == nil && groupChannel.SlackChannelID == "" {
+			return availability{}, nil
+		}
+		// Missing users are added before availability is checked, as they are matched against the channel members
+		promErrors.WithLabelValues("user_not_found_in_cache").Inc()
+		logger.WithFields(log.Fields{"error": err}).Error("user not found in cache.")
+		return availability{}, err
+	case errUserExpired:
+		// Refreshed in the background, use the last known status rather than slowing processing
+		if !sp.config.CacheRefresh.Disabled {
+			logger.Debug("using expired status until refreshed in background.")
+			break
+		}
+		slackUsersData, _, err := getUsersInfo(sp.slack, cachedUser.slackUserID)
+		if err != nil {
+			logger.WithFields(log.Fields{"error": err}).Error("failed to get slack user data.")
+			return availability{}, err
+		}
+		for _, s := range *slackUsersData {
+			cachedUser = updateCachedUser(sp.cache, cachedUser.username, s, sp.config)
+		}
+	}
+
+	ttl := time.Hour * time.Duration(getStatusTTL(sp.config.UserStatuses, cachedUser.status, cachedUser.statusEmoji))
+	if reason, unavailable := statusUnavailable(sp.config.UserStatuses, cachedUser.status, cachedUser.statusEmoji); unavailable {
+		promSlackStatusUnavailable.WithLabelValues(reason, sp.mr.Group()).Inc()
+		logger.WithFields(log.Fields{"reason": reason, "status": cachedUser.status}).Debug("user unavailable due to slack status.")
+		return availability{known: true, available: false, reason: reason, ttl: ttl}, nil
+	}
+	if !statusKnown(sp.config.UserStatuses, cachedUser.status, cachedUser.statusEmoji) {
+		return availability{}, nil
+	}
+	return availability{known: true, available: true, ttl: ttl}, nil
+}
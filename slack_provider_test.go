@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+// Tests
+
+func TestSlackProvider(t *testing.T) {
+	mr := MergeRequest{group: "test", projectID: 1, mergeReqID: 1}
+	available := true
+	config := Config{
+		UserStatuses: map[string]UserStatus{
+			"":          {TTL: 1},
+			"out sick":  {TTL: 8},
+			"in office": {TTL: 2, Available: &available},
+		},
+	}
+
+	type test struct {
+		username string
+		disabled bool
+		seed     string
+		want     availability
+		wantErr  bool
+	}
+
+	tests := []test{
+		// a status without a matching rule is left to later providers
+		{username: "test1", want: availability{}},
+		{username: "test6", want: availability{known: true, available: true, ttl: time.Hour * 2}},
+		{username: "test2", want: availability{known: true, available: false, reason: "out sick", ttl: time.Hour * 8}},
+		{username: "test3", wantErr: true},
+		// expired status used until refreshed in the background
+		{username: "test4", want: availability{known: true, available: false, reason: "out sick", ttl: time.Hour * 8}},
+		// expired status refreshed from slack when background refresh is disabled
+		{username: "test4", disabled: true, seed: "A", want: availability{}},
+		{username: "test5", disabled: true, seed: "E", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		cache := newLocalCache()
+		expire := time.Now().Add(time.Hour).Unix()
+		cache.update(userMeta{username: "test1", slackUserID: "1"}, expire)
+		cache.update(userMeta{username: "test2", slackUserID: "2", status: "out sick"}, expire)
+		cache.update(userMeta{username: "test4", slackUserID: "1", status: "out sick"}, 0)
+		cache.update(userMeta{username: "test5", slackUserID: "1"}, 0)
+		cache.update(userMeta{username: "test6", slackUserID: "6", status: "in office"}, expire)
+
+		config.CacheRefresh.Disabled = tc.disabled
+		provider := newSlackProvider(&MockSlack{wh_url: tc.seed}, cache, config, mr)
+		got, err := provider.Availability(&gitlab.BasicUser{Username: tc.username}, time.Now())
+		if tc.wantErr {
+			assert.Error(t, err, tc.username)
+			continue
+		}
+		assert.NoError(t, err, tc.username)
+		assert.Equal(t, tc.want, got, tc.username)
+	}
+}
+
+func TestSlackProviderWithoutSlackChannel(t *testing.T) {
+	mr := MergeRequest{group: "test", pathWithNamespace: "test/project", projectID: 1, mergeReqID: 1}
+	config := Config{
+		GroupChannels: map[string]GroupChannel{
+			"test": {Notifier: NotifierConfig{Type: notifierMattermost, WebhookURL: "http://mattermost.local/hooks/1"}},
+		},
+	}
+
+	provider := newSlackProvider(&MockSlack{}, newLocalCache(), config, mr)
+	got, err := provider.Availability(&gitlab.BasicUser{Username: "test1"}, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, availability{}, got, "users missing from the cache are unknown")
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 
 	"github.com/slack-go/slack"
 	"github.com/xanzy/go-gitlab"
 
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -21,6 +23,9 @@ type mockSlack struct {
 }
 
 func (s *mockSlack) PostMessage(channelID string, options ...slack.MsgOption) (string, string, error) {
+	if s.wh_url == "rate_limited" {
+		return "", "", &slack.RateLimitedError{RetryAfter: time.Second}
+	}
 	if len(channelID) > 0 {
 		return "", "", errors.New("failed to send slack message!")
 	}
@@ -32,12 +37,16 @@ func (s *mockSlack) PostMessage(channelID string, options ...slack.MsgOption) (s
 func TestSendSlackMsg(t *testing.T) {
 
 	type test struct {
-		url string
+		url       string
+		wantErr   string
+		wantClass errorClass
 	}
 
 	tests := []test{
-		{url: "pass"},
-		{url: "fail"},
+		{url: "pass", wantErr: "failed to send slack message: failed to send slack message!", wantClass: errorPermanent},
+		{url: "fail", wantErr: "failed to send slack message: failed to send slack message!", wantClass: errorPermanent},
+		// rate limiting retried rather than parked
+		{url: "rate_limited", wantErr: "failed to send slack message: slack rate limit exceeded, retry after 1s", wantClass: errorTransient},
 	}
 
 	reviewer1 := &gitlab.BasicUser{
@@ -58,15 +67,103 @@ func TestSendSlackMsg(t *testing.T) {
 		workInProgress:    false,
 	}
 
+	text, footer, err := defaultTemplates.render(newTemplateData(assignment{mr: mr, reviewers: reviewers}, slackMentionFunc(newLocalCache())))
+	assert.NoError(t, err)
+
 	for _, tc := range tests {
 		ms := &mockSlack{wh_url: tc.url}
 
-		err := sendSlackMsg(ms, "test", reviewers, mr)
+		_, _, err := sendSlackMsg(ms, "test", text, footer, mr, tc.url == "pass")
 
 		if err != nil {
-			assert.Equal(t, err.Error(), "failed to send slack message!")
+			assert.Equal(t, tc.wantErr, err.Error(), tc.url)
+			assert.Equal(t, tc.wantClass, classifyError(err), tc.url)
 		} else {
 			assert.NoError(t, err)
 		}
 	}
 }
+
+func TestSlackMention(t *testing.T) {
+	mr := MergeRequest{
+		projectName:   "test",
+		projectWebURL: "https://gitlab.local/test/test",
+		mergeReqURL:   "https://gitlab.local/test/test/-/merge_requests/1",
+		mergeReqTitle: "test",
+	}
+
+	cache := newLocalCache()
+	cache.update(userMeta{username: "test.user1", slackUserID: "U1"}, time.Now().Add(time.Hour).Unix())
+	// slack user id still valid once the status expires
+	cache.update(userMeta{username: "test.user2", slackUserID: "U2"}, time.Now().Add(-time.Hour).Unix())
+	// matched without a slack user id
+	cache.update(userMeta{username: "test.user3"}, time.Now().Add(time.Hour).Unix())
+
+	resolved := &gitlab.BasicUser{ID: 1, Name: "Test User 1", Username: "test.user1"}
+	expired := &gitlab.BasicUser{ID: 2, Name: "Test User 2", Username: "test.user2"}
+	noSlackID := &gitlab.BasicUser{ID: 3, Name: "Test User 3", Username: "test.user3"}
+	notCached := &gitlab.BasicUser{ID: 4, Name: "Test User 4", Username: "test.user4"}
+	noName := &gitlab.BasicUser{ID: 5, Username: "test.user5"}
+
+	type test struct {
+		name      string
+		reviewers []*gitlab.BasicUser
+		want      string
+	}
+
+	tests := []test{
+		{name: "resolved", reviewers: []*gitlab.BasicUser{resolved, expired}, want: "<@U1>, <@U2>"},
+		{name: "unresolved", reviewers: []*gitlab.BasicUser{noSlackID, notCached, noName}, want: "Test User 3, Test User 4, test.user5"},
+		{name: "mixed", reviewers: []*gitlab.BasicUser{notCached, resolved}, want: "Test User 4, <@U1>"},
+	}
+
+	for _, tc := range tests {
+		got, _, err := defaultTemplates.render(newTemplateData(assignment{mr: mr, reviewers: tc.reviewers}, slackMentionFunc(cache)))
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.want+" you have been selected to review <https://gitlab.local/test/test/-/merge_requests/1|test> in <https://gitlab.local/test/test|test>", got, tc.name)
+	}
+}
+
+func TestReviewBlocks(t *testing.T) {
+	mr := MergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 1}
+
+	blocks := reviewBlocks("text", "footer", mr, false)
+	assert.Len(t, blocks, 2)
+
+	// footer rendering empty is left out
+	blocks = reviewBlocks("text", " \n", mr, false)
+	assert.Len(t, blocks, 1)
+
+	blocks = reviewBlocks("text", "footer", mr, true)
+	assert.Len(t, blocks, 3)
+	actions, ok := blocks[2].(*slack.ActionBlock)
+	assert.True(t, ok)
+	assert.Equal(t, reviewActionsBlockID, actions.BlockID)
+
+	var actionIDs []string
+	for _, element := range actions.Elements.ElementSet {
+		button := element.(*slack.ButtonBlockElement)
+		actionIDs = append(actionIDs, button.ActionID)
+
+		var got MergeRequest
+		assert.NoError(t, json.Unmarshal([]byte(button.Value), &got))
+		assert.Equal(t, mr, got)
+	}
+	assert.Equal(t, []string{actionReassign, actionTake, actionOutToday}, actionIDs)
+}
+
+func TestDirectText(t *testing.T) {
+	a := notifierAssignment
+	assert.Equal(t, "You have been selected to review <https://gitlab.local/test/project/-/merge_requests/1|Add feature> in <https://gitlab.local/test/project|project>", directText(a))
+
+	a.changes = "35"
+	a.pipelineStatus = "success"
+	assert.Equal(t, "You have been selected to review <https://gitlab.local/test/project/-/merge_requests/1|Add feature> in <https://gitlab.local/test/project|project>\n*Changes:* 35  *Pipeline:* success", directText(a))
+
+	// titles and project names can not break the link or ping the channel
+	mr := a.mr.(MergeRequest)
+	mr.mergeReqTitle = "Fix <!channel> a > b & c"
+	mr.projectName = "<project>"
+	a = assignment{mr: mr}
+	assert.Equal(t, "You have been selected to review <https://gitlab.local/test/project/-/merge_requests/1|Fix &lt;!channel&gt; a &gt; b &amp; c> in <https://gitlab.local/test/project|&lt;project&gt;>", directText(a))
+}
@@ -0,0 +1,78 @@
+// Availability set per user in the configuration file, such as a secondment or long term leave not reflected in their
+// slack status. An entry may also mark a user available, overriding other providers under the first_known policy.
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+const staticDateLayout = "2006-01-02"
+
+// StaticAvailability - Availability of a user, applying up to and including the until date when set
+type StaticAvailability struct {
+	Available bool   `yaml:"available"`
+	Reason    string `yaml:"reason"`
+	Until     string `yaml:"until"`
+}
+
+func (sa StaticAvailability) validate() error {
+	if sa.Until == "" {
+		return nil
+	}
+	if _, err := time.ParseInLocation(staticDateLayout, sa.Until, time.Local); err != nil {
+		return fmt.Errorf("'%s' until must be a date such as 2022-07-01.", sa.Until)
+	}
+	return nil
+}
+
+// staticEntry: availability with the until date parsed as the end of the day, zero when not set
+type staticEntry struct {
+	available bool
+	reason    string
+	until     time.Time
+}
+
+// staticProvider: users without an entry, or past the until date, are not known
+type staticProvider struct {
+	entries map[string]staticEntry
+}
+
+func newStaticProvider(static map[string]StaticAvailability) (*staticProvider, error) {
+	entries := make(map[string]staticEntry)
+	for username, sa := range static {
+		if err := sa.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %s", username, err)
+		}
+
+		entry := staticEntry{available: sa.Available, reason: sa.Reason}
+		if sa.Until != "" {
+			until, _ := time.ParseInLocation(staticDateLayout, sa.Until, time.Local)
+			entry.until = until.AddDate(0, 0, 1)
+		}
+		if entry.reason == "" && !entry.available {
+			entry.reason = "unavailable"
+		}
+		entries[username] = entry
+	}
+	return &staticProvider{entries: entries}, nil
+}
+
+func (sp *staticProvider) Name() string {
+	return providerStatic
+}
+
+func (sp *staticProvider) Availability(user *gitlab.BasicUser, now time.Time) (availability, error) {
+	entry, ok := sp.entries[user.Username]
+	if !ok || (!entry.until.IsZero() && !now.Before(entry.until)) {
+		return availability{}, nil
+	}
+
+	var ttl time.Duration
+	if !entry.until.IsZero() {
+		ttl = entry.until.Sub(now)
+	}
+	return availability{known: true, available: entry.available, reason: entry.reason, ttl: ttl}, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xanzy/go-gitlab"
+)
+
+// Tests
+
+func TestStaticProvider(t *testing.T) {
+	provider, err := newStaticProvider(map[string]StaticAvailability{
+		"test1": {Reason: "secondment"},
+		"test2": {Reason: "parental leave", Until: "2022-07-01"},
+		"test3": {Available: true},
+		"test4": {},
+	})
+	assert.NoError(t, err)
+
+	day := func(month time.Month, d int, hour int) time.Time {
+		return time.Date(2022, month, d, hour, 0, 0, 0, time.Local)
+	}
+
+	type test struct {
+		username string
+		now      time.Time
+		want     availability
+	}
+
+	tests := []test{
+		{username: "test1", now: day(time.June, 15, 9), want: availability{known: true, available: false, reason: "secondment"}},
+		// until date inclusive
+		{username: "test2", now: day(time.June, 15, 9), want: availability{known: true, available: false, reason: "parental leave", ttl: day(time.July, 2, 0).Sub(day(time.June, 15, 9))}},
+		{username: "test2", now: day(time.July, 1, 23), want: availability{known: true, available: false, reason: "parental leave", ttl: time.Hour}},
+		{username: "test2", now: day(time.July, 2, 0), want: availability{}},
+		{username: "test3", now: day(time.June, 15, 9), want: availability{known: true, available: true}},
+		{username: "test4", now: day(time.June, 15, 9), want: availability{known: true, available: false, reason: "unavailable"}},
+		{username: "test5", now: day(time.June, 15, 9), want: availability{}},
+	}
+
+	for _, tc := range tests {
+		got, err := provider.Availability(&gitlab.BasicUser{Username: tc.username}, tc.now)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.want, got, tc.username)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// teamsNotifier: posts a message card to a microsoft teams incoming webhook, incoming webhooks can not mention users
+// so reviewers are named
+type teamsNotifier struct {
+	url    string
+	client *http.Client
+}
+
+type teamsMessageCard struct {
+	Type       string `json:"@type"`
+	Context    string `json:"@context"`
+	Summary    string `json:"summary"`
+	ThemeColor string `json:"themeColor"`
+	Text       string `json:"text"`
+}
+
+func (tn *teamsNotifier) Name() string {
+	return notifierTeams
+}
+
+func (tn *teamsNotifier) Notify(a assignment) error {
+	text := reviewMessage(a, func(reviewer *gitlab.BasicUser) string {
+		if reviewer.Name != "" {
+			return fmt.Sprintf("**%s**", reviewer.Name)
+		}
+		return fmt.Sprintf("**%s**", reviewer.Username)
+	})
+	card := teamsMessageCard{
+		Type:       "MessageCard",
+		Context:    "https://schema.org/extensions",
+		Summary:    fmt.Sprintf("Review requested: %s", escapeMentions(a.mr.MergeReqTitle())),
+		ThemeColor: "1f81d1",
+		Text:       text,
+	}
+	if err := postWebhook(tn.client, tn.url, card); err != nil {
+		return fmt.Errorf("teams: failed to send message: %w", err)
+	}
+	return nil
+}
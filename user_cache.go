@@ -1,18 +1,50 @@
-// A cache store (in memory) for storing user slack status against unique user id
+// A cache store for storing user slack status against unique user id, held in memory or persisted to disk
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
 	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// UserCache - store of slack user ids and statuses for gitlab users
+type UserCache interface {
+	read(username string) (userMeta, error)
+	update(u userMeta, expireTimestamp int64)
+	delete(username string) error
+	clear(username string) error
+	getMissingIDs(userIDs ...string) []string
+	getUserList() []userList
+	startRefresher(interval time.Duration, refresh func())
+	close()
+}
+
+// newUserCache: create the user cache backend set in the configuration, defaults to memory
+func newUserCache(config Config) (UserCache, error) {
+	switch config.UserCache.Backend {
+	case storeFile:
+		return newFileUserCache(config.storePath(config.UserCache, "user_cache.json"))
+	default:
+		return newLocalCache(), nil
+	}
+}
+
 type userMeta struct {
 	username    string
 	slackUserID string
 	status      string
+	statusEmoji string
+	timezone    string
+	tzOffset    int
+	// busy: gitlab user status availability set to busy, only held in the gitlab status cache
+	busy bool
 }
 
 type cachedUser struct {
@@ -39,12 +71,32 @@ func newLocalCache() *localCache {
 		stop:  make(chan struct{}),
 	}
 
+	return lc
+}
+
+// startRefresher: call refresh on every interval in the background until the cache is closed
+func (lc *localCache) startRefresher(interval time.Duration, refresh func()) {
 	lc.wg.Add(1)
 	go func() {
 		defer lc.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-lc.stop:
+				return
+			case <-ticker.C:
+				refresh()
+			}
+		}
 	}()
+}
 
-	return lc
+// close: stop any background routines and wait for them to finish
+func (lc *localCache) close() {
+	close(lc.stop)
+	lc.wg.Wait()
 }
 
 // update: adds new cache entry otherwise udpates existing
@@ -107,6 +159,9 @@ func (lc *localCache) delete(username string) error {
 
 // getMissingIDs: return a list of usernames if they do not have a slackUserID set in the cache
 func (lc *localCache) getMissingIDs(userIDs ...string) []string {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
 	var missingIDs []string
 
 	// No users in cache so return all
@@ -130,8 +185,8 @@ func (lc *localCache) getMissingIDs(userIDs ...string) []string {
 
 // clear: Clear a users status and expired time from the cache based on username
 func (lc *localCache) clear(username string) error {
-	lc.mu.RLock()
-	defer lc.mu.RUnlock()
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
 
 	cu, ok := lc.users[username]
 	if !ok {
@@ -140,7 +195,7 @@ func (lc *localCache) clear(username string) error {
 	}
 
 	lc.users[username] = cachedUser{
-		user: userMeta{username: cu.user.username, slackUserID: cu.user.slackUserID},
+		user: userMeta{username: cu.user.username, slackUserID: cu.user.slackUserID, timezone: cu.user.timezone, tzOffset: cu.user.tzOffset},
 	}
 
 	promCacheClear.Inc()
@@ -149,14 +204,19 @@ func (lc *localCache) clear(username string) error {
 }
 
 type userList struct {
-	Username    string
-	SlackUserID string
-	SlackStatus string
-	CacheExpire time.Time
-	Expired     bool
+	Username         string
+	SlackUserID      string
+	SlackStatus      string
+	SlackStatusEmoji string
+	Timezone         string
+	CacheExpire      time.Time
+	Expired          bool
 }
 
 func (lc *localCache) getUserList() []userList {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
 	ul := []userList{}
 	t := time.Now()
 
@@ -166,7 +226,153 @@ func (lc *localCache) getUserList() []userList {
 		if t.After(ct) {
 			expiredTS = true
 		}
-		ul = append(ul, userList{Username: k, SlackUserID: v.user.slackUserID, SlackStatus: v.user.status, CacheExpire: time.Unix(v.expireTimestamp, 0), Expired: expiredTS})
+		ul = append(ul, userList{Username: k, SlackUserID: v.user.slackUserID, SlackStatus: v.user.status, SlackStatusEmoji: v.user.statusEmoji, Timezone: v.user.timezone, CacheExpire: time.Unix(v.expireTimestamp, 0), Expired: expiredTS})
 	}
+	sort.Slice(ul, func(i, j int) bool {
+		return ul[i].Username < ul[j].Username
+	})
 	return ul
 }
+
+// userCacheSaveDelay: changes within the delay are written together, so a refresh updating every user writes once
+const userCacheSaveDelay = time.Second
+
+// fileUserCache: users held in memory and written as json to disk shortly after changing so manual overrides made
+// through the admin ui and slack user ids are kept after a restart. Pending changes are written when closed.
+type fileUserCache struct {
+	*localCache
+	path      string
+	saveDelay time.Duration
+	saveMu    sync.Mutex
+
+	pendingMu sync.Mutex
+	dirty     bool
+	saveTimer *time.Timer
+}
+
+type cachedUserRecord struct {
+	Username        string `json:"username"`
+	SlackUserID     string `json:"slack_user_id"`
+	Status          string `json:"status,omitempty"`
+	StatusEmoji     string `json:"status_emoji,omitempty"`
+	Timezone        string `json:"timezone,omitempty"`
+	TZOffset        int    `json:"tz_offset,omitempty"`
+	ExpireTimestamp int64  `json:"expire_timestamp,omitempty"`
+}
+
+// newFileUserCache: create cache loading any existing users, a missing file is treated as an empty cache
+func newFileUserCache(path string) (*fileUserCache, error) {
+	fc := &fileUserCache{
+		localCache: newLocalCache(),
+		path:       path,
+		saveDelay:  userCacheSaveDelay,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.WithFields(log.Fields{"path": path}).Debug("no user cache found, starting empty cache.")
+			return fc, nil
+		}
+		return nil, err
+	}
+
+	if len(data) > 0 {
+		var records []cachedUserRecord
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("'%s' invalid user cache: %s", path, err)
+		}
+		for _, r := range records {
+			fc.users[r.Username] = cachedUser{
+				user:            userMeta{username: r.Username, slackUserID: r.SlackUserID, status: r.Status, statusEmoji: r.StatusEmoji, timezone: r.Timezone, tzOffset: r.TZOffset},
+				expireTimestamp: r.ExpireTimestamp,
+			}
+		}
+		log.WithFields(log.Fields{"path": path, "num_users": len(records)}).Info("loaded user cache.")
+	}
+
+	return fc, nil
+}
+
+func (fc *fileUserCache) update(u userMeta, expireTimestamp int64) {
+	fc.localCache.update(u, expireTimestamp)
+	fc.scheduleSave()
+}
+
+func (fc *fileUserCache) delete(username string) error {
+	if err := fc.localCache.delete(username); err != nil {
+		return err
+	}
+	fc.scheduleSave()
+	return nil
+}
+
+func (fc *fileUserCache) clear(username string) error {
+	if err := fc.localCache.clear(username); err != nil {
+		return err
+	}
+	fc.scheduleSave()
+	return nil
+}
+
+// close: stop the refresher then write any pending changes
+func (fc *fileUserCache) close() {
+	fc.localCache.close()
+
+	fc.pendingMu.Lock()
+	if fc.saveTimer != nil {
+		fc.saveTimer.Stop()
+	}
+	fc.pendingMu.Unlock()
+	fc.save()
+}
+
+// scheduleSave: save once the delay has passed, changes made before then are included in the same write
+func (fc *fileUserCache) scheduleSave() {
+	fc.pendingMu.Lock()
+	defer fc.pendingMu.Unlock()
+
+	fc.dirty = true
+	if fc.saveTimer == nil {
+		fc.saveTimer = time.AfterFunc(fc.saveDelay, fc.save)
+	}
+}
+
+// save: write all users to disk when changed, a failure is logged as the cache is rebuilt from slack when missing
+func (fc *fileUserCache) save() {
+	fc.saveMu.Lock()
+	defer fc.saveMu.Unlock()
+
+	// changes made from here on are written by the next save
+	fc.pendingMu.Lock()
+	dirty := fc.dirty
+	fc.dirty, fc.saveTimer = false, nil
+	fc.pendingMu.Unlock()
+	if !dirty {
+		return
+	}
+
+	fc.mu.RLock()
+	records := make([]cachedUserRecord, 0, len(fc.users))
+	for _, cu := range fc.users {
+		records = append(records, cachedUserRecord{
+			Username:        cu.user.username,
+			SlackUserID:     cu.user.slackUserID,
+			Status:          cu.user.status,
+			StatusEmoji:     cu.user.statusEmoji,
+			Timezone:        cu.user.timezone,
+			TZOffset:        cu.user.tzOffset,
+			ExpireTimestamp: cu.expireTimestamp,
+		})
+	}
+	fc.mu.RUnlock()
+
+	data, err := json.Marshal(records)
+	if err == nil {
+		err = writeFileAtomic(osFS{}, fc.path, data)
+	}
+	if err != nil {
+		promErrors.WithLabelValues("user_cache_save").Inc()
+		log.WithFields(log.Fields{"path": fc.path, "error": err}).Error("failed to save user cache.")
+	}
+}
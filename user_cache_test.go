@@ -4,6 +4,9 @@ import (
 	// "fmt"
 	// "errors"
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -237,3 +240,59 @@ func TestGetUserList(t *testing.T) {
 		assert.Equal(t, tc.got, ul)
 	}
 }
+
+func TestFileUserCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user_cache.json")
+	timeExpire := time.Now().Add(time.Hour * 24 * 14)
+
+	cache, err := newFileUserCache(path)
+	assert.NoError(t, err)
+	assert.Len(t, cache.getUserList(), 0)
+	cache.saveDelay = time.Hour
+
+	cache.update(userMeta{username: "test1", slackUserID: "1", status: "holiday", timezone: "Asia/Singapore", tzOffset: 28800}, timeExpire.Unix())
+	cache.update(userMeta{username: "test2", slackUserID: "2", status: "out sick", timezone: "Europe/London", tzOffset: 3600}, timeExpire.Unix())
+	cache.update(userMeta{username: "test3", slackUserID: "3"}, timeExpire.Unix())
+	assert.NoError(t, cache.clear("test2"))
+	assert.NoError(t, cache.delete("test3"))
+	assert.Equal(t, errUserNotInCache, cache.delete("test3"))
+
+	// changes written together once the delay has passed, pending changes written on close
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	cache.close()
+
+	// users and manual overrides kept after a restart
+	cache, err = newFileUserCache(path)
+	assert.NoError(t, err)
+
+	got, err := cache.read("test1")
+	assert.NoError(t, err)
+	assert.Equal(t, userMeta{username: "test1", slackUserID: "1", status: "holiday", timezone: "Asia/Singapore", tzOffset: 28800}, got)
+
+	// cleared status, timezone kept
+	got, err = cache.read("test2")
+	assert.Equal(t, errUserExpired, err)
+	assert.Equal(t, userMeta{username: "test2", slackUserID: "2", timezone: "Europe/London", tzOffset: 3600}, got)
+
+	_, err = cache.read("test3")
+	assert.Equal(t, errUserNotInCache, err)
+
+	// written in the background once the delay has passed
+	cache.saveDelay = time.Millisecond
+	cache.update(userMeta{username: "test4", slackUserID: "4"}, timeExpire.Unix())
+	assert.Eventually(t, func() bool {
+		saved, err := newFileUserCache(path)
+		if err != nil {
+			return false
+		}
+		_, err = saved.read("test4")
+		return err == nil
+	}, time.Second, time.Millisecond*10)
+	cache.close()
+
+	// invalid file
+	assert.NoError(t, ioutil.WriteFile(path, []byte("{"), 0600))
+	_, err = newFileUserCache(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,160 @@
+// Rules for user statuses set in the configuration file, determining how long a status is cached and if a user with
+// the status is available for selection as a reviewer
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// UserStatus - Rule matching a user status by text or emoji. The key the rule is set against is matched as the text
+// unless text is set. The rule set against an empty key is the default used for statuses without a matching rule.
+//
+// Accepts the ttl alone for compatibility with configuration written before rules: "out sick": 8
+type UserStatus struct {
+	TTL       int    `yaml:"ttl"`
+	Text      string `yaml:"text"`
+	Match     string `yaml:"match"`
+	Emoji     string `yaml:"emoji"`
+	Available *bool  `yaml:"available"`
+
+	regex *regexp.Regexp
+}
+
+const (
+	statusMatchExact     = "exact"
+	statusMatchSubstring = "substring"
+	statusMatchRegex     = "regex"
+)
+
+// Statuses treated as unavailable when a rule does not set available, matching the behaviour before rules. Out today
+// is set through the slack message button.
+var legacyUnavailableStatuses = map[string]bool{
+	"out sick":     true,
+	"vacationing":  true,
+	"holiday":      true,
+	statusOutToday: true,
+}
+
+func (us *UserStatus) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var ttl int
+	if err := unmarshal(&ttl); err == nil {
+		*us = UserStatus{TTL: ttl}
+		return nil
+	}
+
+	type plain UserStatus
+	return unmarshal((*plain)(us))
+}
+
+// validate: check the match type and compile any regular expression, matching is case insensitive
+func (us *UserStatus) validate(name string) error {
+	if us.TTL < 0 {
+		return fmt.Errorf("'%d' ttl must not be negative.", us.TTL)
+	}
+
+	switch us.Match {
+	case "", statusMatchExact, statusMatchSubstring:
+	case statusMatchRegex:
+		regex, err := regexp.Compile("(?i)" + us.text(name))
+		if err != nil {
+			return fmt.Errorf("'%s' invalid regex: %s", us.text(name), err)
+		}
+		us.regex = regex
+	default:
+		return fmt.Errorf("'%s' unknown match type.", us.Match)
+	}
+	return nil
+}
+
+func (us UserStatus) text(name string) string {
+	if us.Text != "" {
+		return us.Text
+	}
+	return name
+}
+
+// available: if a user with a status matching the rule can be selected as a reviewer
+func (us UserStatus) available(name string) bool {
+	if us.Available != nil {
+		return *us.Available
+	}
+	return !legacyUnavailableStatuses[strings.ToLower(name)]
+}
+
+// matches: check the status text against the rule using its match type
+func (us UserStatus) matches(name string, status string) bool {
+	text := us.text(name)
+	switch us.Match {
+	case statusMatchSubstring:
+		return text != "" && strings.Contains(strings.ToLower(status), strings.ToLower(text))
+	case statusMatchRegex:
+		regex := us.regex
+		if regex == nil {
+			var err error
+			if regex, err = regexp.Compile("(?i)" + text); err != nil {
+				return false
+			}
+		}
+		return regex.MatchString(status)
+	default:
+		return strings.EqualFold(status, text) || strings.EqualFold(status, name)
+	}
+}
+
+// matchUserStatus: return the name and rule matching a status. Exact matches take priority, then emoji, then substring
+// and regex rules in name order. Returns the default rule and false when no rule matches.
+func matchUserStatus(userStatuses map[string]UserStatus, status string, emoji string) (string, UserStatus, bool) {
+	var names []string
+	for name := range userStatuses {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if rule := userStatuses[name]; (rule.Match == "" || rule.Match == statusMatchExact) && rule.matches(name, status) {
+			return name, rule, true
+		}
+	}
+
+	if emoji != "" {
+		for _, name := range names {
+			if rule := userStatuses[name]; rule.Emoji != "" && rule.Emoji == emoji {
+				return name, rule, true
+			}
+		}
+	}
+
+	for _, name := range names {
+		if rule := userStatuses[name]; (rule.Match == statusMatchSubstring || rule.Match == statusMatchRegex) && rule.matches(name, status) {
+			return name, rule, true
+		}
+	}
+
+	return "", userStatuses[""], false
+}
+
+// statusKnown: if a status says anything about availability, matching a user status rule or a status unavailable
+// before rules were configured. Users without a status, or a status no rule matches, are not known.
+func statusKnown(userStatuses map[string]UserStatus, status string, emoji string) bool {
+	_, _, ok := matchUserStatus(userStatuses, status, emoji)
+	return ok || legacyUnavailableStatuses[strings.ToLower(status)]
+}
+
+// statusUnavailable: return the name of the rule making a user with the status unavailable for selection
+func statusUnavailable(userStatuses map[string]UserStatus, status string, emoji string) (string, bool) {
+	name, rule, ok := matchUserStatus(userStatuses, status, emoji)
+	if ok && !rule.available(name) {
+		return name, true
+	}
+
+	// Statuses unavailable before rules remain so when no rule is configured
+	if !ok && legacyUnavailableStatuses[strings.ToLower(status)] {
+		return strings.ToLower(status), true
+	}
+	return "", false
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+// Tests
+
+func TestUserStatusUnmarshalYAML(t *testing.T) {
+	data := `---
+"": 1
+"out sick": 8
+"parental leave":
+  ttl: 24
+  match: "substring"
+  available: false
+"palm_tree":
+  emoji: ":palm_tree:"
+`
+	var got map[string]UserStatus
+	assert.NoError(t, yaml.Unmarshal([]byte(data), &got))
+
+	unavailable := false
+	assert.Equal(t, map[string]UserStatus{
+		"":               {TTL: 1},
+		"out sick":       {TTL: 8},
+		"parental leave": {TTL: 24, Match: "substring", Available: &unavailable},
+		"palm_tree":      {Emoji: ":palm_tree:"},
+	}, got)
+}
+
+func TestUserStatusValidate(t *testing.T) {
+	type test struct {
+		status UserStatus
+		err    error
+	}
+
+	tests := []test{
+		{status: UserStatus{TTL: 8}, err: nil},
+		{status: UserStatus{Match: "regex", Text: "^on[- ]call"}, err: nil},
+		{status: UserStatus{TTL: -1}, err: errors.New("'-1' ttl must not be negative.")},
+		{status: UserStatus{Match: "unknown"}, err: errors.New("'unknown' unknown match type.")},
+		{status: UserStatus{Match: "regex", Text: "on(call"}, err: errors.New("'on(call' invalid regex: error parsing regexp: missing closing ): `(?i)on(call`")},
+	}
+
+	for _, tc := range tests {
+		err := tc.status.validate("test")
+		assert.Equal(t, tc.err, err)
+		if err == nil && tc.status.Match == statusMatchRegex {
+			assert.NotNil(t, tc.status.regex)
+		}
+	}
+}
+
+func TestMatchUserStatus(t *testing.T) {
+	available := true
+	unavailable := false
+	userStatuses := map[string]UserStatus{
+		"":               {TTL: 1},
+		"out sick":       {TTL: 8},
+		"holiday":        {TTL: 8, Available: &available},
+		"leave":          {TTL: 24, Text: "parental leave", Available: &unavailable},
+		"travelling":     {TTL: 4, Match: "substring", Available: &unavailable},
+		"on call":        {TTL: 2, Match: "regex", Text: "^on[- ]?call", Available: &unavailable},
+		"palm_tree":      {TTL: 12, Emoji: ":palm_tree:", Available: &unavailable},
+		"in a meeting":   {TTL: 1, Emoji: ":calendar:"},
+		"parental leave": {TTL: 48, Match: "substring"},
+	}
+
+	type test struct {
+		status          string
+		emoji           string
+		wantName        string
+		wantTTL         int
+		wantUnavailable bool
+	}
+
+	tests := []test{
+		// default rule
+		{status: "", wantName: "", wantTTL: 1},
+		{status: "coding", wantName: "", wantTTL: 1},
+		// exact match ignoring case, unavailable as set before rules
+		{status: "Out Sick", wantName: "out sick", wantTTL: 8, wantUnavailable: true},
+		// available overrides the statuses unavailable before rules
+		{status: "holiday", wantName: "holiday", wantTTL: 8},
+		// text set on rule, exact matches take priority over substring
+		{status: "parental leave", wantName: "leave", wantTTL: 24, wantUnavailable: true},
+		{status: "leave", wantName: "leave", wantTTL: 24, wantUnavailable: true},
+		{status: "on parental leave", wantName: "parental leave", wantTTL: 48},
+		{status: "travelling to london", wantName: "travelling", wantTTL: 4, wantUnavailable: true},
+		{status: "On-call this week", wantName: "on call", wantTTL: 2, wantUnavailable: true},
+		// emoji take priority over substring and regex
+		{status: "travelling", emoji: ":calendar:", wantName: "in a meeting", wantTTL: 1},
+		{status: "on call", emoji: ":palm_tree:", wantName: "palm_tree", wantTTL: 12, wantUnavailable: true},
+		{status: "", emoji: ":palm_tree:", wantName: "palm_tree", wantTTL: 12, wantUnavailable: true},
+	}
+
+	for _, tc := range tests {
+		name, rule, _ := matchUserStatus(userStatuses, tc.status, tc.emoji)
+		assert.Equal(t, tc.wantName, name, tc.status)
+		assert.Equal(t, tc.wantTTL, rule.TTL, tc.status)
+
+		_, unavailable := statusUnavailable(userStatuses, tc.status, tc.emoji)
+		assert.Equal(t, tc.wantUnavailable, unavailable, tc.status)
+	}
+
+	// statuses unavailable before rules without any configuration
+	reason, unavailable := statusUnavailable(nil, "Vacationing", "")
+	assert.True(t, unavailable)
+	assert.Equal(t, "vacationing", reason)
+}
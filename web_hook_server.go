@@ -20,7 +20,9 @@ type webhook struct {
 	Secret          string
 	EventsToAccept  []gitlab.EventType
 	GitlabBotUserID int
-	Requests        chan MergeRequest
+	Queue           requestQueue
+	// StatusUpdater - updates the slack message sent for a merge request on approve, merge and close, optional
+	StatusUpdater *reviewStatusUpdater
 }
 
 // Handle the different types of requests/gitlab events
@@ -65,7 +67,7 @@ func (hook webhook) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 }
 
 // Handle a MergerRequest Gitlab Event
-// Currently never returns an error.
+// Returns an error when the merge request could not be added to the processing queue.
 func (hook webhook) handleMRRequest(event *gitlab.MergeEvent) (string, error) {
 	// strip project name from path
 	groupPath, _ := groupPath(event.Project.PathWithNamespace)
@@ -94,6 +96,20 @@ func (hook webhook) handleMRRequest(event *gitlab.MergeEvent) (string, error) {
 		return "ignoring request initiated through this service.", nil
 	}
 
+	// Show the review status on the slack message, updated asynchronously as slack is not required to accept the event
+	status, statusChanged := reviewStatus(event.ObjectAttributes.Action)
+	if statusChanged && hook.StatusUpdater != nil {
+		user := event.User.Name
+		if user == "" {
+			user = event.User.Username
+		}
+		go func() {
+			if err := hook.StatusUpdater.update(mr, status, user); err != nil {
+				logger.WithFields(log.Fields{"error": err}).Error("failed to update slack message with review status.")
+			}
+		}()
+	}
+
 	// Ignore actions which should mean a reviewer should not be set
 	result, _ := regexp.MatchString("(approved|merge)", strings.ToLower(event.ObjectAttributes.Action))
 	if result {
@@ -102,6 +118,12 @@ func (hook webhook) handleMRRequest(event *gitlab.MergeEvent) (string, error) {
 		return "ignoring approved/merge action.", nil
 	}
 
+	if status == reviewClosed {
+		promIgnoreActions.WithLabelValues(event.ObjectAttributes.Action, mr.group).Inc()
+		logger.Debug("ignoring close action.")
+		return "ignoring close action.", nil
+	}
+
 	if event.ObjectAttributes.MergeStatus == "cannot_be_merged" {
 		promIgnoreActions.WithLabelValues("mr_cannot_merge", mr.group).Inc()
 		logger.Debug("ignoring as merge request cannot be merged.")
@@ -118,7 +140,9 @@ func (hook webhook) handleMRRequest(event *gitlab.MergeEvent) (string, error) {
 	}
 
 	// MR has passed checks; assign reviewer asynchronously
-	hook.Requests <- mr
+	if err := hook.Queue.push(mr); err != nil {
+		return "", err
+	}
 
 	return "successfully added merge request to processing queue.", nil
 }
@@ -26,6 +26,8 @@ func testPayload(fixture int) *gitlab.MergeEvent {
 		fixturePath = "./tests/fixtures/merge_request_events/merge-action.json"
 	case 4:
 		fixturePath = "./tests/fixtures/merge_request_events/approved-action.json"
+	case 5:
+		fixturePath = "./tests/fixtures/merge_request_events/close-action.json"
 	}
 
 	if err != nil {
@@ -58,11 +60,12 @@ func TestHandleMRRequest(t *testing.T) {
 		{2, 99999, "ignoring as merge request cannot be merged.", nil},
 		{3, 99999, "ignoring approved/merge action.", nil},
 		{4, 99999, "ignoring approved/merge action.", nil},
+		{5, 99999, "ignoring close action.", nil},
 		{1, 1, "ignoring request initiated through this service.", nil},
 	}
 
 	// Do not handle the channel element of this at the moment
-	requests := make(chan MergeRequest, 1)
+	requests := newMemoryQueue(1)
 
 	for _, tc := range tests {
 		event := testPayload(tc.fixture)
@@ -71,7 +74,7 @@ func TestHandleMRRequest(t *testing.T) {
 			Secret:          "test",
 			EventsToAccept:  []gitlab.EventType{gitlab.EventTypeMergeRequest},
 			GitlabBotUserID: tc.GitlabBotUserID,
-			Requests:        requests,
+			Queue:           requests,
 		}
 
 		got, err := wh.handleMRRequest(event)
@@ -2,7 +2,7 @@ package main
 
 import (
 	"errors"
-	"math/rand"
+	"regexp"
 	"strings"
 	"time"
 
@@ -17,16 +17,33 @@ const (
 	WorkerWaiting
 )
 
-type Worker struct{}
+// Worker - locks are shared between all workers so only one processes a merge request at a time, unmatched records
+// approvers without a matching slack user, emails caches gitlab user emails used for matching and notifications
+// delivers the reviewers assigned
+type Worker struct {
+	locks         *mrLocks
+	unmatched     *unmatchedUsers
+	emails        *gitlabEmails
+	notifications *notificationDispatcher
+}
+
+var (
+	errNoApprovers    = errors.New("no approvers available after slack status checks.")
+	errNoGroupChannel = errors.New("no slack channel configured.")
+)
+
+// Matches co-author trailers in commit messages: "Co-authored-by: name <email>"
+var coAuthorRegex = regexp.MustCompile(`(?im)^co-authored-by:\s*(.+?)\s*<([^>]+)>\s*$`)
 
-func NewWorker() *Worker {
-	return &Worker{}
+func NewWorker(locks *mrLocks, unmatched *unmatchedUsers, emails *gitlabEmails, notifications *notificationDispatcher) *Worker {
+	return &Worker{locks: locks, unmatched: unmatched, emails: emails, notifications: notifications}
 }
 
 // Working routing to handle assigning Reviewers to MergeRequests asynchronously
-func (w *Worker) Run(requests chan MergeRequest, responses chan MRResponse, status chan WorkerStatus, gitClient GitlabWrapper, slack SlackWrapper, config Config, cache *localCache) {
-	for mergeRequestJob := range requests {
-		logger := log.WithFields(log.Fields{"group": mergeRequestJob.Group(), "project_id": mergeRequestJob.ProjectID(), "merge_request_id": mergeRequestJob.MergeReqID()})
+func (w *Worker) Run(jobs <-chan job, responses chan MRResponse, status chan WorkerStatus, gitClient GitlabWrapper, slack SlackWrapper, config Config, cache UserCache) {
+	for j := range jobs {
+		mergeRequestJob := j.mr
+		logger := log.WithFields(log.Fields{"group": mergeRequestJob.Group(), "project_id": mergeRequestJob.ProjectID(), "merge_request_id": mergeRequestJob.MergeReqID(), "job_id": j.id})
 
 		status <- WorkerWorking
 
@@ -37,7 +54,7 @@ func (w *Worker) Run(requests chan MergeRequest, responses chan MRResponse, stat
 		} else {
 			logger.Info(resultMessage)
 		}
-		responses <- MRResponse{status: resultMessage, err: err}
+		responses <- MRResponse{job: j, status: resultMessage, err: err}
 
 		status <- WorkerWaiting
 	}
@@ -45,11 +62,19 @@ func (w *Worker) Run(requests chan MergeRequest, responses chan MRResponse, stat
 
 // Checks for current reviews and if none, assigns randomly from suggested approvers
 //gocyclo:ignore
-func (w *Worker) ProcessMR(gitClient GitlabWrapper, mr MergeRequests, slack SlackWrapper, config Config, responses chan MRResponse, cache *localCache) (string, error) {
+func (w *Worker) ProcessMR(gitClient GitlabWrapper, mr MergeRequests, slack SlackWrapper, config Config, responses chan MRResponse, cache UserCache) (string, error) {
 	logger := log.WithFields(log.Fields{"group": mr.Group(), "project_id": mr.ProjectID(), "merge_request_id": mr.MergeReqID()})
 
 	promProcessedMRs.WithLabelValues(mr.Group()).Inc()
 
+	// Reviewers are read then set, another worker must not process the merge request in between
+	unlock, contended := w.locks.lock(mergeRequestKey(mr))
+	defer unlock()
+	if contended {
+		promLockContention.WithLabelValues(mr.Group()).Inc()
+		logger.Debug("waited for another worker processing merge request.")
+	}
+
 	err, mrResult := mr.getMR(gitClient)
 	if err != nil {
 		return "", err
@@ -77,60 +102,78 @@ func (w *Worker) ProcessMR(gitClient GitlabWrapper, mr MergeRequests, slack Slac
 	if err != nil {
 		return "", err
 	}
+	// order the approvers are returned in, round robin selection follows it past unavailable approvers
+	allApprovers := approvers
 
-	slackChannel, slackChannelID, err := getSlackChannel(mr.PathWithNamespace(), config.GroupChannels)
+	groupKey, groupChannel, err := getGroupChannel(mr.PathWithNamespace(), config.GroupChannels)
 	if err != nil {
 		return "", err
 	}
+	slackChannelID := groupChannel.SlackChannelID
 
-	// Check for any missing usernames from the cache in comparison to the codeowners
-	// if missing the system will not know the slack user ID which is required for requesting
-	// the slack user status, therefore we must grab it via the allocated channel for sending slack
-	// messages
-	var usernames []string
-	for _, user := range approvers {
-		usernames = append(usernames, user.Username)
+	var commits []*gitlab.Commit
+	if groupChannel.Selection.ExcludeCommitters {
+		commits, err = mr.getMRCommits(gitClient)
+		if err != nil {
+			return "", err
+		}
 	}
-	missingUsernames := cache.getMissingIDs(usernames...)
-	if len(missingUsernames) > 0 {
-		slackUsernames, err := getSlackUserIDs(slack, cache, slackChannelID, mr)
+	approvers, exclusions := excludeAuthors(approvers, mrResult.Author, commits, groupChannel.Selection, mr)
+
+	// Approvers missing from the cache have no known slack user ID which is required for requesting the slack user
+	// status, therefore they are matched against the members of the channel allocated for sending slack messages. Groups
+	// notified through another chat have no slack channel to match against. Approvers recently found without a match
+	// are not matched again until the recheck interval has passed.
+	unmapped := w.unmatched.toMatch(getUnmappedApprovers(cache, approvers), mr.Group(), time.Now())
+	if len(unmapped) > 0 && slackChannelID != "" {
+		slackUserIDs, err := getSlackUserIDs(slack, cache, slackChannelID, mr)
 		if err != nil {
 			return "", err
 		}
-		logger.WithFields(log.Fields{"missing_ids": slackUsernames}).Debug("missing cache entries, fetching usernames from channel")
-		// We have pulled out all the userids but with no way of knowing what ID belongs to who, so call
-		// a full update cache for all users in the channel. This is initially expensive but should mean that
-		// we gain majority coverage of all git username to slack username and slack id quickly and the IDS
-		// will remain mapped in memory in the cache.
+		logger.WithFields(log.Fields{"num_unmapped": len(unmapped), "num_channel_users": len(slackUserIDs)}).Debug("missing cache entries, matching against users in channel.")
 
 		// ToDo: Handle a large number of user ids pulled from the slack channel
-		err = updateCache(slack, cache, mr, slackUsernames, config)
+		unmatched, err := updateCache(slack, gitClient, w.emails, cache, mr, unmapped, slackUserIDs, config)
 		if err != nil {
 			return "", err
 		}
+		for _, approver := range unmapped {
+			w.unmatched.remove(approver.Username)
+		}
+		for _, approver := range unmatched {
+			w.unmatched.add(approver, mr.Group())
+		}
 	}
 
-	approvers = checkCache(slack, cache, approvers, mr, config)
+	approvers, unavailable := checkCache(gitClient, slack, cache, approvers, mr, config)
+	exclusions = append(exclusions, unavailable...)
 
 	if len(approvers) == 0 {
 		promIgnoreActions.WithLabelValues("no_available_approvers", mr.Group()).Inc()
-		return "", errors.New("no approvers available after slack status checks.")
+		return "", errNoApprovers
 	}
 
-	selectedApprovers := selectApprovers(approvers, approvalsRequired)
-	logger.WithFields(log.Fields{"selected": selectedApprovers, "approvals_required": approvalsRequired, "num_approvers": len(approvers)}).Debug("selected to assign to mr.")
+	selector := config.reviewerSelector(groupKey)
+	selectedApprovers := selectReviewers(selector, gitClient, slack, cache, config.presences, mr, groupChannel.Selection, allApprovers, approvers, approvalsRequired)
+	logger.WithFields(log.Fields{"selected": selectedApprovers, "approvals_required": approvalsRequired, "num_approvers": len(approvers), "strategy": groupChannel.Selection.Strategy}).Debug("selected to assign to mr.")
 
 	err = mr.setMRReviwer(gitClient, selectedApprovers)
 	if err != nil {
 		return "", err
 	}
 
-	if len(slackChannelID) > 0 {
-		logger.WithFields(log.Fields{"channel": slackChannel}).Debug("send slack message.")
-		err = sendSlackMsg(slack, slackChannel, selectedApprovers, mr)
-		if err != nil {
-			return "", err
-		}
+	a := assignment{mr: mr, reviewers: selectedApprovers, approvalsRequired: approvalsRequired, exclusions: exclusions, author: mrResult.Author, labels: mrResult.Labels, changes: mrResult.ChangesCount, pipelineStatus: headPipelineStatus(mrResult)}
+
+	// Reviewers are already assigned, webhooks are delivered and retried without failing the merge request
+	for _, webhook := range config.outboundWebhooks(groupKey) {
+		w.notifications.send(webhook, a)
+	}
+
+	// Reviewers are already assigned, notifications are delivered and retried without failing the merge request
+	notifier, ok := config.notifier(groupKey, slack, cache)
+	if ok {
+		logger.WithFields(log.Fields{"notifier": notifier.Name()}).Debug("send notification.")
+		w.notifications.send(notifier, a)
 	} else {
 		logger.WithFields(log.Fields{"group": mr.Group()}).Warn("no slack channel configured for group.")
 		promSlackMsgsErrors.WithLabelValues("no_slack_channel_configured", mr.Group(), "").Inc()
@@ -139,85 +182,131 @@ func (w *Worker) ProcessMR(gitClient GitlabWrapper, mr MergeRequests, slack Slac
 	return "successfully processed merge request.", nil
 }
 
-// checkCache: check the cache for user status and update where required, then pass on a list of available approvers
-func checkCache(slack SlackWrapper, cache *localCache, suggestedApprovers []*gitlab.BasicUser, mr MergeRequests, config Config) []*gitlab.BasicUser {
+// checkCache: check the availability of each approver through the chain of availability providers, starting with the
+// slack status held in the cache, then pass on a list of available approvers and the reason each other approver was
+// excluded
+func checkCache(gitClient GitlabWrapper, slack SlackWrapper, cache UserCache, suggestedApprovers []*gitlab.BasicUser, mr MergeRequests, config Config) ([]*gitlab.BasicUser, []exclusion) {
 	logger := log.WithFields(log.Fields{"group": mr.Group(), "project_id": mr.ProjectID(), "merge_request_id": mr.MergeReqID()})
 
-	// var unexpectedErrors []string
-	var approvers []*gitlab.BasicUser
+	chain := config.availabilityChain(newSlackProvider(slack, cache, config, mr), newGitlabProvider(gitClient, config.gitlabStatuses, config, mr))
+	now := time.Now()
 
+	var approvers []*gitlab.BasicUser
+	var exclusions []exclusion
 	for _, gitUser := range suggestedApprovers {
-		cachedUser, err := cache.read(gitUser.Username)
+		provider, answer, err := chain.check(gitUser, mr, now)
 		if err != nil {
-			switch err.Error() {
-			case "no_user_in_cache":
-				// this should not happen as missing users are checked before this (above) and should be added before this check function.
-				promErrors.WithLabelValues("user_not_found_in_cache").Inc()
-				logger.WithFields(log.Fields{"error": err}).Error("user not found in cache.")
-				continue
-			case "user_data_expired":
-				slackUsersData, _, err := getUsersInfo(slack, cachedUser.slackUserID)
-				if err != nil {
-					logger.WithFields(log.Fields{"error": err}).Error("failed to get slack user data.")
-					// do not block on error which is hopefully temporary, continue to review other users
-					continue
-				}
-				for _, s := range *slackUsersData {
-					u := userMeta{username: cachedUser.username, slackUserID: cachedUser.slackUserID, status: strings.ToLower(s.Profile.StatusText)}
-					t := time.Now()
-					ttl := getStatusTTL(config.UserStatuses, s.Profile.StatusText)
-					expire := t.Add(time.Hour * time.Duration(ttl))
-					cache.update(u, expire.Unix())
-				}
-			default:
-				// will NOT execute because of the line preceding the switch.
+			// do not block on error which is hopefully temporary, continue to review other users
+			logger.WithFields(log.Fields{"error": err, "provider": provider, "username": gitUser.Username}).Warn("failed to check availability, excluding user.")
+			exclusions = append(exclusions, exclusion{Username: gitUser.Username, Reason: "availability_error", Provider: provider})
+			continue
+		}
+		if !answer.available {
+			logger.WithFields(log.Fields{"provider": provider, "reason": answer.reason, "ttl": answer.ttl, "username": gitUser.Username}).Debug("user unavailable.")
+			exclusions = append(exclusions, exclusion{Username: gitUser.Username, Reason: answer.reason, Provider: provider})
+			continue
+		}
+		approvers = append(approvers, gitUser)
+	}
+	return approvers, exclusions
+}
+
+// excludeAuthors: remove the merge request author, unless allowed, and the authors of any passed commits (including
+// co-authors) from the suggested approvers, returning the remaining approvers and those excluded. Commit authors are
+// matched against the gitlab user by name or email local part as commits only include the git author details.
+func excludeAuthors(suggestedApprovers []*gitlab.BasicUser, author *gitlab.BasicUser, commits []*gitlab.Commit, selection Selection, mr MergeRequests) ([]*gitlab.BasicUser, []exclusion) {
+	logger := log.WithFields(log.Fields{"group": mr.Group(), "project_id": mr.ProjectID(), "merge_request_id": mr.MergeReqID()})
+
+	type commitAuthor struct {
+		name  string
+		email string
+	}
+	var commitAuthors []commitAuthor
+	for _, commit := range commits {
+		commitAuthors = append(commitAuthors, commitAuthor{commit.AuthorName, commit.AuthorEmail})
+		for _, match := range coAuthorRegex.FindAllStringSubmatch(commit.Message, -1) {
+			commitAuthors = append(commitAuthors, commitAuthor{match[1], match[2]})
+		}
+	}
+
+	var approvers []*gitlab.BasicUser
+	var exclusions []exclusion
+	for _, approver := range suggestedApprovers {
+		if !selection.AllowAuthor && author != nil && approver.ID == author.ID {
+			promExcludedApprovers.WithLabelValues("author", mr.Group()).Inc()
+			logger.WithFields(log.Fields{"reason": "author", "username": approver.Username}).Debug("user excluded from selection.")
+			exclusions = append(exclusions, exclusion{Username: approver.Username, Reason: "author"})
+			continue
+		}
+
+		committed := false
+		for _, ca := range commitAuthors {
+			// Empty names and emails, such as a co-author trailer without an email, match no one
+			name := strings.TrimSpace(ca.name)
+			emailUser := strings.TrimSpace(strings.SplitN(ca.email, "@", 2)[0])
+			if (name != "" && approver.Name != "" && strings.EqualFold(name, approver.Name)) || (emailUser != "" && approver.Username != "" && strings.EqualFold(emailUser, approver.Username)) {
+				committed = true
+				break
 			}
 		}
-		if cachedUser.status == "out sick" || cachedUser.status == "vacationing" || cachedUser.status == "holiday" {
-			promSlackStatusUnavailable.WithLabelValues(cachedUser.status, mr.Group()).Inc()
-			logger.WithFields(log.Fields{"reason": cachedUser.status, "username": gitUser.Username}).Debug("user unavailable due to slack status.")
-		} else {
-			approvers = append(approvers, gitUser)
+		if committed {
+			promExcludedApprovers.WithLabelValues("committer", mr.Group()).Inc()
+			logger.WithFields(log.Fields{"reason": "committer", "username": approver.Username}).Debug("user excluded from selection.")
+			exclusions = append(exclusions, exclusion{Username: approver.Username, Reason: "committer"})
+			continue
 		}
+
+		approvers = append(approvers, approver)
 	}
-	return approvers
+	return approvers, exclusions
 }
 
-// updateCache: Update the user cache for all passed updates (list of usernames)
-func updateCache(slack SlackWrapper, cache *localCache, mr MergeRequests, updates []string, config Config) error {
+// updateCache: match the passed gitlab users against the slack users (list of slack user ids) and add each match to
+// the cache keyed by gitlab username, returning the gitlab users without a match
+func updateCache(slack SlackWrapper, gitClient GitlabWrapper, emails *gitlabEmails, cache UserCache, mr MergeRequests, gitUsers []*gitlab.BasicUser, slackUserIDs []string, config Config) ([]*gitlab.BasicUser, error) {
 	logger := log.WithFields(log.Fields{"group": mr.Group(), "project_id": mr.ProjectID(), "merge_request_id": mr.MergeReqID()})
 
-	logger.WithFields(log.Fields{"num_updates": len(updates)}).Debug("updating cache entries.")
+	logger.WithFields(log.Fields{"num_updates": len(gitUsers)}).Debug("updating cache entries.")
 
-	slackUsersData, missingIDs, err := getUsersInfo(slack, updates...)
+	slackUsersData, missingIDs, err := getUsersInfo(slack, slackUserIDs...)
 	if err != nil {
 		logger.WithFields(log.Fields{"error": err}).Error("failed to get slack user data.")
-		return err
+		return nil, err
+	}
+	if len(missingIDs) > 0 {
+		logger.WithFields(log.Fields{"num_missing": len(missingIDs)}).Debug("slack user ids in channel without user data.")
+	}
+
+	matched, unmatched := matchSlackUsers(gitClient, emails, gitUsers, *slackUsersData, config.UserMappings, mr)
+	for username, s := range matched {
+		logger.WithFields(log.Fields{"username": username, "slack_user_id": s.ID}).Debug("updating cache entry.")
+		updateCachedUser(cache, username, s, config)
 	}
 
 	// Gitlab Users that do not exist in Slack
-	if len(missingIDs) > 0 {
-		logger.WithFields(log.Fields{"num_missing": len(missingIDs)}).Debug("gitlab users in codeowners without matching user in slack channel.")
+	for _, gitUser := range unmatched {
 		promSlackUsersMissing.WithLabelValues(mr.Group()).Inc()
+		logger.WithFields(log.Fields{"username": gitUser.Username}).Warn("gitlab user without matching user in slack channel, add a user mapping.")
 	}
 
-	if len(*slackUsersData) > 0 {
-		for _, s := range *slackUsersData {
-			logger.WithFields(log.Fields{"username": len(s.Name)}).Debug("updating cache entry.")
-			u := userMeta{username: s.Name, slackUserID: s.ID, status: strings.ToLower(s.Profile.StatusText)}
-			t := time.Now()
-			ttl := getStatusTTL(config.UserStatuses, s.Profile.StatusText)
-			expire := t.Add(time.Hour * time.Duration(ttl))
-			cache.update(u, expire.Unix())
+	return unmatched, nil
+}
+
+// getUnmappedApprovers: return the approvers not in the cache or without a slack user id
+func getUnmappedApprovers(cache UserCache, approvers []*gitlab.BasicUser) []*gitlab.BasicUser {
+	var unmapped []*gitlab.BasicUser
+	for _, approver := range approvers {
+		cachedUser, err := cache.read(approver.Username)
+		if errors.Is(err, errUserNotInCache) || cachedUser.slackUserID == "" {
+			unmapped = append(unmapped, approver)
 		}
 	}
-
-	return nil
+	return unmapped
 }
 
 // getSlackUserIDs: get list of slack user ids from channel set in configuration and compare against ids found in the
 // local cache returning a list of all missing ids.
-func getSlackUserIDs(slack SlackWrapper, cache *localCache, slackChannelID string, mr MergeRequests) ([]string, error) {
+func getSlackUserIDs(slack SlackWrapper, cache UserCache, slackChannelID string, mr MergeRequests) ([]string, error) {
 	slackUsers, err := getUsersInConversation(slack, slackChannelID)
 	if err != nil {
 		return nil, err
@@ -225,52 +314,30 @@ func getSlackUserIDs(slack SlackWrapper, cache *localCache, slackChannelID strin
 	return slackUsers, nil
 }
 
-// Select random reviewers upto approvals required
-func selectApprovers(approvers []*gitlab.BasicUser, approvalsRequired int) []*gitlab.BasicUser {
-	var selectedApprovers []*gitlab.BasicUser
-
-	if approvalsRequired > len(approvers) {
-		return approvers
-	}
-
-	rand.Seed(time.Now().UnixNano())
-	// Random is by the index of the result.
-	randomApprovalIndexes := rand.Perm(len(approvers))
-
-	for i := 0; i < approvalsRequired; i++ {
-		randID := randomApprovalIndexes[i]
-		selectedApprovers = append(selectedApprovers, approvers[randID])
-	}
-
-	return selectedApprovers
-}
-
-// getSlackChannel: return slack channel and id to post to based on the group set in the MR payload
-func getSlackChannel(pathWithNamespace string, groupChannels map[string]GroupChannel) (string, string, error) {
+// getGroupChannel: return the matched group and its configuration based on the group set in the MR payload, walking
+// up parent groups until a match is found
+func getGroupChannel(pathWithNamespace string, groupChannels map[string]GroupChannel) (string, GroupChannel, error) {
 	compare := strings.ToLower(pathWithNamespace)
 	var err error
 	for {
 		if channel, ok := groupChannels[compare]; ok {
-			return channel.SlackChannel, channel.SlackChannelID, nil
+			return compare, channel, nil
 		}
 
 		compare, err = groupPath(compare)
 		if err != nil {
-			return "", "", errors.New("no slack channel configured.")
+			return "", GroupChannel{}, errNoGroupChannel
 		}
 	}
 }
 
-// getStatusTTL: return the ttl in hours assigned to a status
-// TODO: make the statuses generic, instead of being allocated to slack. The statuses themselves have
-//       nothing to do with slack and could come from any place.
-func getStatusTTL(userStatuses map[string]int, status string) int {
-	logger := log.WithFields(log.Fields{"status": status})
-	for k, v := range userStatuses {
-		if k == strings.ToLower(status) {
-			return v
-		}
+// getStatusTTL: return the ttl in hours assigned to the rule matching a status, otherwise the default rule. Sources of
+// availability other than the slack status are consulted through an AvailabilityProvider.
+func getStatusTTL(userStatuses map[string]UserStatus, status string, emoji string) int {
+	logger := log.WithFields(log.Fields{"status": status, "emoji": emoji})
+	_, rule, ok := matchUserStatus(userStatuses, status, emoji)
+	if !ok {
+		logger.Debug("returned user status has no config entry, using default.")
 	}
-	logger.Debug("returned user status has no config entry, using default.")
-	return userStatuses[""]
+	return rule.TTL
 }
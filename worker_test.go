@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/slack-go/slack"
@@ -75,6 +76,10 @@ func (mr MockMergeRequest) getMRApprovers(gc GitlabWrapper) ([]*gitlab.BasicUser
 	return approvers, 1, nil
 }
 
+func (mr MockMergeRequest) getMRCommits(gc GitlabWrapper) ([]*gitlab.Commit, error) {
+	return nil, nil
+}
+
 func (mr MockMergeRequest) setMRReviwer(gc GitlabWrapper, reviewers []*gitlab.BasicUser) error {
 	return nil
 }
@@ -119,6 +124,42 @@ func (mr MockMergeRequest) WorkInProgress() bool {
 	return mr.workInProgress
 }
 
+// racingMergeRequest: merge request shared between workers recording reviewers set, getMR is slow so workers
+// without locking would all see no reviewers assigned
+type racingMergeRequest struct {
+	MockMergeRequest
+	state *racingState
+}
+
+type racingState struct {
+	mu        sync.Mutex
+	reviewers []*gitlab.BasicUser
+	assigned  int
+}
+
+func (mr racingMergeRequest) getMR(gc GitlabWrapper) (error, *gitlab.MergeRequest) {
+	err, gmr := mr.MockMergeRequest.getMR(gc)
+	if err != nil {
+		return err, nil
+	}
+
+	mr.state.mu.Lock()
+	gmr.Reviewers = mr.state.reviewers
+	mr.state.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+	return nil, gmr
+}
+
+func (mr racingMergeRequest) setMRReviwer(gc GitlabWrapper, reviewers []*gitlab.BasicUser) error {
+	mr.state.mu.Lock()
+	defer mr.state.mu.Unlock()
+
+	mr.state.reviewers = reviewers
+	mr.state.assigned++
+	return nil
+}
+
 type MockSlack struct {
 	mock.Mock
 	wh_url string
@@ -128,6 +169,26 @@ func (s *MockSlack) PostMessage(channelID string, options ...slack.MsgOption) (s
 	return "", "", nil
 }
 
+func (s *MockSlack) PostEphemeral(channelID string, userID string, options ...slack.MsgOption) (string, error) {
+	return "", nil
+}
+
+func (s *MockSlack) UpdateMessage(channelID string, timestamp string, options ...slack.MsgOption) (string, string, string, error) {
+	if channelID == "E" {
+		return "", "", "", errors.New("message_not_found")
+	}
+	return channelID, timestamp, "", nil
+}
+
+func (s *MockSlack) OpenConversation(params *slack.OpenConversationParameters) (*slack.Channel, bool, bool, error) {
+	if params.Users[0] == "E" {
+		return nil, false, false, errors.New("user_not_found")
+	}
+	channel := &slack.Channel{}
+	channel.ID = "D" + params.Users[0]
+	return channel, false, false, nil
+}
+
 func (s *MockSlack) GetUsersInConversation(params *slack.GetUsersInConversationParameters) ([]string, string, error) {
 	var users []string
 	switch params.ChannelID {
@@ -159,6 +220,7 @@ func (s *MockSlack) GetUsersInfo(users ...string) (*[]slack.User, error) {
 		Name: "test2",
 		Profile: slack.UserProfile{
 			StatusText: "",
+			Email:      "test2@slack.local",
 		},
 	}
 	u3 := slack.User{
@@ -187,6 +249,29 @@ func (s *MockSlack) GetUsersInfo(users ...string) (*[]slack.User, error) {
 	return &userInfo, nil
 }
 
+// GetUserPresence: user "2" away, "E" error, otherwise active
+func (s *MockSlack) GetUserPresence(user string) (*slack.UserPresence, error) {
+	switch user {
+	case "2":
+		return &slack.UserPresence{Presence: "away"}, nil
+	case "E":
+		return nil, errors.New("user_not_found")
+	}
+	return &slack.UserPresence{Presence: "active", Online: true}, nil
+}
+
+// GetDNDInfo: user "3" snoozed, "4" within scheduled do not disturb hours, otherwise off
+func (s *MockSlack) GetDNDInfo(user *string) (*slack.DNDStatus, error) {
+	now := time.Now()
+	switch *user {
+	case "3":
+		return &slack.DNDStatus{SnoozeInfo: slack.SnoozeInfo{SnoozeEnabled: true, SnoozeEndTime: int(now.Add(time.Hour).Unix())}}, nil
+	case "4":
+		return &slack.DNDStatus{Enabled: true, NextStartTimestamp: int(now.Add(-time.Hour).Unix()), NextEndTimestamp: int(now.Add(time.Hour).Unix())}, nil
+	}
+	return &slack.DNDStatus{Enabled: true, NextStartTimestamp: int(now.Add(time.Hour).Unix()), NextEndTimestamp: int(now.Add(2 * time.Hour).Unix())}, nil
+}
+
 // Tests:
 
 func TestProcessMR(t *testing.T) {
@@ -200,7 +285,7 @@ func TestProcessMR(t *testing.T) {
 		},
 	}
 
-	worker := NewWorker()
+	worker := NewWorker(newMRLocks(), newUnmatchedUsers(), newGitlabEmails(), newNotificationDispatcher())
 	mockResponses := make(chan MRResponse, 10000)
 
 	cache := newLocalCache()
@@ -250,95 +335,165 @@ func TestProcessMR(t *testing.T) {
 	}
 }
 
-func TestSelectApprovers(t *testing.T) {
-	type test struct {
-		approvers         []*gitlab.BasicUser
-		approvalsRequired int
-		validIDs          []int
+func TestProcessMRConcurrent(t *testing.T) {
+	mockGitClient := &mockGitlab{}
+	mockConfig := Config{
+		GroupChannels: map[string]GroupChannel{
+			"test/test": {SlackChannel: "channel", SlackChannelID: "AAAAA"},
+		},
 	}
+	mockResponses := make(chan MRResponse, 10000)
 
-	a1 := &gitlab.BasicUser{
-		ID:       1,
-		Name:     "Test User 1",
-		Username: "test.user1",
-	}
-	a2 := &gitlab.BasicUser{
-		ID:       2,
-		Name:     "Test User 2",
-		Username: "test.user2",
-	}
-	a3 := &gitlab.BasicUser{
-		ID:       3,
-		Name:     "Test User 3",
-		Username: "test.user3",
+	cache := newLocalCache()
+	timeExpire := time.Now().Add(time.Hour * 8)
+	cache.update(userMeta{username: "test1", slackUserID: "1"}, timeExpire.Unix())
+	cache.update(userMeta{username: "test2", slackUserID: "2"}, timeExpire.Unix())
+
+	locks := newMRLocks()
+	unmatched := newUnmatchedUsers()
+	state := &racingState{}
+	mr := racingMergeRequest{
+		MockMergeRequest: MockMergeRequest{pathWithNamespace: "test/test", group: "test", projectID: 1, mergeReqID: 2},
+		state:            state,
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan string, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker := NewWorker(locks, unmatched, newGitlabEmails(), newNotificationDispatcher())
+			got, err := worker.ProcessMR(mockGitClient, mr, &MockSlack{}, mockConfig, mockResponses, cache)
+			assert.NoError(t, err)
+			results <- got
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	// only the first worker assigns a reviewer, the rest see the reviewer already assigned
+	assert.Equal(t, 1, state.assigned)
+	processed := 0
+	for got := range results {
+		if got == "successfully processed merge request." {
+			processed++
+		} else {
+			assert.Equal(t, "reviewer already assigned.", got)
+		}
 	}
+	assert.Equal(t, 1, processed)
+	assert.Equal(t, 0, locks.size())
+}
+
+func TestExcludeAuthors(t *testing.T) {
+	a1 := &gitlab.BasicUser{ID: 1, Name: "Test User 1", Username: "test.user1"}
+	a2 := &gitlab.BasicUser{ID: 2, Name: "Test User 2", Username: "test.user2"}
+	a3 := &gitlab.BasicUser{ID: 3, Name: "Test User 3", Username: "test.user3"}
+	a4 := &gitlab.BasicUser{ID: 4, Username: "test.user4"}
 	approvers := []*gitlab.BasicUser{a1, a2, a3}
 
+	mockMR := MockMergeRequest{group: "test", projectID: 1, mergeReqID: 1}
+
+	type test struct {
+		approvers []*gitlab.BasicUser
+		author    *gitlab.BasicUser
+		commits   []*gitlab.Commit
+		selection Selection
+		want      []*gitlab.BasicUser
+	}
+
 	tests := []test{
-		// should return empty list
-		{approvers: approvers, approvalsRequired: 0, validIDs: []int{}},
-		{approvers: approvers, approvalsRequired: 1, validIDs: []int{1, 2, 3}},
-		{approvers: approvers, approvalsRequired: 2, validIDs: []int{1, 2, 3}},
-		// should return 3 approvers
-		{approvers: approvers, approvalsRequired: 4, validIDs: []int{1, 2, 3}},
+		// author excluded by default
+		{author: a1, commits: nil, selection: Selection{}, want: []*gitlab.BasicUser{a2, a3}},
+		{author: a1, commits: nil, selection: Selection{AllowAuthor: true}, want: []*gitlab.BasicUser{a1, a2, a3}},
+		// author not a suggested approver
+		{author: &gitlab.BasicUser{ID: 4}, commits: nil, selection: Selection{}, want: []*gitlab.BasicUser{a1, a2, a3}},
+		{author: nil, commits: nil, selection: Selection{}, want: []*gitlab.BasicUser{a1, a2, a3}},
+		// commit author matched by name
+		{
+			author:    a1,
+			commits:   []*gitlab.Commit{{AuthorName: "test user 2", AuthorEmail: "t2@example.com"}},
+			selection: Selection{ExcludeCommitters: true},
+			want:      []*gitlab.BasicUser{a3},
+		},
+		// commit author matched by email and co-author trailer
+		{
+			author: a1,
+			commits: []*gitlab.Commit{
+				{AuthorName: "Someone", AuthorEmail: "test.user2@example.com", Message: "fix: thing\n\nCo-authored-by: Test User 3 <t3@example.com>\n"},
+			},
+			selection: Selection{ExcludeCommitters: true},
+			want:      nil,
+		},
+		// empty commit author name and email match no one, including approvers without a name
+		{
+			approvers: []*gitlab.BasicUser{a1, a2, a3, a4},
+			author:    nil,
+			commits:   []*gitlab.Commit{{AuthorName: " ", AuthorEmail: ""}, {AuthorName: "Someone", AuthorEmail: "@example.com"}},
+			selection: Selection{ExcludeCommitters: true},
+			want:      []*gitlab.BasicUser{a1, a2, a3, a4},
+		},
 	}
 
 	for _, tc := range tests {
-		selectedApprovers := selectApprovers(tc.approvers, tc.approvalsRequired)
-		if len(tc.approvers) > tc.approvalsRequired {
-			assert.Equal(t, tc.approvalsRequired, len(selectedApprovers))
-		}
-		for _, sa := range selectedApprovers {
-			assert.Contains(t, tc.validIDs, sa.ID)
+		suggested := approvers
+		if tc.approvers != nil {
+			suggested = tc.approvers
 		}
+		got, _ := excludeAuthors(suggested, tc.author, tc.commits, tc.selection, mockMR)
+		assert.Equal(t, tc.want, got)
 	}
+
+	_, exclusions := excludeAuthors(approvers, a1, []*gitlab.Commit{{AuthorName: "test user 2"}}, Selection{ExcludeCommitters: true}, mockMR)
+	assert.Equal(t, []exclusion{{Username: a1.Username, Reason: "author"}, {Username: a2.Username, Reason: "committer"}}, exclusions)
 }
 
-func TestGetSlackChannel(t *testing.T) {
+func TestGetGroupChannel(t *testing.T) {
 	type test struct {
 		search          string
+		want_group      string
 		want_channel    string
 		want_channel_id string
-		err             string
+		err             error
 	}
 
 	mockChannelConfig := Config{
 		GroupChannels: map[string]GroupChannel{
 			"repo":           {SlackChannel: "channel", SlackChannelID: "AAAAA"},
-			"sub-group/repo": {SlackChannel: "channel", SlackChannelID: "AAAAA"},
+			"sub-group/repo": {SlackChannel: "sub-channel", SlackChannelID: "BBBBB"},
 			"sub_group/repo": {SlackChannel: "channel", SlackChannelID: "AAAAA"},
 		},
 	}
 
 	tests := []test{
-		{search: "repo", want_channel: "channel", want_channel_id: "AAAAA", err: ""},
-		{search: "sub-group/repo", want_channel: "channel", want_channel_id: "AAAAA", err: ""},
-		{search: "sub_group/repo", want_channel: "channel", want_channel_id: "AAAAA", err: ""},
-		{search: "SUB_GROUP/REPO", want_channel: "channel", want_channel_id: "AAAAA", err: ""},
-		{search: "not-exist", want_channel: "", want_channel_id: "", err: "no slack channel configured."},
+		{search: "repo", want_group: "repo", want_channel: "channel", want_channel_id: "AAAAA"},
+		{search: "sub-group/repo", want_group: "sub-group/repo", want_channel: "sub-channel", want_channel_id: "BBBBB"},
+		{search: "sub_group/repo", want_group: "sub_group/repo", want_channel: "channel", want_channel_id: "AAAAA"},
+		{search: "SUB_GROUP/REPO", want_group: "sub_group/repo", want_channel: "channel", want_channel_id: "AAAAA"},
+		// parent groups matched when the project has no entry
+		{search: "sub-group/repo/project", want_group: "sub-group/repo", want_channel: "sub-channel", want_channel_id: "BBBBB"},
+		{search: "not-exist", err: errNoGroupChannel},
 	}
 
 	for _, tc := range tests {
 		mr := MergeRequest{pathWithNamespace: tc.search}
-		GroupChannels := mockChannelConfig.GroupChannels
 
-		got_channel, got_channel_id, err := getSlackChannel(mr.pathWithNamespace, GroupChannels)
-		if err != nil {
-			assert.Equal(t, err.Error(), tc.err)
-		} else {
-			assert.Equal(t, got_channel, tc.want_channel)
-			assert.Equal(t, got_channel_id, tc.want_channel_id)
-		}
+		got_group, got_channel, err := getGroupChannel(mr.pathWithNamespace, mockChannelConfig.GroupChannels)
+		assert.Equal(t, tc.err, err, tc.search)
+		assert.Equal(t, tc.want_group, got_group, tc.search)
+		assert.Equal(t, tc.want_channel, got_channel.SlackChannel, tc.search)
+		assert.Equal(t, tc.want_channel_id, got_channel.SlackChannelID, tc.search)
 	}
 }
 
 func TestCheckCache(t *testing.T) {
 	mockConfig := Config{
-		UserStatuses: map[string]int{
-			"":           1,
-			"out sick":   8,
-			"vactioning": 8,
-			"holiday":    8,
+		UserStatuses: map[string]UserStatus{
+			"":           {TTL: 1},
+			"out sick":   {TTL: 8},
+			"vactioning": {TTL: 8},
+			"holiday":    {TTL: 8},
 		},
 	}
 
@@ -417,20 +572,33 @@ func TestCheckCache(t *testing.T) {
 			cache.update(cache2, timeExpired.Unix())
 		}
 
-		got := checkCache(&mockSlack, cache, tc.suggestedApprovers, mockMR, mockConfig)
+		// expired users are refreshed in the background unless disabled
+		for _, disabled := range []bool{false, true} {
+			mockConfig.CacheRefresh.Disabled = disabled
+			got, _ := checkCache(&mockGitlab{}, &mockSlack, cache, tc.suggestedApprovers, mockMR, mockConfig)
 
-		assert.Equal(t, tc.approvers, got)
+			assert.Equal(t, tc.approvers, got)
+		}
 	}
+
+	// available by slack status but unavailable through an availability provider
+	mockConfig.providers = []AvailabilityProvider{&mockProvider{name: providerCalendar, answers: map[string]availability{"test2": unavailableAnswer("Annual Leave")}}}
+	got, exclusions := checkCache(&mockGitlab{}, &MockSlack{wh_url: "H"}, cache, []*gitlab.BasicUser{reviewer1, reviewer2}, mockMR, mockConfig)
+	assert.Equal(t, []*gitlab.BasicUser{reviewer1}, got)
+	assert.Equal(t, []exclusion{{Username: "test2", Reason: "Annual Leave", Provider: providerCalendar}}, exclusions)
 }
 
 func TestUpdateCache(t *testing.T) {
 	// updte existing cache entry timestamp
 	mockConfig := Config{
-		UserStatuses: map[string]int{
-			"":           1,
-			"out sick":   8,
-			"vactioning": 8,
-			"holiday":    8,
+		UserStatuses: map[string]UserStatus{
+			"":           {TTL: 1},
+			"out sick":   {TTL: 8},
+			"vactioning": {TTL: 8},
+			"holiday":    {TTL: 8},
+		},
+		UserMappings: map[string]string{
+			"ldap5": "1",
 		},
 	}
 
@@ -441,13 +609,22 @@ func TestUpdateCache(t *testing.T) {
 		mergeReqID:        1,
 		workInProgress:    false,
 	}
+	mockGitClient := &mockGitlab{}
 
 	cache := newLocalCache()
 
+	test1 := &gitlab.BasicUser{ID: 1, Username: "test1"}
+	test2 := &gitlab.BasicUser{ID: 2, Username: "test2"}
+	// gitlab usernames not matching slack names, matched by email or configured mapping
+	ldap4 := &gitlab.BasicUser{ID: 4, Username: "ldap4"}
+	ldap5 := &gitlab.BasicUser{ID: 5, Username: "ldap5"}
+	ldap6 := &gitlab.BasicUser{ID: 6, Username: "ldap6"}
+
 	type test struct {
 		slackChanID string
-		updates     []string
+		updates     []*gitlab.BasicUser
 		got         map[string]cachedUser
+		unmatched   []*gitlab.BasicUser
 		err         error
 	}
 
@@ -458,31 +635,46 @@ func TestUpdateCache(t *testing.T) {
 		// Empty Cache
 		{
 			"A",
-			[]string{"test1"},
+			[]*gitlab.BasicUser{test1},
 			map[string]cachedUser{"test1": {user: userMeta{username: "test1", slackUserID: "1"}, expireTimestamp: timeExpire1.Unix()}},
 			nil,
+			nil,
 		},
 		// Update Cache
 		{
 			"B",
-			[]string{"test1"},
+			[]*gitlab.BasicUser{test1},
 			map[string]cachedUser{"test1": {user: userMeta{username: "test1", slackUserID: "1", status: "out sick"}, expireTimestamp: timeExpire8.Unix()}},
 			nil,
+			nil,
 		},
 		// Update Cache with new user
 		{
 			"C",
-			[]string{"test1"},
+			[]*gitlab.BasicUser{test1, test2},
 			map[string]cachedUser{
 				"test1": {user: userMeta{username: "test1", slackUserID: "1"}, expireTimestamp: timeExpire1.Unix()},
 				"test2": {user: userMeta{username: "test2", slackUserID: "2"}, expireTimestamp: timeExpire1.Unix()},
 			},
 			nil,
+			nil,
+		},
+		// Matched by email, mapping and unmatched
+		{
+			"H",
+			[]*gitlab.BasicUser{ldap4, ldap5, ldap6},
+			map[string]cachedUser{
+				"ldap4": {user: userMeta{username: "ldap4", slackUserID: "2"}, expireTimestamp: timeExpire1.Unix()},
+				"ldap5": {user: userMeta{username: "ldap5", slackUserID: "1"}, expireTimestamp: timeExpire1.Unix()},
+			},
+			[]*gitlab.BasicUser{ldap6},
+			nil,
 		},
 		// Error
 		{
 			"E",
-			[]string{"test1"},
+			[]*gitlab.BasicUser{test1},
+			nil,
 			nil,
 			errors.New("slack: failed to get user details: user_not_found\n"),
 		},
@@ -502,15 +694,16 @@ func TestUpdateCache(t *testing.T) {
 			cache.update(u, timeExpire1.Unix())
 		}
 
-		err := updateCache(&mockSlack, cache, mockMR, tc.updates, mockConfig)
+		unmatched, err := updateCache(&mockSlack, mockGitClient, newGitlabEmails(), cache, mockMR, tc.updates, []string{"1", "2"}, mockConfig)
 
 		if err != nil {
 			assert.Equal(t, err.Error(), tc.err.Error())
 			continue
 		}
 		assert.NoError(t, err)
+		assert.Equal(t, tc.unmatched, unmatched)
 
-		for _, v := range tc.updates {
+		for v := range tc.got {
 			assert.Equal(t, tc.got[v].user, cache.users[v].user)
 			assert.WithinDuration(t, time.Unix(tc.got[v].expireTimestamp, 0), time.Unix(cache.users[v].expireTimestamp, 0), 0)
 		}
@@ -518,6 +711,25 @@ func TestUpdateCache(t *testing.T) {
 
 }
 
+func TestGetUnmappedApprovers(t *testing.T) {
+	cache := newLocalCache()
+	timeExpire := time.Now().Add(time.Hour * 8)
+	cache.update(userMeta{username: "test1", slackUserID: "1"}, timeExpire.Unix())
+	cache.update(userMeta{username: "test2"}, timeExpire.Unix())
+	// expired entries keep their slack user id
+	cache.update(userMeta{username: "test3", slackUserID: "3"}, 0)
+
+	approvers := []*gitlab.BasicUser{
+		{ID: 1, Username: "test1"},
+		{ID: 2, Username: "test2"},
+		{ID: 3, Username: "test3"},
+		{ID: 4, Username: "test4"},
+	}
+
+	got := getUnmappedApprovers(cache, approvers)
+	assert.Equal(t, []*gitlab.BasicUser{approvers[1], approvers[3]}, got)
+}
+
 func TestGetSlackUserIDs(t *testing.T) {
 	mockMR := MockMergeRequest{
 		pathWithNamespace: "test/test",
@@ -558,11 +770,11 @@ func TestGetSlackUserIDs(t *testing.T) {
 
 func TestGetStatusTTL(t *testing.T) {
 	mockConfig := Config{
-		UserStatuses: map[string]int{
-			"":           1,
-			"out sick":   8,
-			"vactioning": 8,
-			"holiday":    8,
+		UserStatuses: map[string]UserStatus{
+			"":           {TTL: 1},
+			"out sick":   {TTL: 8},
+			"vactioning": {TTL: 8},
+			"holiday":    {TTL: 8},
 		},
 	}
 
@@ -583,7 +795,7 @@ func TestGetStatusTTL(t *testing.T) {
 
 	for _, tc := range tests {
 
-		got := getStatusTTL(mockConfig.UserStatuses, tc.status)
+		got := getStatusTTL(mockConfig.UserStatuses, tc.status, "")
 		assert.Equal(t, tc.expected, got)
 	}
 }
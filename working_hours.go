@@ -0,0 +1,125 @@
+// Working hours of approvers in their own slack timezone. When set for a group, approvers outside working hours are
+// only selected when there are not enough approvers inside working hours to meet the approvals required.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	// Run image does not include a timezone database, embedded so slack timezone names can be loaded
+	_ "time/tzdata"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xanzy/go-gitlab"
+)
+
+const workingHoursLayout = "15:04"
+
+var defaultWorkingDays = []string{"mon", "tue", "wed", "thu", "fri"}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// WorkingHours - Local start and end time of the working day for approvers in a group, an end before the start spans
+// midnight. Days default to monday to friday.
+type WorkingHours struct {
+	Start string   `yaml:"start"`
+	End   string   `yaml:"end"`
+	Days  []string `yaml:"days"`
+}
+
+// validate: check the start and end are times of day and each day is known
+func (wh WorkingHours) validate() error {
+	start, err := time.Parse(workingHoursLayout, wh.Start)
+	if err != nil {
+		return fmt.Errorf("'%s' working hours start must be a time of day such as 09:00.", wh.Start)
+	}
+	end, err := time.Parse(workingHoursLayout, wh.End)
+	if err != nil {
+		return fmt.Errorf("'%s' working hours end must be a time of day such as 17:30.", wh.End)
+	}
+	if start.Equal(end) {
+		return fmt.Errorf("'%s' working hours start and end must differ.", wh.Start)
+	}
+	for _, day := range wh.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("'%s' unknown working day.", day)
+		}
+	}
+	return nil
+}
+
+// contains: if the time in the passed location is inside working hours
+func (wh WorkingHours) contains(now time.Time, loc *time.Location) bool {
+	local := now.In(loc)
+
+	days := wh.Days
+	if len(days) == 0 {
+		days = defaultWorkingDays
+	}
+	working := false
+	for _, day := range days {
+		if weekdays[strings.ToLower(day)] == local.Weekday() {
+			working = true
+			break
+		}
+	}
+	if !working {
+		return false
+	}
+
+	start, errStart := time.Parse(workingHoursLayout, wh.Start)
+	end, errEnd := time.Parse(workingHoursLayout, wh.End)
+	if errStart != nil || errEnd != nil {
+		return true
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+	if endMinute < startMinute {
+		return minute >= startMinute || minute < endMinute
+	}
+	return minute >= startMinute && minute < endMinute
+}
+
+// location: the timezone of a cached user from slack, falling back to the offset when the timezone name is unknown.
+// Returns false when the user has no timezone.
+func (u userMeta) location() (*time.Location, bool) {
+	if u.timezone == "" {
+		return nil, false
+	}
+	loc, err := time.LoadLocation(u.timezone)
+	if err != nil {
+		return time.FixedZone(u.timezone, u.tzOffset), true
+	}
+	return loc, true
+}
+
+// splitByWorkingHours: split approvers into those inside and outside working hours in their own timezone. Approvers
+// without a known timezone are treated as inside working hours.
+func splitByWorkingHours(cache UserCache, approvers []*gitlab.BasicUser, wh WorkingHours, mr MergeRequests, now time.Time) ([]*gitlab.BasicUser, []*gitlab.BasicUser) {
+	logger := log.WithFields(log.Fields{"group": mr.Group(), "project_id": mr.ProjectID(), "merge_request_id": mr.MergeReqID()})
+
+	var inside, outside []*gitlab.BasicUser
+	for _, approver := range approvers {
+		cachedUser, _ := cache.read(approver.Username)
+		loc, ok := cachedUser.location()
+		if !ok || wh.contains(now, loc) {
+			inside = append(inside, approver)
+			continue
+		}
+
+		promAwayApprovers.WithLabelValues("out_of_hours", mr.Group()).Inc()
+		logger.WithFields(log.Fields{"reason": "out_of_hours", "username": approver.Username, "timezone": cachedUser.timezone}).Debug("user outside working hours.")
+		outside = append(outside, approver)
+	}
+	return inside, outside
+}
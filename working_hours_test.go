@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests
+
+func TestWorkingHoursValidate(t *testing.T) {
+	type test struct {
+		wh  WorkingHours
+		err error
+	}
+
+	tests := []test{
+		{wh: WorkingHours{Start: "09:00", End: "17:30"}, err: nil},
+		{wh: WorkingHours{Start: "22:00", End: "06:00", Days: []string{"Sun", "mon"}}, err: nil},
+		{wh: WorkingHours{Start: "9am", End: "17:30"}, err: errors.New("'9am' working hours start must be a time of day such as 09:00.")},
+		{wh: WorkingHours{Start: "09:00"}, err: errors.New("'' working hours end must be a time of day such as 17:30.")},
+		{wh: WorkingHours{Start: "09:00", End: "09:00"}, err: errors.New("'09:00' working hours start and end must differ.")},
+		{wh: WorkingHours{Start: "09:00", End: "17:30", Days: []string{"monday"}}, err: errors.New("'monday' unknown working day.")},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.err, tc.wh.validate())
+	}
+}
+
+func TestWorkingHoursContains(t *testing.T) {
+	edinburgh, _ := time.LoadLocation("Europe/London")
+	toronto, _ := time.LoadLocation("America/Toronto")
+	singapore, _ := time.LoadLocation("Asia/Singapore")
+
+	// Wednesday 10:00 in Edinburgh, 05:00 in Toronto and 17:00 in Singapore
+	wednesday := time.Date(2022, time.June, 15, 9, 0, 0, 0, time.UTC)
+	// Saturday 10:00 in Edinburgh
+	saturday := time.Date(2022, time.June, 18, 9, 0, 0, 0, time.UTC)
+
+	office := WorkingHours{Start: "09:00", End: "17:30"}
+	nights := WorkingHours{Start: "22:00", End: "06:00", Days: []string{"tue", "wed"}}
+
+	type test struct {
+		wh   WorkingHours
+		now  time.Time
+		loc  *time.Location
+		want bool
+	}
+
+	tests := []test{
+		{wh: office, now: wednesday, loc: edinburgh, want: true},
+		{wh: office, now: wednesday, loc: toronto, want: false},
+		{wh: office, now: wednesday, loc: singapore, want: true},
+		{wh: office, now: wednesday.Add(time.Minute * 30), loc: singapore, want: false},
+		{wh: office, now: saturday, loc: edinburgh, want: false},
+		// spanning midnight
+		{wh: nights, now: wednesday, loc: toronto, want: true},
+		{wh: nights, now: wednesday, loc: edinburgh, want: false},
+		{wh: nights, now: saturday, loc: toronto, want: false},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, tc.wh.contains(tc.now, tc.loc), tc.now.In(tc.loc).String())
+	}
+}
+
+func TestUserLocation(t *testing.T) {
+	now := time.Date(2022, time.June, 15, 9, 0, 0, 0, time.UTC)
+
+	type test struct {
+		u        userMeta
+		wantOK   bool
+		wantHour int
+	}
+
+	tests := []test{
+		{u: userMeta{timezone: "America/Toronto", tzOffset: -14400}, wantOK: true, wantHour: 5},
+		// unknown timezone name falls back to the offset
+		{u: userMeta{timezone: "Mars/Olympus_Mons", tzOffset: 28800}, wantOK: true, wantHour: 17},
+		{u: userMeta{}, wantOK: false},
+	}
+
+	for _, tc := range tests {
+		loc, ok := tc.u.location()
+		assert.Equal(t, tc.wantOK, ok)
+		if ok {
+			assert.Equal(t, tc.wantHour, now.In(loc).Hour())
+		}
+	}
+}